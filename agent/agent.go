@@ -0,0 +1,233 @@
+// Package agent keeps unlocked secret keys in memory, and serves them to
+// redact processes of the same user through a unix socket.
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/julian7/redact/securemem"
+)
+
+// Operations of agent requests
+const (
+	OpAdd    = "add"
+	OpGet    = "get"
+	OpRemove = "remove"
+	OpList   = "list"
+	OpStop   = "stop"
+
+	// DefaultTTL is the default idle time after which keys are forgotten
+	DefaultTTL = 15 * time.Minute
+)
+
+var (
+	ErrNotRunning     = errors.New("agent is not running")
+	ErrAlreadyRunning = errors.New("agent is already running")
+	ErrAgentFailed    = errors.New("agent request failed")
+	ErrKeyNotFound    = errors.New("key not found in agent")
+	ErrInvalidOp      = errors.New("invalid agent operation")
+	ErrInvalidTTL     = errors.New("invalid agent idle timeout")
+	ErrInsecureSock   = errors.New("insecure agent socket")
+)
+
+// Request is a request sent to the agent
+type Request struct {
+	Op string `json:"op"`
+	// Repo identifies a repository by its absolute git common dir
+	Repo string `json:"repo,omitempty"`
+	// Key is an unprotected secret key file
+	Key []byte `json:"key,omitempty"`
+}
+
+// Response is the agent's response to a request
+type Response struct {
+	Error   string  `json:"error,omitempty"`
+	Key     []byte  `json:"key,omitempty"`
+	Entries []Entry `json:"entries,omitempty"`
+}
+
+// Entry describes a key held by the agent
+type Entry struct {
+	Repo    string    `json:"repo"`
+	Expires time.Time `json:"expires"`
+}
+
+type entry struct {
+	key     *securemem.Buffer
+	expires time.Time
+}
+
+// Agent holds secret keys of repositories, and forgets them after they are
+// not used for TTL
+type Agent struct {
+	TTL  time.Duration
+	now  func() time.Time
+	mu   sync.Mutex
+	keys map[string]*entry
+	stop chan struct{}
+	once sync.Once
+}
+
+// New returns a new agent with an idle timeout
+func New(ttl time.Duration) (*Agent, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTTL, ttl)
+	}
+
+	return &Agent{
+		TTL:  ttl,
+		now:  time.Now,
+		keys: make(map[string]*entry),
+		stop: make(chan struct{}),
+	}, nil
+}
+
+// Handle serves a single request
+func (a *Agent) Handle(req *Request) *Response {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	a.expire(now)
+
+	switch req.Op {
+	case OpAdd:
+		if req.Repo == "" || len(req.Key) == 0 {
+			return &Response{Error: "missing repo or key"}
+		}
+
+		a.forget(req.Repo)
+		a.keys[req.Repo] = &entry{key: securemem.FromBytes(req.Key), expires: now.Add(a.TTL)}
+
+		return &Response{}
+	case OpGet:
+		item, ok := a.keys[req.Repo]
+		if !ok {
+			return &Response{Error: ErrKeyNotFound.Error()}
+		}
+
+		item.expires = now.Add(a.TTL)
+
+		return &Response{Key: bytes.Clone(item.key.Bytes())}
+	case OpRemove:
+		if req.Repo == "" {
+			for repo := range a.keys {
+				a.forget(repo)
+			}
+		} else {
+			a.forget(req.Repo)
+		}
+
+		return &Response{}
+	case OpList:
+		entries := make([]Entry, 0, len(a.keys))
+		for repo, item := range a.keys {
+			entries = append(entries, Entry{Repo: repo, Expires: item.expires})
+		}
+
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Repo < entries[j].Repo })
+
+		return &Response{Entries: entries}
+	case OpStop:
+		a.once.Do(func() { close(a.stop) })
+
+		return &Response{}
+	}
+
+	return &Response{Error: fmt.Sprintf("%s: %q", ErrInvalidOp, req.Op)}
+}
+
+// Serve serves requests on listener, until the context is cancelled, or
+// the agent is stopped. All keys are forgotten on return.
+func (a *Agent) Serve(ctx context.Context, listener net.Listener) error {
+	defer a.Handle(&Request{Op: OpRemove})
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		ticker := time.NewTicker(min(a.TTL, time.Minute))
+		defer ticker.Stop()
+
+		stop := a.stop
+
+		for {
+			select {
+			case <-ctx.Done():
+				listener.Close()
+
+				return
+			case <-stop:
+				stop = nil
+
+				cancel()
+			case <-ticker.C:
+				a.mu.Lock()
+				a.expire(a.now())
+				a.mu.Unlock()
+			}
+		}
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("accepting connection: %w", err)
+		}
+
+		go a.serveConn(conn)
+	}
+}
+
+func (a *Agent) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	if err := checkPeer(conn); err != nil {
+		return
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	req := &Request{}
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(req); err != nil {
+		_ = json.NewEncoder(conn).Encode(&Response{Error: err.Error()})
+
+		return
+	}
+
+	resp := a.Handle(req)
+	_ = json.NewEncoder(conn).Encode(resp)
+
+	securemem.Wipe(req.Key)
+	securemem.Wipe(resp.Key)
+}
+
+// expire forgets expired keys. It has to be called with the lock held.
+func (a *Agent) expire(now time.Time) {
+	for repo, item := range a.keys {
+		if !now.Before(item.expires) {
+			a.forget(repo)
+		}
+	}
+}
+
+// forget wipes, and removes a key. It has to be called with the lock held.
+func (a *Agent) forget(repo string) {
+	if item, ok := a.keys[repo]; ok {
+		item.key.Destroy()
+		delete(a.keys, repo)
+	}
+}
@@ -0,0 +1,203 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/julian7/redact/securemem"
+)
+
+func TestHandle(t *testing.T) { //nolint:funlen
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	agent, err := New(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent.now = func() time.Time { return now }
+
+	tt := []struct {
+		name    string
+		advance time.Duration
+		req     *Request
+		key     string
+		entries int
+		err     string
+	}{
+		{name: "get missing", req: &Request{Op: OpGet, Repo: "/a"}, err: ErrKeyNotFound.Error()},
+		{name: "add without key", req: &Request{Op: OpAdd, Repo: "/a"}, err: "missing repo or key"},
+		{name: "add a", req: &Request{Op: OpAdd, Repo: "/a", Key: []byte("key a")}},
+		{name: "add b", req: &Request{Op: OpAdd, Repo: "/b", Key: []byte("key b")}},
+		{name: "list", req: &Request{Op: OpList}, entries: 2},
+		{name: "get a", advance: 50 * time.Second, req: &Request{Op: OpGet, Repo: "/a"}, key: "key a"},
+		{name: "b expired", advance: 10 * time.Second, req: &Request{Op: OpGet, Repo: "/b"}, err: ErrKeyNotFound.Error()},
+		{name: "a is used", advance: 30 * time.Second, req: &Request{Op: OpGet, Repo: "/a"}, key: "key a"},
+		{name: "remove a", req: &Request{Op: OpRemove, Repo: "/a"}},
+		{name: "a removed", req: &Request{Op: OpGet, Repo: "/a"}, err: ErrKeyNotFound.Error()},
+		{name: "invalid", req: &Request{Op: "dump"}, err: `invalid agent operation: "dump"`},
+	}
+
+	for _, tc := range tt {
+		now = now.Add(tc.advance)
+		resp := agent.Handle(tc.req)
+
+		if resp.Error != tc.err {
+			t.Errorf("%s: unexpected error. Expected: %q, received: %q", tc.name, tc.err, resp.Error)
+		}
+
+		if string(resp.Key) != tc.key {
+			t.Errorf("%s: unexpected key. Expected: %q, received: %q", tc.name, tc.key, resp.Key)
+		}
+
+		if len(resp.Entries) != tc.entries {
+			t.Errorf("%s: unexpected entries: %+v", tc.name, resp.Entries)
+		}
+	}
+}
+
+func TestForgetWipes(t *testing.T) {
+	tt := []struct {
+		name    string
+		advance time.Duration
+		req     *Request
+		kept    int
+	}{
+		{name: "remove", req: &Request{Op: OpRemove, Repo: "/a"}},
+		{name: "remove all", req: &Request{Op: OpRemove}},
+		{name: "expire", advance: time.Minute, req: &Request{Op: OpList}},
+		{name: "replace", req: &Request{Op: OpAdd, Repo: "/a", Key: []byte("other")}, kept: 1},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+			agent, err := New(time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			agent.now = func() time.Time { return now }
+
+			before := securemem.Outstanding()
+			key := []byte("secret")
+			agent.Handle(&Request{Op: OpAdd, Repo: "/a", Key: key})
+
+			if !bytes.Equal(key, make([]byte, len(key))) {
+				t.Errorf("request key is not wiped: %q", key)
+			}
+
+			if received := securemem.Outstanding(); received != before+1 {
+				t.Errorf("key is not in guarded memory: %d buffers", received-before)
+			}
+
+			now = now.Add(tc.advance)
+			agent.Handle(tc.req)
+
+			if received := securemem.Outstanding(); received != before+tc.kept {
+				t.Errorf("key is not released: %d buffers", received-before)
+			}
+
+			agent.Handle(&Request{Op: OpRemove})
+
+			if received := securemem.Outstanding(); received != before {
+				t.Errorf("key is not released: %d buffers", received-before)
+			}
+		})
+	}
+}
+
+func TestNewInvalidTTL(t *testing.T) {
+	if _, err := New(0); !errors.Is(err, ErrInvalidTTL) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	dir, err := os.MkdirTemp("", "redact-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	sock := filepath.Join(dir, "run", "agent.sock")
+	client := &Client{Socket: sock}
+
+	if _, err := client.Get("/a"); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("unexpected error without agent: %v", err)
+	}
+
+	listener, err := Listen(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Listen(sock); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("unexpected error of second agent: %v", err)
+	}
+
+	agent, err := New(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+
+	go func() { done <- agent.Serve(context.Background(), listener) }()
+
+	if err := client.Add("/a", []byte("key a")); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := client.Get("/a")
+	if err != nil || string(key) != "key a" {
+		t.Errorf("unexpected key %q, error: %v", key, err)
+	}
+
+	if _, err := client.Get("/b"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unexpected error of missing key: %v", err)
+	}
+
+	if err := client.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error stopping agent: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent didn't stop")
+	}
+}
+
+func TestInsecureSocketDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("socket dir permissions are not checked on windows")
+	}
+
+	dir, err := os.MkdirTemp("", "redact-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Listen(filepath.Join(dir, "agent.sock")); !errors.Is(err, ErrInsecureSock) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
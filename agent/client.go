@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Client talks to the agent
+type Client struct {
+	Socket string
+}
+
+// NewClient returns a client of the agent at the default socket
+func NewClient() *Client {
+	return &Client{Socket: SocketPath()}
+}
+
+// Add stores the secret key of a repository in the agent
+func (c *Client) Add(repo string, key []byte) error {
+	_, err := c.call(&Request{Op: OpAdd, Repo: repo, Key: key})
+
+	return err
+}
+
+// Get returns the secret key of a repository from the agent
+func (c *Client) Get(repo string) ([]byte, error) {
+	resp, err := c.call(&Request{Op: OpGet, Repo: repo})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Key, nil
+}
+
+// Remove makes the agent forget the secret key of a repository, or all keys
+// if repo is empty
+func (c *Client) Remove(repo string) error {
+	_, err := c.call(&Request{Op: OpRemove, Repo: repo})
+
+	return err
+}
+
+// List returns repositories the agent holds keys of
+func (c *Client) List() ([]Entry, error) {
+	resp, err := c.call(&Request{Op: OpList})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Entries, nil
+}
+
+// Stop stops the agent
+func (c *Client) Stop() error {
+	_, err := c.call(&Request{Op: OpStop})
+
+	return err
+}
+
+func (c *Client) call(req *Request) (*Response, error) {
+	if err := checkSocketDir(c.Socket); err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("unix", c.Socket, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotRunning, err)
+	}
+
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("sending agent request: %w", err)
+	}
+
+	resp := &Response{}
+	if err := json.NewDecoder(conn).Decode(resp); err != nil {
+		return nil, fmt.Errorf("reading agent response: %w", err)
+	}
+
+	if resp.Error != "" {
+		if resp.Error == ErrKeyNotFound.Error() {
+			return nil, ErrKeyNotFound
+		}
+
+		return nil, fmt.Errorf("%w: %s", ErrAgentFailed, resp.Error)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package agent
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// checkOwner ensures a directory is owned by the current user, and it's not
+// accessible by others
+func checkOwner(name string, st fs.FileInfo) error {
+	if stat, ok := st.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%w: %s is owned by uid %d", ErrInsecureSock, name, stat.Uid)
+	}
+
+	if st.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%w: %s is accessible by others (%s)", ErrInsecureSock, name, st.Mode().Perm())
+	}
+
+	return nil
+}
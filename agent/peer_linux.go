@@ -0,0 +1,45 @@
+//go:build linux
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// checkPeer ensures the peer process runs as the current user
+func checkPeer(conn net.Conn) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("checking agent peer: %w", err)
+	}
+
+	var (
+		cred    *unix.Ucred
+		credErr error
+	)
+
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return fmt.Errorf("checking agent peer: %w", err)
+	}
+
+	if credErr != nil {
+		return fmt.Errorf("checking agent peer: %w", credErr)
+	}
+
+	if int(cred.Uid) != os.Getuid() {
+		return fmt.Errorf("%w: peer uid %d", ErrInsecureSock, cred.Uid)
+	}
+
+	return nil
+}
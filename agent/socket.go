@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// SocketEnv overrides the agent's socket path
+const SocketEnv = "REDACT_AGENT_SOCK"
+
+// SocketPath returns the agent's socket path. It is in a per-user runtime
+// directory, unless it's overridden by REDACT_AGENT_SOCK.
+func SocketPath() string {
+	if sock := os.Getenv(SocketEnv); sock != "" {
+		return sock
+	}
+
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "redact", "agent.sock")
+	}
+
+	return filepath.Join(os.TempDir(), "redact-"+strconv.Itoa(os.Getuid()), "agent.sock")
+}
+
+// Listen creates the agent's socket. The socket's directory is accessible
+// by the current user only.
+func Listen(sock string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(sock), 0700); err != nil {
+		return nil, fmt.Errorf("creating agent socket dir: %w", err)
+	}
+
+	if err := checkSocketDir(sock); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(sock); err == nil {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			conn.Close()
+
+			return nil, fmt.Errorf("%w at %s", ErrAlreadyRunning, sock)
+		}
+
+		if err := os.Remove(sock); err != nil {
+			return nil, fmt.Errorf("removing stale agent socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("creating agent socket: %w", err)
+	}
+
+	if err := os.Chmod(sock, 0600); err != nil {
+		listener.Close()
+
+		return nil, fmt.Errorf("setting agent socket permissions: %w", err)
+	}
+
+	return listener, nil
+}
+
+// checkSocketDir ensures the socket's directory can't be tampered with by
+// other users
+func checkSocketDir(sock string) error {
+	st, err := os.Stat(filepath.Dir(sock))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w: %w", ErrNotRunning, err)
+		}
+
+		return fmt.Errorf("checking agent socket dir: %w", err)
+	}
+
+	if !st.IsDir() {
+		return fmt.Errorf("%w: %s is not a directory", ErrInsecureSock, filepath.Dir(sock))
+	}
+
+	return checkOwner(filepath.Dir(sock), st)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/julian7/redact/agent"
+	"github.com/urfave/cli/v3"
+)
+
+func (rt *Runtime) agentCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "agent",
+		Usage: "Key agent commands",
+		Description: `In-memory key agent
+
+The key agent holds unlocked secret keys in memory, and serves them to redact
+processes of the same user through a unix socket. This way, secret keys
+don't have to be stored in the repository ("redact unlock --agent").
+Keys are forgotten after they are not used for the idle timeout.
+
+redact tries the agent before reading the secret key file. The socket is in
+$XDG_RUNTIME_DIR/redact, or in a per-user temporary directory, unless it's
+set by REDACT_AGENT_SOCK. Its directory must not be accessible by others.`,
+		Commands: []*cli.Command{
+			rt.agentRunCmd(),
+			rt.agentStartCmd(),
+			rt.agentStopCmd(),
+			rt.agentStatusCmd(),
+			rt.agentForgetCmd(),
+		},
+	}
+}
+
+func agentTTLFlag() cli.Flag {
+	return &cli.DurationFlag{
+		Name:    "ttl",
+		Usage:   "forget keys after they are not used for this long",
+		Value:   agent.DefaultTTL,
+		Sources: cli.EnvVars("REDACT_AGENT_TTL"),
+	}
+}
+
+func (rt *Runtime) agentRunCmd() *cli.Command {
+	return &cli.Command{
+		Name:   "run",
+		Usage:  "Runs key agent in the foreground",
+		Flags:  []cli.Flag{agentTTLFlag()},
+		Action: rt.agentRunDo,
+	}
+}
+
+func (rt *Runtime) agentStartCmd() *cli.Command {
+	return &cli.Command{
+		Name:   "start",
+		Usage:  "Starts key agent in the background",
+		Flags:  []cli.Flag{agentTTLFlag()},
+		Action: rt.agentStartDo,
+	}
+}
+
+func (rt *Runtime) agentStopCmd() *cli.Command {
+	return &cli.Command{
+		Name:   "stop",
+		Usage:  "Stops key agent, forgetting all keys",
+		Action: rt.agentStopDo,
+	}
+}
+
+func (rt *Runtime) agentStatusCmd() *cli.Command {
+	return &cli.Command{
+		Name:   "status",
+		Usage:  "Lists repositories the key agent holds keys of",
+		Action: rt.agentStatusDo,
+	}
+}
+
+func (rt *Runtime) agentForgetCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "forget",
+		Usage: "Makes key agent forget the current repository's key",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "forget keys of all repositories",
+			},
+		},
+		Action: rt.agentForgetDo,
+	}
+}
+
+func (rt *Runtime) agentRunDo(ctx context.Context, cmd *cli.Command) error {
+	keyAgent, err := agent.New(cmd.Duration("ttl"))
+	if err != nil {
+		return err
+	}
+
+	sock := agent.SocketPath()
+
+	listener, err := agent.Listen(sock)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rt.Infof("key agent listening on %s", sock)
+
+	if err := keyAgent.Serve(ctx, listener); err != nil {
+		return err
+	}
+
+	rt.Info("key agent stopped")
+
+	return nil
+}
+
+func (rt *Runtime) agentStartDo(_ context.Context, cmd *cli.Command) error {
+	client := agent.NewClient()
+
+	if _, err := client.List(); err == nil {
+		return fmt.Errorf("%w at %s", agent.ErrAlreadyRunning, client.Socket)
+	}
+
+	argv0, err := fullPath()
+	if err != nil {
+		return err
+	}
+
+	daemon := exec.Command(argv0, "agent", "run", "--ttl", cmd.Duration("ttl").String()) //nolint:gosec
+	detach(daemon)
+
+	if err := daemon.Start(); err != nil {
+		return fmt.Errorf("starting key agent: %w", err)
+	}
+
+	if err := daemon.Process.Release(); err != nil {
+		return fmt.Errorf("starting key agent: %w", err)
+	}
+
+	for range 50 {
+		if _, err := client.List(); err == nil {
+			rt.Infof("key agent started on %s", client.Socket)
+
+			return nil
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return fmt.Errorf("%w: agent didn't start", agent.ErrNotRunning)
+}
+
+func (rt *Runtime) agentStopDo(_ context.Context, _ *cli.Command) error {
+	if err := agent.NewClient().Stop(); err != nil {
+		return err
+	}
+
+	rt.Info("key agent stopped")
+
+	return nil
+}
+
+func (rt *Runtime) agentStatusDo(_ context.Context, _ *cli.Command) error {
+	entries, err := agent.NewClient().List()
+	if err != nil {
+		if errors.Is(err, agent.ErrNotRunning) {
+			rt.Info("key agent is not running")
+
+			return nil
+		}
+
+		return err
+	}
+
+	rt.Infof("key agent holds %d key%s", len(entries), map[bool]string{false: "s", true: ""}[len(entries) == 1])
+
+	for _, entry := range entries {
+		rt.Infof(" - %s (expires in %s)", entry.Repo, time.Until(entry.Expires).Round(time.Second))
+	}
+
+	return nil
+}
+
+func (rt *Runtime) agentForgetDo(_ context.Context, cmd *cli.Command) error {
+	var repo string
+
+	if !cmd.Bool("all") {
+		if err := rt.SetupRepo(); err != nil {
+			return err
+		}
+
+		repo = rt.CommonDir
+	}
+
+	if err := agent.NewClient().Remove(repo); err != nil {
+		return err
+	}
+
+	rt.Info("key agent forgot keys")
+
+	return nil
+}
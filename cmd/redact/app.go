@@ -55,8 +55,15 @@ The subsequent "git add" command will encrypt files matching this pattern.`,
 				Value:   true,
 				Usage:   "enforce file permission checks; use with caution!",
 			},
+			&cli.StringFlag{
+				Name:    "key-store",
+				Sources: cli.EnvVars("REDACT_KEY_STORE"),
+				Usage:   "secret key store: git, xdg, env[:NAME], or file:PATH (git config: redact.keyStore)",
+			},
 		},
 		Commands: []*cli.Command{
+			rt.agentCmd(),
+			rt.auditCmd(),
 			rt.gpgCmd(),
 			rt.extCmd(),
 			rt.gitCmd(),
@@ -81,7 +88,11 @@ func (rt *Runtime) GlobalConfig(ctx context.Context, cmd *cli.Command) (context.
 
 	rt.setLogLevel(strings.ToLower(cmd.String("verbosity")))
 
+	rt.StrictPermissions = optionSetting(cmd, "strict-permissions", "REDACT_STRICT")
 	rt.StrictPermissionChecks = cmd.Bool("strict-permissions")
+	rt.PassphraseFunc = rt.readPassphrase
+	rt.ExportPassphraseFunc = rt.readExportPassphrase
+	rt.KeyStore = optionSetting(cmd, "key-store", "REDACT_KEY_STORE")
 
 	return ctx, nil
 }
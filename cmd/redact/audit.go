@@ -0,0 +1,17 @@
+package main
+
+import "github.com/urfave/cli/v3"
+
+func (rt *Runtime) auditCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "audit",
+		Usage: "Audit commands",
+		Description: `Repository audits
+
+Audit commands look for secrets leaked in the repository, beyond the current
+status of files (see "redact status").`,
+		Commands: commands(
+			rt.auditHistoryCmd(),
+		),
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/julian7/redact/files"
+	"github.com/julian7/redact/gitutil"
+	"github.com/julian7/redact/repo"
+	"github.com/urfave/cli/v3"
+)
+
+// Audit record types
+const (
+	auditFinding    = "finding"
+	auditCheckpoint = "checkpoint"
+)
+
+// Audit issues
+const (
+	auditPlaintext    = "plaintext"
+	auditMissingEpoch = "missing-epoch"
+	auditUnreadable   = "unreadable"
+)
+
+// symlinkMode is the file mode of symbolic links in git trees. Filters don't
+// apply to them.
+const symlinkMode = 120000
+
+func (rt *Runtime) auditHistoryCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "history",
+		Usage:     "Scans commit history for leaked plaintext and undecryptable files",
+		ArgsUsage: "[rev-range...]",
+		Description: `Scan commit history
+
+This command walks commits of a revision range (HEAD by default, see
+"git rev-list" for syntax), oldest first. It evaluates gitattributes as of
+each commit, and reports blobs at paths with the redact filter, which are not
+encrypted ("plaintext"), which are encrypted with a key epoch missing from the
+secret key ("missing-epoch"), or which cannot be read ("unreadable"). Every
+blob is reported once at a path, in the earliest commit it's found.
+
+It writes JSON records, one per line: findings, and a checkpoint after every
+commit audited. With --output and --resume, it continues an interrupted audit
+of the same output file, skipping commits with checkpoints.
+
+It fails if there are findings.`,
+		Before: rt.LoadSecretKey,
+		Action: rt.auditHistoryDo,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Write records to a file instead of standard output",
+			},
+			&cli.BoolFlag{
+				Name:  "resume",
+				Value: false,
+				Usage: "Resume an audit of the output file",
+			},
+		},
+	}
+}
+
+// auditRecord is a record of the history audit: a finding, or a checkpoint
+// of an audited commit
+type auditRecord struct {
+	Type    string `json:"type"`
+	Commit  string `json:"commit"`
+	Path    string `json:"path,omitempty"`
+	Blob    string `json:"blob,omitempty"`
+	Issue   string `json:"issue,omitempty"`
+	Epoch   uint32 `json:"epoch,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// blobState is the encryption state of a blob
+type blobState struct {
+	encrypted bool
+	epoch     uint32
+	err       error
+}
+
+type historyAudit struct {
+	key     *files.SecretKey
+	encoder *json.Encoder
+	// audited holds commits with checkpoints
+	audited map[string]bool
+	// reported holds findings by path, blob, and issue
+	reported map[string]bool
+	// blobs caches states of blobs by object ID
+	blobs map[string]*blobState
+	// filtered caches paths with the redact filter by .gitattributes files
+	// in effect
+	filtered map[string]map[string]bool
+	findings int
+}
+
+func (rt *Runtime) auditHistoryDo(_ context.Context, cmd *cli.Command) error {
+	revs := cmd.Args().Slice()
+	if len(revs) == 0 {
+		revs = []string{"HEAD"}
+	}
+
+	audit := &historyAudit{
+		key:      rt.SecretKey,
+		audited:  map[string]bool{},
+		reported: map[string]bool{},
+		blobs:    map[string]*blobState{},
+		filtered: map[string]map[string]bool{},
+	}
+
+	var writer io.Writer = os.Stdout
+
+	outFile := cmd.String("output")
+	if outFile != "" {
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if cmd.Bool("resume") {
+			flags = os.O_CREATE | os.O_RDWR
+		}
+
+		file, err := os.OpenFile(outFile, flags, 0600)
+		if err != nil {
+			return fmt.Errorf("opening audit log: %w", err)
+		}
+
+		defer file.Close()
+
+		if cmd.Bool("resume") {
+			if err := audit.resume(file); err != nil {
+				return err
+			}
+		}
+
+		writer = file
+	} else if cmd.Bool("resume") {
+		return fmt.Errorf("%w: --resume requires --output", ErrOptions)
+	}
+
+	audit.encoder = json.NewEncoder(writer)
+
+	commits, err := gitutil.RevList(revs)
+	if err != nil {
+		return err
+	}
+
+	if len(audit.audited) > 0 {
+		rt.Infof("resuming audit: %d commits audited already", len(audit.audited))
+	}
+
+	for _, commit := range commits {
+		if audit.audited[commit] {
+			continue
+		}
+
+		if err := audit.auditCommit(commit); err != nil {
+			return err
+		}
+	}
+
+	if audit.findings > 0 {
+		return fmt.Errorf("%w: %d finding%s", ErrAuditFindings, audit.findings, plural[audit.findings == 1])
+	}
+
+	return nil
+}
+
+// resume reads records of an interrupted audit, and prepares the file for
+// appending. An incomplete last record is dropped.
+func (a *historyAudit) resume(file *os.File) error {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return fmt.Errorf("reading audit log: %w", err)
+	}
+
+	end := bytes.LastIndexByte(data, '\n') + 1
+
+	if err := file.Truncate(int64(end)); err != nil {
+		return fmt.Errorf("truncating audit log: %w", err)
+	}
+
+	if _, err := file.Seek(int64(end), io.SeekStart); err != nil {
+		return fmt.Errorf("%w: %w", ErrSeek, err)
+	}
+
+	for line := range bytes.Lines(data[:end]) {
+		var record auditRecord
+
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("%w: %w", ErrAuditLog, err)
+		}
+
+		switch record.Type {
+		case auditCheckpoint:
+			a.audited[record.Commit] = true
+		case auditFinding:
+			a.reported[findingKey(record.Path, record.Blob, record.Issue)] = true
+			a.findings++
+		default:
+			return fmt.Errorf("%w: unknown record type %q", ErrAuditLog, record.Type)
+		}
+	}
+
+	return nil
+}
+
+// auditCommit reports findings of a commit, and writes its checkpoint
+func (a *historyAudit) auditCommit(commit string) error {
+	tree, err := gitutil.LsTreeFull(commit)
+	if err != nil {
+		return err
+	}
+
+	filtered, err := a.filteredPaths(commit, tree)
+	if err != nil {
+		return err
+	}
+
+	blobs := []*gitutil.TreeEntry{}
+	unread := []*gitutil.TreeEntry{}
+
+	for _, entry := range tree {
+		if entry.Type != "blob" || entry.Access == symlinkMode || !filtered[entry.Filename] {
+			continue
+		}
+
+		blobs = append(blobs, entry)
+
+		objectID := hex.EncodeToString(entry.ObjectID)
+		if _, ok := a.blobs[objectID]; !ok {
+			a.blobs[objectID] = nil
+			unread = append(unread, entry)
+		}
+	}
+
+	states, err := runWorkers(unread, func(cat *gitutil.CatFile, entry *gitutil.TreeEntry) *blobState {
+		hdr, err := blobHeader(cat, a.key, entry.ObjectID)
+		if err != nil {
+			return &blobState{err: err}
+		}
+
+		if hdr == nil {
+			return &blobState{}
+		}
+
+		return &blobState{encrypted: true, epoch: hdr.Epoch}
+	})
+	if err != nil {
+		return err
+	}
+
+	for idx, entry := range unread {
+		a.blobs[hex.EncodeToString(entry.ObjectID)] = states[idx]
+	}
+
+	for _, entry := range blobs {
+		if err := a.check(commit, entry); err != nil {
+			return err
+		}
+	}
+
+	return a.write(&auditRecord{Type: auditCheckpoint, Commit: commit})
+}
+
+// check reports a finding of a blob at a path, unless it's reported already
+func (a *historyAudit) check(commit string, entry *gitutil.TreeEntry) error {
+	objectID := hex.EncodeToString(entry.ObjectID)
+	state := a.blobs[objectID]
+	record := &auditRecord{Type: auditFinding, Commit: commit, Path: entry.Filename, Blob: objectID}
+
+	switch {
+	case state.err != nil:
+		record.Issue = auditUnreadable
+		record.Message = state.err.Error()
+	case !state.encrypted:
+		record.Issue = auditPlaintext
+		record.Message = "not encrypted"
+	default:
+		if _, err := a.key.Key(state.epoch); err == nil {
+			return nil
+		}
+
+		record.Issue = auditMissingEpoch
+		record.Epoch = state.epoch
+		record.Message = fmt.Sprintf("encrypted with key epoch %d, missing from the secret key", state.epoch)
+	}
+
+	key := findingKey(record.Path, record.Blob, record.Issue)
+	if a.reported[key] {
+		return nil
+	}
+
+	a.reported[key] = true
+	a.findings++
+
+	return a.write(record)
+}
+
+// filteredPaths returns paths of a commit with the redact filter. Results are
+// cached by .gitattributes files of the commit, therefore attributes are
+// checked only for paths not seen with the same .gitattributes files.
+func (a *historyAudit) filteredPaths(commit string, tree []*gitutil.TreeEntry) (map[string]bool, error) {
+	attrFiles := []string{}
+
+	for _, entry := range tree {
+		if path.Base(entry.Filename) == repo.GitAttributesFile {
+			attrFiles = append(attrFiles, entry.Filename+":"+hex.EncodeToString(entry.ObjectID))
+		}
+	}
+
+	slices.Sort(attrFiles)
+	attrsKey := strings.Join(attrFiles, "\000")
+
+	filtered, ok := a.filtered[attrsKey]
+	if !ok {
+		filtered = map[string]bool{}
+		a.filtered[attrsKey] = filtered
+	}
+
+	unchecked := []string{}
+
+	for _, entry := range tree {
+		if _, ok := filtered[entry.Filename]; !ok && entry.Type == "blob" {
+			unchecked = append(unchecked, entry.Filename)
+		}
+	}
+
+	attrs, err := gitutil.CheckAttrsAt(commit, unchecked, "filter")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range unchecked {
+		filtered[name] = attrs[name]["filter"] == repo.AttrName
+	}
+
+	return filtered, nil
+}
+
+func (a *historyAudit) write(record *auditRecord) error {
+	if err := a.encoder.Encode(record); err != nil {
+		return fmt.Errorf("writing audit log: %w", err)
+	}
+
+	return nil
+}
+
+func findingKey(name, objectID, issue string) string {
+	return strings.Join([]string{name, objectID, issue}, "\000")
+}
@@ -10,4 +10,9 @@ var (
 	ErrSeek              = errors.New("cannot return to start of file")
 	ErrNoSuitableKey     = errors.New("no suitable key found")
 	ErrKeyAlreadyExists  = errors.New("secret key already exists")
+	ErrNoKeyBackups      = errors.New("key store does not keep backups")
+	ErrEpochInUse        = errors.New("key epoch is still in use")
+	ErrAuditFindings     = errors.New("history audit found problems")
+	ErrAuditLog          = errors.New("invalid audit log")
+	ErrAgentOnlyKey      = errors.New("secret key is in the key agent only")
 )
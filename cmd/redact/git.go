@@ -10,10 +10,13 @@ func (rt *Runtime) gitCmd() *cli.Command {
 
 Redact interacts with git using gitattributes(5), through filter and diff
 settings. Unlocked repositories are also configured to run these redact
-commands for data conversion.`,
+commands for data conversion. Git versions supporting long running filter
+processes use "redact git filter-process" instead of starting a clean or
+smudge filter for each file.`,
 		Commands: []*cli.Command{
 			rt.gitCleanCmd(),
 			rt.gitDiffCmd(),
+			rt.gitFilterProcessCmd(),
 			rt.gitSmudgeCmd(),
 		},
 	}
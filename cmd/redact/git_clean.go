@@ -4,14 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
-	"math"
 	"os"
-	"strings"
+	"strconv"
 
 	"github.com/julian7/redact/encoder"
 	"github.com/julian7/redact/files"
 	"github.com/julian7/redact/gitutil"
+	"github.com/julian7/redact/repo"
 	"github.com/urfave/cli/v3"
 )
 
@@ -25,117 +26,536 @@ contents to standard out. You can set specific epoch (key number), encoding
 type for this process, or you can also take an already existing, encrypted
 file in the git repository, to be used as a template.
 
-To enforce encoding type, set "git.clean.type" in the config file, or set
-REDACT_GIT_CLEAN_TYPE environment variable with encryption name (case
-insensitive).
+To enforce encoding type, set REDACT_GIT_CLEAN_TYPE environment variable, or
+--type option with encryption name (case insensitive). Otherwise, files keep
+their current encoding type, and new files are encrypted with the "encoding"
+of repo settings (.redact/settings.json), or AES256-GCM96.
 
-Currently only AES256-GCM96 (default) and ChaCha20-Poly1305 encryptions are
-supported. According to [Go's automatic cipher suite
-ordering](https://go.dev/blog/tls-cipher-suites) blog post, the only two
-viable encryptions are the aforementioned two. CPUs with AES-NI support
-go just fine with AES256-GCM96, but when used in environments with no
+Settings are taken from environment variables, command line options, (where
+applicable) gitattributes and git config, repo settings, and built-in
+defaults, in this order of precedence.
+
+Files encrypted with key epochs below "min_epoch" of repo settings are
+re-encrypted with the latest key. Explicitly requesting such an epoch is an
+error.
+
+Supported encryptions are AES256-GCM96 (default), ChaCha20-Poly1305,
+XChaCha20-Poly1305, and AES256-GCM-SIV. According to [Go's automatic cipher
+suite ordering](https://go.dev/blog/tls-cipher-suites) blog post, the only
+two viable encryptions are AES-GCM and ChaCha20-Poly1305. CPUs with AES-NI
+support go just fine with AES256-GCM96, but when used in environments with no
 hardware support, ChaCha20-Poly1305 is the better choice.
+
+XChaCha20-Poly1305 uses 192 bit nonces, and AES256-GCM-SIV is resistant to
+nonce misuse, which makes them better fits for deterministic encryption.
+
+Files can be bound to their paths, with REDACT_GIT_CLEAN_BIND_PATH
+environment variable (or --bind-path option), the "redact-bind-path"
+gitattribute, or the "redact.bindPath" git config option, in this order of
+precedence. Otherwise, files keep their current path binding setting. Path
+bound files can be decrypted only at the path they were encrypted for, which
+is provided by --file.
+
+Plaintext can be padded before encryption, to hide its exact size. Padding
+schemes are "pow2" (next power of two), "block:N" (multiple of N bytes),
+"padme" (Padmé, at most 12% overhead), and "none". The scheme is set by
+REDACT_GIT_CLEAN_PADDING environment variable (or --padding option), the
+"redact-padding" gitattribute (like "redact-padding=pow2"; set without value
+means "padme"), the "redact.padding" git config option, or "padding" of repo
+settings, in this order of precedence. Files are not padded by default.
+
+Plaintext can be compressed before padding and encryption, which lets large
+text files take less space. Compression schemes are "deflate" (optionally
+with minimum file size, like "deflate:4096"; files smaller than 1024 bytes
+are not compressed by default), and "none". The scheme is set by
+REDACT_GIT_CLEAN_COMPRESS environment variable (or --compress option), the
+"redact-compress" gitattribute (set without value means "deflate"), the
+"redact.compress" git config option, or "compression" of repo settings, in
+this order of precedence. Files are not compressed by default. Compression
+reveals how well a file compresses, therefore it should be turned off for
+files whose sizes are meant to be hidden by padding.
 `,
 		Before: rt.LoadSecretKey,
 		Action: rt.gitCleanDo,
-		Flags: []cli.Flag{
-			&cli.UintFlag{
-				Name:    "epoch",
-				Aliases: []string{"e"},
-				Value:   0,
-				Usage:   "Use specific key epoch (by default it uses the latest key)",
-				Sources: cli.EnvVars("REDACT_GIT_CLEAN_EPOCH"),
-			},
-			&cli.StringFlag{
-				Name:    "type",
-				Aliases: []string{"t"},
-				Usage:   "Use specific `encoding` type (aes256-gcm96 (default) or chacha20-poly1305)",
-				Sources: cli.EnvVars("REDACT_GIT_CLEAN_TYPE"),
-			},
+		Flags: append(
+			cleanFlags(),
 			&cli.StringFlag{
 				Name:    "file",
 				Aliases: []string{"f"},
 				Usage:   "file path being filtered; --epoch and --type overwrites",
 			},
+		),
+	}
+}
+
+// cleanFlags returns flags controlling encryption, shared by clean filters
+func cleanFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.UintFlag{
+			Name:    "epoch",
+			Aliases: []string{"e"},
+			Value:   0,
+			Usage:   "Use specific key epoch (by default it uses the latest key)",
+			Sources: cli.EnvVars("REDACT_GIT_CLEAN_EPOCH"),
+		},
+		&cli.StringFlag{
+			Name:    "type",
+			Aliases: []string{"t"},
+			Usage:   "Use specific `encoding` type (aes256-gcm96 (default), chacha20-poly1305, xchacha20-poly1305, or aes256-gcm-siv)",
+			Sources: cli.EnvVars("REDACT_GIT_CLEAN_TYPE"),
+		},
+		&cli.BoolFlag{
+			Name:    "bind-path",
+			Usage:   "Bind encrypted files to their paths",
+			Sources: cli.EnvVars("REDACT_GIT_CLEAN_BIND_PATH"),
+		},
+		&cli.StringFlag{
+			Name:    "padding",
+			Usage:   "Pad files with `scheme` (none, pow2, block:N, or padme)",
+			Sources: cli.EnvVars("REDACT_GIT_CLEAN_PADDING"),
+		},
+		&cli.StringFlag{
+			Name:    "compress",
+			Usage:   "Compress files with `scheme` (none, deflate, or deflate:MINSIZE)",
+			Sources: cli.EnvVars("REDACT_GIT_CLEAN_COMPRESS"),
 		},
 	}
 }
 
-func (rt *Runtime) gitCleanDo(_ context.Context, cmd *cli.Command) error {
-	keyEpoch := uint32(0)
-	encType := encoder.TypeAES256GCM96
+// cleanConfig contains encryption overrides of clean filters
+type cleanConfig struct {
+	epoch           uint32
+	epochSet        bool
+	minEpoch        uint32
+	encType         uint32
+	typeSet         bool
+	defaultType     uint32
+	bindPath        bool
+	bindPathSet     bool
+	repoBindPath    bool
+	repoBindPathSet bool
+	padding         *files.Padding
+	repoPadding     *files.Padding
+	defaultPadding  *files.Padding
+	compression     *files.Compression
+	repoCompression *files.Compression
+	defaultCompress *files.Compression
+}
 
-	if cmd.IsSet("file") {
-		fname := cmd.String("file")
+// newCleanConfig reads encryption settings from environment variables,
+// command line options, git config, and repo settings
+func (rt *Runtime) newCleanConfig(cmd *cli.Command) (*cleanConfig, error) {
+	var err error
+
+	conf := &cleanConfig{defaultType: encoder.TypeAES256GCM96}
+	settings := rt.Settings
+
+	if settings == nil {
+		settings = &repo.Settings{}
+	}
+
+	if conf.minEpoch, err = rt.MinEpoch(); err != nil {
+		return nil, err
+	}
 
-		hdr, err := rt.hdrByFilename(fname)
+	if epoch := optionSetting(cmd, "epoch", "REDACT_GIT_CLEAN_EPOCH"); epoch.Set {
+		value, err := strconv.ParseUint(epoch.Value, 10, 32)
 		if err != nil {
-			if !errors.Is(err, fs.ErrNotExist) {
-				rt.Warnf("unable to determine epoch from filename: %s", err.Error())
-			}
-		} else {
-			keyEpoch = hdr.Epoch
-			encType = hdr.Encoding
+			return nil, fmt.Errorf("%w: invalid epoch value %q", ErrOptions, epoch.Value)
 		}
+
+		conf.epoch = uint32(value)
+		conf.epochSet = value > 0
 	}
 
-	if cmd.IsSet("epoch") {
-		epoch := cmd.Uint("epoch")
-		if epoch > math.MaxUint32 {
-			return fmt.Errorf("%w: epoch value %d is too large", ErrOptions, epoch)
+	if encType := optionSetting(cmd, "type", "REDACT_GIT_CLEAN_TYPE"); encType.Set && encType.Value != "" {
+		conf.encType, err = encoder.FindEncoder(encType.Value)
+		if err != nil {
+			return nil, fmt.Errorf("finding encoding %q: %w", encType.Value, err)
 		}
 
-		keyEpoch = uint32(epoch)
+		conf.typeSet = true
+	} else if settings.Encoding != "" {
+		conf.defaultType, err = encoder.FindEncoder(settings.Encoding)
+		if err != nil {
+			return nil, fmt.Errorf("finding encoding %q: %w", settings.Encoding, err)
+		}
 	}
 
-	if keyEpoch == 0 {
-		keyEpoch = rt.LatestKey
+	if bindPath := optionSetting(cmd, "bind-path", "REDACT_GIT_CLEAN_BIND_PATH"); bindPath.Set {
+		conf.bindPath, err = strconv.ParseBool(bindPath.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid bind path value %q", ErrOptions, bindPath.Value)
+		}
+
+		conf.bindPathSet = true
 	}
 
-	encTypeName := cmd.String("type")
-	if encTypeName != "" {
-		var err error
+	conf.repoBindPath, conf.repoBindPathSet, err = gitutil.GitConfigBool(repo.ConfigBindPath)
+	if err != nil {
+		return nil, err
+	}
 
-		encType, err = encoder.FindEncoder(encTypeName)
-		if err != nil {
-			return fmt.Errorf("finding encoding %q: %w", encTypeName, err)
+	if conf.padding, err = parseSetting(
+		optionSetting(cmd, "padding", "REDACT_GIT_CLEAN_PADDING"),
+		files.ParsePadding,
+	); err != nil {
+		return nil, err
+	}
+
+	if conf.repoPadding, err = parseGitConfig(repo.ConfigPadding, files.ParsePadding); err != nil {
+		return nil, err
+	}
+
+	if conf.defaultPadding, err = parseSetting(repo.StringSetting(settings.Padding), files.ParsePadding); err != nil {
+		return nil, err
+	}
+
+	if conf.compression, err = parseSetting(
+		optionSetting(cmd, "compress", "REDACT_GIT_CLEAN_COMPRESS"),
+		files.ParseCompression,
+	); err != nil {
+		return nil, err
+	}
+
+	if conf.repoCompression, err = parseGitConfig(repo.ConfigCompress, files.ParseCompression); err != nil {
+		return nil, err
+	}
+
+	if conf.defaultCompress, err = parseSetting(
+		repo.StringSetting(settings.Compression),
+		files.ParseCompression,
+	); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// parseSetting parses a setting, if it is set
+func parseSetting[T any](setting repo.Setting, parse func(string) (T, error)) (*T, error) {
+	if !setting.Set {
+		return nil, nil
+	}
+
+	value, err := parse(setting.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &value, nil
+}
+
+// parseGitConfig parses a git config option, if it is set
+func parseGitConfig[T any](key string, parse func(string) (T, error)) (*T, error) {
+	value, ok, err := gitutil.GitConfigGet(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := parseSetting(repo.Setting{Value: value, Set: ok}, parse)
+	if err != nil {
+		return nil, fmt.Errorf("%s config: %w", key, err)
+	}
+
+	return ret, nil
+}
+
+func (rt *Runtime) gitCleanDo(_ context.Context, cmd *cli.Command) error {
+	var hdr *files.FileHeader
+
+	conf, err := rt.newCleanConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	filePath := cmd.String("file")
+
+	if cmd.IsSet("file") {
+		hdr, err = rt.hdrByFilename(filePath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			rt.Warnf("unable to determine epoch from filename: %s", err.Error())
 		}
 	}
 
-	if err := rt.Encode(encType, keyEpoch, os.Stdin, os.Stdout); err != nil {
+	return rt.clean(conf, filePath, hdr, os.Stdin, os.Stdout)
+}
+
+// clean encrypts a file. Encoding type, key epoch, and path binding are taken
+// from the file's current header (if any), unless they are overridden.
+func (rt *Runtime) clean(
+	conf *cleanConfig,
+	filePath string,
+	hdr *files.FileHeader,
+	reader io.Reader,
+	writer io.Writer,
+) error {
+	attrs := rt.fileAttrs(filePath)
+
+	encType, err := chooseEncoding(conf, attrs[repo.AttrCipher], hdr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	keyEpoch, err := rt.chooseEpoch(conf, attrs[repo.AttrEpochMin], filePath, hdr)
+	if err != nil {
+		return err
+	}
+
+	opts, err := fileOptions(conf, filePath, attrs, hdr)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return rt.EncodeWithOptions(encType, keyEpoch, opts, reader, writer)
+}
+
+// fileAttrs returns redact gitattributes of a file
+func (rt *Runtime) fileAttrs(filePath string) map[string]string {
+	if filePath == "" {
+		return map[string]string{}
+	}
+
+	attrs, err := gitutil.CheckAttr(filePath, repo.FileAttrs...)
+	if err != nil {
+		rt.Warnf("unable to determine file attributes: %s", err.Error())
+
+		return map[string]string{}
+	}
+
+	return attrs
+}
+
+// chooseEncoding decides the encoding type of a file
+func chooseEncoding(conf *cleanConfig, attr string, hdr *files.FileHeader) (uint32, error) {
+	if conf.typeSet {
+		return conf.encType, nil
+	}
+
+	encType, ok, err := repo.ParseCipherAttr(attr)
+	if err != nil || ok {
+		return encType, err
+	}
+
+	if hdr != nil {
+		return hdr.Encoding, nil
+	}
+
+	return conf.defaultType, nil
+}
+
+// chooseEpoch decides the key epoch of a file. Files below the minimum epoch
+// (of repo settings, git config, environment, or of the file's
+// gitattributes), encrypted with a retired epoch, or with a key not available
+// locally are upgraded to the latest key.
+func (rt *Runtime) chooseEpoch(conf *cleanConfig, attr string, filePath string, hdr *files.FileHeader) (uint32, error) {
+	keyEpoch := uint32(0)
+
+	if hdr != nil {
+		keyEpoch = hdr.Epoch
+	}
+
+	if conf.epochSet {
+		keyEpoch = conf.epoch
+	}
+
+	if keyEpoch == 0 {
+		keyEpoch = rt.LatestKey
+	}
+
+	if rt.Settings.Retired(keyEpoch) {
+		if conf.epochSet || rt.Settings.Retired(rt.LatestKey) {
+			return 0, fmt.Errorf("%w: key epoch %d is retired", ErrNoSuitableKey, keyEpoch)
+		}
+
+		rt.Warnf("key epoch %d of %q is retired, using epoch %d", keyEpoch, filePath, rt.LatestKey)
+
+		keyEpoch = rt.LatestKey
+	}
+
+	if _, ok := rt.Keys[keyEpoch]; !ok && !conf.epochSet {
+		rt.Warnf("key epoch %d of %q is not available, using epoch %d", keyEpoch, filePath, rt.LatestKey)
+
+		keyEpoch = rt.LatestKey
+	}
+
+	minEpoch, _, err := repo.ParseEpochMinAttr(attr)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	minEpoch = max(minEpoch, conf.minEpoch)
+
+	if keyEpoch >= minEpoch {
+		return keyEpoch, nil
+	}
+
+	if conf.epochSet {
+		return 0, fmt.Errorf("%w: key epoch %d is below minimum epoch %d", ErrOptions, keyEpoch, minEpoch)
+	}
+
+	if rt.LatestKey < minEpoch {
+		return 0, fmt.Errorf("%w: latest key epoch %d is below minimum epoch %d", ErrNoSuitableKey, rt.LatestKey, minEpoch)
+	}
+
+	rt.Warnf("key epoch %d of %q is below minimum epoch %d, using epoch %d", keyEpoch, filePath, minEpoch, rt.LatestKey)
+
+	return rt.LatestKey, nil
+}
+
+// fileOptions decides per-file encoding options, from command line options,
+// gitattributes, git config, and the file's current header
+func fileOptions(
+	conf *cleanConfig,
+	filePath string,
+	attrs map[string]string,
+	hdr *files.FileHeader,
+) (*files.FileOptions, error) {
+	padding, err := choosePadding(conf, attrs[repo.AttrPadding])
+	if err != nil {
+		return nil, fmt.Errorf("%s attribute of %s: %w", repo.AttrPadding, filePath, err)
+	}
+
+	compression, err := chooseCompression(conf, attrs[repo.AttrCompress])
+	if err != nil {
+		return nil, fmt.Errorf("%s attribute of %s: %w", repo.AttrCompress, filePath, err)
+	}
+
+	return &files.FileOptions{
+		Path:        filePath,
+		BindPath:    chooseBindPath(conf, attrs[repo.AttrBindPath], hdr),
+		Padding:     padding,
+		Compression: compression,
+	}, nil
+}
+
+// chooseBindPath decides whether a file should be bound to its path. Without
+// overrides, files keep path binding of their current version.
+func chooseBindPath(conf *cleanConfig, attr string, hdr *files.FileHeader) bool {
+	if conf.bindPathSet {
+		return conf.bindPath
+	}
+
+	switch attr {
+	case gitutil.AttrSet, "true":
+		return true
+	case gitutil.AttrUnset, "false":
+		return false
+	}
+
+	if conf.repoBindPathSet {
+		return conf.repoBindPath
+	}
+
+	return hdr != nil && hdr.PathBound()
+}
+
+// choosePadding decides the padding scheme of a file
+func choosePadding(conf *cleanConfig, attr string) (files.Padding, error) {
+	if conf.padding != nil {
+		return *conf.padding, nil
+	}
+
+	switch attr {
+	case gitutil.AttrSet:
+		return files.Padding{Scheme: files.PaddingPadme}, nil
+	case gitutil.AttrUnset:
+		return files.Padding{Scheme: files.PaddingNone}, nil
+	case gitutil.AttrUnspecified, "":
+	default:
+		return files.ParsePadding(attr)
+	}
+
+	if conf.repoPadding != nil {
+		return *conf.repoPadding, nil
+	}
+
+	if conf.defaultPadding != nil {
+		return *conf.defaultPadding, nil
+	}
+
+	return files.Padding{Scheme: files.PaddingNone}, nil
+}
+
+// chooseCompression decides the compression scheme of a file
+func chooseCompression(conf *cleanConfig, attr string) (files.Compression, error) {
+	if conf.compression != nil {
+		return *conf.compression, nil
+	}
+
+	switch attr {
+	case gitutil.AttrSet:
+		return files.ParseCompression("deflate")
+	case gitutil.AttrUnset:
+		return files.Compression{Scheme: files.CompressionNone}, nil
+	case gitutil.AttrUnspecified, "":
+	default:
+		return files.ParseCompression(attr)
+	}
+
+	if conf.repoCompression != nil {
+		return *conf.repoCompression, nil
+	}
+
+	if conf.defaultCompress != nil {
+		return *conf.defaultCompress, nil
+	}
+
+	return files.Compression{Scheme: files.CompressionNone}, nil
 }
 
+// hdrByFilename returns the file header of a file's current version: the one
+// staged in the index, or the one in HEAD. The index is checked first, as it
+// follows renames (like "git mv"), which keeps path binding of moved files.
+// Plaintext files have no headers.
 func (rt *Runtime) hdrByFilename(filename string) (*files.FileHeader, error) {
 	if filename == "" {
 		return nil, fs.ErrNotExist
 	}
 
-	files, err := gitutil.LsTree("HEAD", []string{filename})
+	objectID, err := currentObject(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, f := range files {
-		if diff := strings.Compare(f.Filename, filename); diff != 0 {
-			continue
-		}
+	cat, err := gitutil.NewCatFile()
+	if err != nil {
+		return nil, err
+	}
 
-		fReader, err := gitutil.Cat(f.ObjectID)
-		if err != nil {
-			return nil, err
-		}
+	defer cat.Close()
+
+	return blobHeader(cat, rt.SecretKey, objectID)
+}
 
-		hdr, err := rt.FileStatus(fReader)
-		if err == nil {
-			return hdr, nil
+// currentObject returns the object ID of a file in the index, or in HEAD if
+// it's not staged
+func currentObject(filename string) ([]byte, error) {
+	index, err := gitutil.LsFiles([]string{":(literal)" + filename})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range index.Items {
+		if entry.Status != gitutil.StatusOther && entry.Stage == 0 && entry.Name == filename {
+			return entry.SHA1[:], nil
 		}
+	}
+
+	if !gitutil.HasHead() {
+		return nil, fs.ErrNotExist
+	}
 
+	tree, err := gitutil.LsTree("HEAD", []string{filename})
+	if err != nil {
 		return nil, err
 	}
 
+	for _, entry := range tree {
+		if entry.Filename == filename {
+			return entry.ObjectID, nil
+		}
+	}
+
 	return nil, fs.ErrNotExist
 }
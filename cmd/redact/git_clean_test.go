@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/julian7/redact/encoder"
+	"github.com/julian7/redact/files"
+	"github.com/julian7/redact/logger"
+	"github.com/julian7/redact/repo"
+	"github.com/urfave/cli/v3"
+)
+
+// TestCleanConfigPrecedence checks settings are taken from environment
+// variables, command line options, git config, repo settings, and built-in
+// defaults, in this order of precedence
+func TestCleanConfigPrecedence(t *testing.T) { //nolint:funlen
+	settings := &repo.Settings{
+		Version:     repo.SettingsVersion,
+		Encoding:    "chacha20-poly1305",
+		MinEpoch:    2,
+		Padding:     "pow2",
+		Compression: "deflate",
+	}
+
+	tt := []struct {
+		name        string
+		settings    *repo.Settings
+		config      map[string]string
+		args        []string
+		env         map[string]string
+		encoding    uint32
+		epoch       uint32
+		bindPath    bool
+		padding     string
+		compression string
+	}{
+		{
+			name:        "built-in",
+			encoding:    encoder.TypeAES256GCM96,
+			epoch:       1,
+			padding:     "none",
+			compression: "none",
+		},
+		{
+			name:        "settings",
+			settings:    settings,
+			encoding:    encoder.TypeChaCha20Poly1305,
+			epoch:       4,
+			padding:     "pow2",
+			compression: "deflate:1024",
+		},
+		{
+			name:     "git config",
+			settings: settings,
+			config: map[string]string{
+				repo.ConfigPadding:  "padme",
+				repo.ConfigCompress: "none",
+				repo.ConfigBindPath: "true",
+				repo.ConfigMinEpoch: "3",
+			},
+			encoding:    encoder.TypeChaCha20Poly1305,
+			epoch:       4,
+			bindPath:    true,
+			padding:     "padme",
+			compression: "none",
+		},
+		{
+			name:     "empty environment",
+			settings: settings,
+			config: map[string]string{
+				repo.ConfigPadding:  "padme",
+				repo.ConfigCompress: "none",
+				repo.ConfigBindPath: "true",
+			},
+			env: map[string]string{
+				"REDACT_GIT_CLEAN_TYPE":      "",
+				"REDACT_GIT_CLEAN_EPOCH":     "",
+				"REDACT_GIT_CLEAN_BIND_PATH": "",
+				"REDACT_GIT_CLEAN_PADDING":   "",
+				"REDACT_GIT_CLEAN_COMPRESS":  "",
+			},
+			encoding:    encoder.TypeChaCha20Poly1305,
+			epoch:       4,
+			bindPath:    true,
+			padding:     "padme",
+			compression: "none",
+		},
+		{
+			name:     "command line",
+			settings: settings,
+			config: map[string]string{
+				repo.ConfigPadding:  "padme",
+				repo.ConfigCompress: "none",
+				repo.ConfigBindPath: "true",
+				repo.ConfigMinEpoch: "3",
+			},
+			args: []string{
+				"--type", "xchacha20-poly1305", "--epoch", "3", "--bind-path=false",
+				"--padding", "block:64", "--compress", "deflate:16",
+			},
+			encoding:    encoder.TypeXChaCha20Poly1305,
+			epoch:       3,
+			padding:     "block:64",
+			compression: "deflate:16",
+		},
+		{
+			name:     "environment",
+			settings: settings,
+			config: map[string]string{
+				repo.ConfigPadding:  "padme",
+				repo.ConfigCompress: "none",
+				repo.ConfigBindPath: "true",
+				repo.ConfigMinEpoch: "3",
+			},
+			args: []string{
+				"--type", "xchacha20-poly1305", "--epoch", "3", "--bind-path=false",
+				"--padding", "block:64", "--compress", "deflate:16",
+			},
+			env: map[string]string{
+				"REDACT_GIT_CLEAN_TYPE":      "aes256-gcm-siv",
+				"REDACT_GIT_CLEAN_EPOCH":     "4",
+				"REDACT_GIT_CLEAN_BIND_PATH": "true",
+				"REDACT_GIT_CLEAN_PADDING":   "none",
+				"REDACT_GIT_CLEAN_COMPRESS":  "deflate:4096",
+				repo.MinEpochEnv:             "4",
+			},
+			encoding:    encoder.TypeAES256GCMSIV,
+			epoch:       4,
+			bindPath:    true,
+			padding:     "none",
+			compression: "deflate:4096",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+
+			if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+				t.Skipf("git init: %v: %s", err, out)
+			}
+
+			for key, value := range tc.config {
+				git(t, "config", key, value)
+			}
+
+			for _, env := range []string{
+				"REDACT_GIT_CLEAN_TYPE",
+				"REDACT_GIT_CLEAN_EPOCH",
+				"REDACT_GIT_CLEAN_PADDING",
+				"REDACT_GIT_CLEAN_COMPRESS",
+				"REDACT_GIT_CLEAN_BIND_PATH",
+				repo.MinEpochEnv,
+			} {
+				value, ok := tc.env[env]
+				t.Setenv(env, value)
+
+				if !ok {
+					os.Unsetenv(env)
+				}
+			}
+
+			key := &files.SecretKey{}
+			defer key.Destroy()
+
+			for range 4 {
+				if err := key.Generate(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rt := &Runtime{Logger: logger.New(), Repo: &repo.Repo{SecretKey: key, Settings: tc.settings}}
+
+			var conf *cleanConfig
+
+			cmd := &cli.Command{
+				Name:  "clean",
+				Flags: cleanFlags(),
+				Action: func(_ context.Context, cmd *cli.Command) error {
+					var err error
+
+					conf, err = rt.newCleanConfig(cmd)
+
+					return err
+				},
+			}
+
+			if err := cmd.Run(context.Background(), append([]string{"clean"}, tc.args...)); err != nil {
+				t.Fatal(err)
+			}
+
+			encoding, err := chooseEncoding(conf, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if encoding != tc.encoding {
+				t.Errorf("unexpected encoding. Expected: %s, received: %s", encoder.Name(tc.encoding), encoder.Name(encoding))
+			}
+
+			epoch, err := rt.chooseEpoch(conf, "", "secret.key", &files.FileHeader{Epoch: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if epoch != tc.epoch {
+				t.Errorf("unexpected epoch. Expected: %d, received: %d", tc.epoch, epoch)
+			}
+
+			if bindPath := chooseBindPath(conf, "", nil); bindPath != tc.bindPath {
+				t.Errorf("unexpected path binding. Expected: %t, received: %t", tc.bindPath, bindPath)
+			}
+
+			padding, err := choosePadding(conf, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if padding.String() != tc.padding {
+				t.Errorf("unexpected padding. Expected: %s, received: %s", tc.padding, padding)
+			}
+
+			compression, err := chooseCompression(conf, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if compression.String() != tc.compression {
+				t.Errorf("unexpected compression. Expected: %s, received: %s", tc.compression, compression)
+			}
+		})
+	}
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 
+	"github.com/julian7/redact/files"
+	"github.com/julian7/redact/gitutil"
+	"github.com/julian7/redact/securemem"
 	"github.com/urfave/cli/v3"
 )
 
@@ -25,18 +30,124 @@ func (rt *Runtime) gitDiffDo(_ context.Context, cmd *cli.Command) error {
 		return fmt.Errorf("%w: redact git diff requires a single argument", ErrOptions)
 	}
 
-	reader, err := os.Open(args.First())
+	filename := args.First()
+
+	reader, err := os.Open(filename)
 	if err != nil {
 		return err
 	}
 
 	defer reader.Close()
 
-	err = rt.Decode(reader, os.Stdout)
-	if err == nil {
-		return nil
+	out, err := rt.decodeGuarded(reader, nil)
+	if errors.Is(err, files.ErrPathRequired) {
+		out, err = rt.decodePathBound(filename, reader)
+	}
+
+	if err != nil {
+		if !errors.Is(err, files.ErrInvalidPreamble) {
+			rt.Warnf("decoding %s: %v", filename, err)
+		}
+
+		return copyRaw(reader)
+	}
+
+	defer out.Destroy()
+
+	if _, err := os.Stdout.Write(out.Bytes()); err != nil {
+		return fmt.Errorf("writing decoded file: %w", err)
 	}
 
+	return nil
+}
+
+// decodeGuarded decodes a file from the beginning into guarded memory. It is
+// returned only if decoding succeeds.
+func (rt *Runtime) decodeGuarded(reader io.ReadSeeker, opts *files.FileOptions) (*securemem.Buffer, error) {
+	if err := rewind(reader); err != nil {
+		return nil, err
+	}
+
+	out := securemem.New(0)
+
+	if err := rt.DecodeWithOptions(opts, reader, out); err != nil {
+		out.Destroy()
+
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// decodePathBound decodes a path bound file. Git provides blob contents in a
+// temporary file, without their paths. Therefore, paths are looked up by the
+// blob's object ID in the index and in HEAD, and each of them is tried.
+func (rt *Runtime) decodePathBound(filename string, reader io.ReadSeeker) (*securemem.Buffer, error) {
+	objectID, err := gitutil.HashObject(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := pathsByObject(objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		out, err := rt.decodeGuarded(reader, &files.FileOptions{Path: path})
+		if err == nil {
+			return out, nil
+		}
+
+		rt.Debugf("decoding %s as %s: %v", filename, path, err)
+	}
+
+	return nil, fmt.Errorf("%w: no path in the index or in HEAD decodes it", files.ErrPathRequired)
+}
+
+// pathsByObject returns paths of an object ID in the index, and in HEAD
+func pathsByObject(objectID []byte) ([]string, error) {
+	paths := []string{}
+	seen := map[string]bool{}
+
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	index, err := gitutil.LsFilesFull()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range index.Items {
+		if bytes.Equal(entry.SHA1[:], objectID) {
+			add(entry.Name)
+		}
+	}
+
+	if !gitutil.HasHead() {
+		return paths, nil
+	}
+
+	entries, err := gitutil.LsTreeFull("HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if bytes.Equal(entry.ObjectID, objectID) {
+			add(entry.Filename)
+		}
+	}
+
+	return paths, nil
+}
+
+// rewind returns to the beginning of a file
+func rewind(reader io.ReadSeeker) error {
 	n, err := reader.Seek(0, io.SeekStart)
 	if err != nil {
 		return fmt.Errorf("re-reading file from beginning: %w", err)
@@ -46,6 +157,17 @@ func (rt *Runtime) gitDiffDo(_ context.Context, cmd *cli.Command) error {
 		return fmt.Errorf("%w: returned to position %d instead", ErrSeek, n)
 	}
 
+	return nil
+}
+
+// copyRaw writes a file as it is, when it can't be decoded. Decoded contents
+// are kept in guarded memory until decoding succeeds, so that output doesn't
+// mix plaintext with the raw file.
+func copyRaw(reader io.ReadSeeker) error {
+	if err := rewind(reader); err != nil {
+		return err
+	}
+
 	if _, err := io.Copy(os.Stdout, reader); err != nil {
 		return fmt.Errorf("reading file: %w", err)
 	}
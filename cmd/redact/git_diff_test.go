@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/julian7/redact/encoder"
+	"github.com/julian7/redact/files"
+	"github.com/julian7/redact/logger"
+	"github.com/julian7/redact/repo"
+)
+
+func TestPathBoundRename(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("GIT_AUTHOR_NAME", "redact")
+	t.Setenv("GIT_AUTHOR_EMAIL", "redact@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "redact")
+	t.Setenv("GIT_COMMITTER_EMAIL", "redact@example.com")
+
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Skipf("git init: %v: %s", err, out)
+	}
+
+	key := &files.SecretKey{}
+	defer key.Destroy()
+
+	if err := key.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(path string) []byte {
+		var ciphertext bytes.Buffer
+
+		opts := &files.FileOptions{Path: path, BindPath: true}
+		if err := key.EncodeWithOptions(encoder.TypeAES256GCM96, 1, opts, bytes.NewReader([]byte("secret")), &ciphertext); err != nil {
+			t.Fatal(err)
+		}
+
+		return ciphertext.Bytes()
+	}
+
+	if err := os.WriteFile("a.key", encode("a.key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	git(t, "add", "-A")
+	git(t, "commit", "-q", "-m", "first")
+	git(t, "mv", "a.key", "b.key")
+
+	rt := &Runtime{Logger: logger.New(), Repo: &repo.Repo{SecretKey: key, CommonDir: ".git"}}
+
+	hdr, err := rt.hdrByFilename("b.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hdr == nil || !hdr.PathBound() {
+		t.Errorf("moved file is not path bound: %+v", hdr)
+	}
+
+	tt := []struct {
+		name     string
+		contents []byte
+		err      error
+	}{
+		{name: "moved blob", contents: encode("a.key")},
+		{name: "unknown blob", contents: encode("c.key"), err: files.ErrPathRequired},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "blob")
+			if err := os.WriteFile(filename, tc.contents, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			reader, err := os.Open(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer reader.Close()
+
+			out, err := rt.decodePathBound(filename, reader)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("unexpected error. Expected: %v, received: %v", tc.err, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			defer out.Destroy()
+
+			if string(out.Bytes()) != "secret" {
+				t.Errorf("unexpected decoded contents: %q", out.Bytes())
+			}
+		})
+	}
+}
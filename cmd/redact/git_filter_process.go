@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/julian7/redact/files"
+	"github.com/julian7/redact/gitutil"
+	"github.com/julian7/redact/securemem"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	filterClient     = "git-filter-client"
+	filterServer     = "git-filter-server"
+	filterVersion    = "version=2"
+	filterCapClean   = "capability=clean"
+	filterCapSmudge  = "capability=smudge"
+	filterCapDelay   = "capability=delay"
+	filterStatusOK   = "status=success"
+	filterStatusErr  = "status=error"
+	filterStatusWait = "status=delayed"
+)
+
+var ErrFilterProtocol = errors.New("git filter protocol error")
+
+func (rt *Runtime) gitFilterProcessCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "filter-process",
+		Usage: "Long running clean / smudge filter for git",
+		Description: `This plumbing command implements git's long running filter process protocol
+(see "filter.<driver>.process" in gitattributes(5)).
+
+Instead of starting a new process for each file to be cleaned or smudged,
+git starts this filter once, and sends all files through it. The secret key
+is loaded only once, which makes checking out repositories with many
+encrypted files considerably faster.
+
+Smudging can be delayed, where git allows it, and then files up to 1 MiB are
+decrypted in parallel. Larger files are decrypted right away.
+
+It takes the same options as "redact git clean", applied to all files.`,
+		Before: rt.LoadSecretKey,
+		Action: rt.gitFilterProcessDo,
+		Flags:  cleanFlags(),
+	}
+}
+
+// filterProcess is a single session of git's long running filter protocol
+type filterProcess struct {
+	rt      *Runtime
+	conf    *cleanConfig
+	reader  *gitutil.PktReader
+	writer  *gitutil.PktWriter
+	caps    []string
+	current map[string][]byte
+	cat     *gitutil.CatFile
+	delayed *delayQueue
+}
+
+// delayQueue tracks delayed smudge jobs
+type delayQueue struct {
+	sync.Mutex
+	cond    *sync.Cond
+	sem     chan struct{}
+	wg      sync.WaitGroup
+	jobs    map[string]*delayedBlob
+	running int
+}
+
+type delayedBlob struct {
+	out    *securemem.Buffer
+	err    error
+	done   bool
+	listed bool
+}
+
+func (rt *Runtime) gitFilterProcessDo(_ context.Context, cmd *cli.Command) error {
+	conf, err := rt.newCleanConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := removeStaleSpool(rt.CommonDir); err != nil {
+		rt.Warn(err.Error())
+	}
+
+	proc := &filterProcess{
+		rt:      rt,
+		conf:    conf,
+		reader:  gitutil.NewPktReader(os.Stdin),
+		writer:  gitutil.NewPktWriter(os.Stdout),
+		delayed: newDelayQueue(runtime.NumCPU()),
+	}
+
+	defer proc.delayed.Close()
+	defer proc.closeCat()
+
+	if err := proc.handshake(); err != nil {
+		return err
+	}
+
+	return proc.serve()
+}
+
+func (fp *filterProcess) handshake() error {
+	welcome, err := fp.reader.ReadText()
+	if err != nil {
+		return fmt.Errorf("reading filter welcome: %w", err)
+	}
+
+	if len(welcome) < 2 || welcome[0] != filterClient || !slices.Contains(welcome[1:], filterVersion) {
+		return fmt.Errorf("%w: unsupported client welcome: %q", ErrFilterProtocol, welcome)
+	}
+
+	if err := fp.writer.WriteText(filterServer, filterVersion); err != nil {
+		return err
+	}
+
+	if err := fp.writer.Flush(); err != nil {
+		return err
+	}
+
+	caps, err := fp.reader.ReadText()
+	if err != nil {
+		return fmt.Errorf("reading filter capabilities: %w", err)
+	}
+
+	for _, capability := range []string{filterCapClean, filterCapSmudge, filterCapDelay} {
+		if slices.Contains(caps, capability) {
+			fp.caps = append(fp.caps, capability)
+		}
+	}
+
+	if err := fp.writer.WriteText(fp.caps...); err != nil {
+		return err
+	}
+
+	return fp.writer.Flush()
+}
+
+func (fp *filterProcess) serve() error {
+	for {
+		req, err := fp.reader.ReadKeyValues()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return fmt.Errorf("reading filter command: %w", err)
+		}
+
+		switch req["command"] {
+		case "clean":
+			err = fp.handleClean(req["pathname"])
+		case "smudge":
+			err = fp.handleSmudge(req["pathname"], req["can-delay"] == "1")
+		case "list_available_blobs":
+			err = fp.handleListAvailable()
+		default:
+			return fmt.Errorf("%w: unknown command %q", ErrFilterProtocol, req["command"])
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (fp *filterProcess) handleClean(pathname string) error {
+	out := &spool{}
+	defer out.Close()
+
+	content := fp.reader.Content()
+	err := fp.rt.clean(fp.conf, pathname, fp.currentHeader(pathname), content, out)
+
+	if err := drain(content); err != nil {
+		return err
+	}
+
+	if err != nil {
+		fp.rt.Warnf("cleaning %s: %v", pathname, err)
+
+		return fp.respondStatus(filterStatusErr)
+	}
+
+	reader, err := out.Reader()
+	if err != nil {
+		fp.rt.Warn(err.Error())
+
+		return fp.respondStatus(filterStatusErr)
+	}
+
+	return fp.respond(reader)
+}
+
+// handleSmudge decrypts a file. Encrypted contents are read first, as git
+// sends the whole file before reading the response. Decrypted contents of
+// delayed files are kept in guarded memory until git asks for them, and large
+// files are not delayed. Other files are streamed back to git.
+func (fp *filterProcess) handleSmudge(pathname string, canDelay bool) error {
+	content := fp.reader.Content()
+
+	if job, ok := fp.delayed.Take(pathname); ok {
+		if err := drain(content); err != nil {
+			return err
+		}
+
+		defer job.out.Destroy()
+
+		if job.err != nil {
+			fp.rt.Warnf("smudging %s: %v", pathname, job.err)
+
+			return fp.respondStatus(filterStatusErr)
+		}
+
+		return fp.respond(bytes.NewReader(job.out.Bytes()))
+	}
+
+	in := &spool{}
+
+	if _, err := io.Copy(in, content); err != nil {
+		in.Close()
+
+		return fmt.Errorf("reading content of %s: %w", pathname, err)
+	}
+
+	if canDelay && slices.Contains(fp.caps, filterCapDelay) && !in.Spooled() {
+		fp.delayed.Add(pathname, func(out io.Writer) error {
+			defer in.Close()
+
+			reader, err := in.Reader()
+			if err != nil {
+				return err
+			}
+
+			return fp.rt.DecodeWithOptions(&files.FileOptions{Path: pathname}, reader, out)
+		})
+
+		return fp.respondStatus(filterStatusWait)
+	}
+
+	defer in.Close()
+
+	reader, err := in.Reader()
+	if err != nil {
+		fp.rt.Warn(err.Error())
+
+		return fp.respondStatus(filterStatusErr)
+	}
+
+	return fp.respondDecoded(pathname, reader)
+}
+
+func (fp *filterProcess) handleListAvailable() error {
+	for _, pathname := range fp.delayed.WaitAvailable() {
+		if err := fp.writer.WriteText("pathname=" + pathname); err != nil {
+			return err
+		}
+	}
+
+	if err := fp.writer.Flush(); err != nil {
+		return err
+	}
+
+	return fp.respondStatus(filterStatusOK)
+}
+
+// respond sends a successful response with contents
+func (fp *filterProcess) respond(reader io.Reader) error {
+	if err := fp.respondStatus(filterStatusOK); err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(fp.writer, reader); err != nil {
+		return fmt.Errorf("sending filtered content: %w", err)
+	}
+
+	if err := fp.writer.Flush(); err != nil {
+		return err
+	}
+
+	// empty list: keep status unchanged
+	return fp.writer.Flush()
+}
+
+// respondDecoded streams decrypted contents to git. If decryption fails after
+// contents are partially sent, the response is closed with an error status,
+// and git discards the contents.
+func (fp *filterProcess) respondDecoded(pathname string, reader io.Reader) error {
+	out := &responseWriter{fp: fp}
+
+	if err := fp.rt.DecodeWithOptions(&files.FileOptions{Path: pathname}, reader, out); err != nil {
+		fp.rt.Warnf("smudging %s: %v", pathname, err)
+
+		if out.started {
+			if err := fp.writer.Flush(); err != nil {
+				return err
+			}
+		}
+
+		return fp.respondStatus(filterStatusErr)
+	}
+
+	if !out.started {
+		if err := fp.respondStatus(filterStatusOK); err != nil {
+			return err
+		}
+	}
+
+	if err := fp.writer.Flush(); err != nil {
+		return err
+	}
+
+	// empty list: keep status unchanged
+	return fp.writer.Flush()
+}
+
+// responseWriter sends contents of a response, after a successful status
+// before the first write
+type responseWriter struct {
+	fp      *filterProcess
+	started bool
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	if !w.started {
+		if err := w.fp.respondStatus(filterStatusOK); err != nil {
+			return 0, err
+		}
+
+		w.started = true
+	}
+
+	return w.fp.writer.Write(data)
+}
+
+func (fp *filterProcess) respondStatus(status string) error {
+	if err := fp.writer.WriteText(status); err != nil {
+		return err
+	}
+
+	return fp.writer.Flush()
+}
+
+// currentHeader returns file header of a file's current version in the index,
+// or in HEAD, if it is encrypted. The index follows renames, which keeps path
+// binding of moved files. The index and HEAD tree are listed only once per
+// session.
+func (fp *filterProcess) currentHeader(pathname string) *files.FileHeader {
+	if fp.current == nil {
+		fp.current = fp.listCurrent()
+	}
+
+	objectID, ok := fp.current[pathname]
+	if !ok {
+		return nil
+	}
+
+	if fp.cat == nil {
+		cat, err := gitutil.NewCatFile()
+		if err != nil {
+			fp.rt.Warnf("unable to determine epoch from filename: %s", err.Error())
+
+			return nil
+		}
+
+		fp.cat = cat
+	}
+
+	reader, err := fp.cat.Blob(objectID)
+	if err != nil {
+		fp.rt.Warnf("unable to determine epoch from filename: %s", err.Error())
+
+		return nil
+	}
+
+	hdr, err := fp.rt.FileStatus(reader)
+	if err != nil {
+		if !errors.Is(err, files.ErrInvalidPreamble) {
+			fp.rt.Warnf("unable to determine epoch from filename: %s", err.Error())
+		}
+
+		return nil
+	}
+
+	return hdr
+}
+
+// listCurrent returns object IDs of files in the index, and of files in HEAD
+// not in the index
+func (fp *filterProcess) listCurrent() map[string][]byte {
+	current := map[string][]byte{}
+
+	if gitutil.HasHead() {
+		entries, err := gitutil.LsTreeFull("HEAD")
+		if err != nil {
+			fp.rt.Debugf("listing HEAD: %v", err)
+		}
+
+		for _, entry := range entries {
+			current[entry.Filename] = entry.ObjectID
+		}
+	}
+
+	index, err := gitutil.LsFilesFull()
+	if err != nil {
+		fp.rt.Debugf("listing index: %v", err)
+
+		return current
+	}
+
+	for _, entry := range index.Items {
+		if entry.Stage == 0 {
+			current[entry.Name] = entry.SHA1[:]
+		}
+	}
+
+	return current
+}
+
+// closeCat stops the git process reading blobs of HEAD, if it's running
+func (fp *filterProcess) closeCat() {
+	if fp.cat == nil {
+		return
+	}
+
+	if err := fp.cat.Close(); err != nil {
+		fp.rt.Debugf("closing git cat-file: %v", err)
+	}
+}
+
+// drain reads all remaining content until the flush packet
+func drain(content io.Reader) error {
+	if _, err := io.Copy(io.Discard, content); err != nil {
+		return fmt.Errorf("reading content: %w", err)
+	}
+
+	return nil
+}
+
+func newDelayQueue(workers int) *delayQueue {
+	queue := &delayQueue{
+		sem:  make(chan struct{}, max(workers, 1)),
+		jobs: map[string]*delayedBlob{},
+	}
+	queue.cond = sync.NewCond(queue)
+
+	return queue
+}
+
+// Add starts a delayed job in the background, which writes into guarded
+// memory
+func (q *delayQueue) Add(pathname string, job func(io.Writer) error) {
+	blob := &delayedBlob{out: securemem.New(0)}
+
+	q.Lock()
+	q.jobs[pathname] = blob
+	q.running++
+	q.Unlock()
+
+	q.wg.Add(1)
+
+	go func() {
+		defer q.wg.Done()
+
+		q.sem <- struct{}{}
+		err := job(blob.out)
+		<-q.sem
+
+		q.Lock()
+		blob.err = err
+		blob.done = true
+		q.running--
+		q.Unlock()
+		q.cond.Broadcast()
+	}()
+}
+
+// WaitAvailable returns path names of finished jobs not listed yet. It blocks
+// until at least one job is finished, unless there are no running jobs left.
+func (q *delayQueue) WaitAvailable() []string {
+	q.Lock()
+	defer q.Unlock()
+
+	for {
+		available := []string{}
+
+		for pathname, blob := range q.jobs {
+			if blob.done && !blob.listed {
+				blob.listed = true
+				available = append(available, pathname)
+			}
+		}
+
+		if len(available) > 0 || q.running == 0 {
+			slices.Sort(available)
+
+			return available
+		}
+
+		q.cond.Wait()
+	}
+}
+
+// Take removes a finished job from the queue
+func (q *delayQueue) Take(pathname string) (*delayedBlob, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	blob, ok := q.jobs[pathname]
+	if !ok || !blob.done {
+		return nil, false
+	}
+
+	delete(q.jobs, pathname)
+
+	return blob, true
+}
+
+// Close waits for all jobs, and releases their results
+func (q *delayQueue) Close() {
+	q.wg.Wait()
+
+	q.Lock()
+	defer q.Unlock()
+
+	for pathname, blob := range q.jobs {
+		blob.out.Destroy()
+		delete(q.jobs, pathname)
+	}
+}
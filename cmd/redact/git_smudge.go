@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 
+	"github.com/julian7/redact/files"
 	"github.com/urfave/cli/v3"
 )
 
@@ -13,11 +14,18 @@ func (rt *Runtime) gitSmudgeCmd() *cli.Command {
 		Usage:  "Decoding file from STDIN, to STDOUT",
 		Before: rt.LoadSecretKey,
 		Action: rt.gitSmudgeDo,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "file",
+				Aliases: []string{"f"},
+				Usage:   "file path being filtered; required for path bound files",
+			},
+		},
 	}
 }
 
-func (rt *Runtime) gitSmudgeDo(_ context.Context, _ *cli.Command) error {
-	err := rt.Decode(os.Stdin, os.Stdout)
+func (rt *Runtime) gitSmudgeDo(_ context.Context, cmd *cli.Command) error {
+	err := rt.DecodeWithOptions(&files.FileOptions{Path: cmd.String("file")}, os.Stdin, os.Stdout)
 	if err != nil {
 		return err
 	}
@@ -4,9 +4,9 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"io"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/julian7/redact/files"
 	"github.com/julian7/redact/gpgutil"
 	"github.com/julian7/redact/kx"
 	"github.com/urfave/cli/v3"
@@ -17,9 +17,19 @@ func (rt *Runtime) gpgGrantCmd() *cli.Command {
 		Name:      "grant",
 		Usage:     "Grants access to collaborators with OpenPGP keys",
 		ArgsUsage: "[KEY...]",
-		Before:    rt.LoadSecretKey,
-		Action:    rt.gpgGrantGPGDo,
-		Flags: []cli.Flag{
+		Description: `Grant access to collaborators with OpenPGP keys
+
+The secret key is saved into the key exchange directory, encrypted with every
+OpenPGP key provided.
+
+With --epoch, collaborators get a single key epoch. With --since-epoch, they
+get the selected key epoch, and all later ones, including keys generated
+later. The epoch range is recorded in the key exchange directory, and it is
+honored by "redact key generate" and "redact key save". Granting access
+without these options grants all key epochs.`,
+		Before: rt.LoadSecretKey,
+		Action: rt.gpgGrantGPGDo,
+		Flags: append([]cli.Flag{
 			&cli.StringSliceFlag{
 				Name:      "file",
 				Aliases:   []string{"f"},
@@ -31,13 +41,22 @@ func (rt *Runtime) gpgGrantCmd() *cli.Command {
 				Aliases: []string{"a"},
 				Usage:   "import from OpenPGP ASCII Armored file instead of a keyring",
 			},
-		},
+		}, epochRangeFlags()...),
 	}
 }
 
 func (rt *Runtime) gpgGrantGPGDo(_ context.Context, cmd *cli.Command) error {
 	var keyEntries openpgp.EntityList
 
+	epochs, err := epochRange(cmd)
+	if err != nil {
+		return err
+	}
+
+	if _, err := rt.Subset(epochs); err != nil {
+		return err
+	}
+
 	rt.loadKeys(cmd.StringSlice("openpgp"), false, &keyEntries)
 	rt.loadKeys(cmd.StringSlice("openpgp-armor"), true, &keyEntries)
 
@@ -65,7 +84,7 @@ func (rt *Runtime) gpgGrantGPGDo(_ context.Context, cmd *cli.Command) error {
 	saved := 0
 
 	for _, key := range keyEntries {
-		if err := rt.saveGPGKey(key); err != nil {
+		if err := rt.saveGPGKey(key, epochs); err != nil {
 			rt.Warnf("cannot save key: %v", err)
 
 			continue
@@ -100,16 +119,10 @@ func (rt *Runtime) loadKeys(pgpFiles []string, isArmor bool, keyEntries *openpgp
 	}
 }
 
-func (rt *Runtime) saveGPGKey(key *openpgp.Entity) error {
+func (rt *Runtime) saveGPGKey(key *openpgp.Entity, epochs files.EpochRange) error {
 	gpgutil.PrintKey(key)
 
-	err := kx.SaveGPGKeyToKX(rt.Repo, key, func(w io.Writer) {
-		err := rt.SaveTo(w)
-		if err != nil {
-			rt.Warn(err)
-		}
-	})
-	if err != nil {
+	if err := kx.SaveGPGKeyToKX(rt.Repo, key, epochs); err != nil {
 		return err
 	}
 
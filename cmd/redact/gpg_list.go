@@ -59,6 +59,13 @@ func (rt *Runtime) accessListDo(ctx context.Context, cmd *cli.Command) error {
 
 		gpgutil.PrintKey(entities[0])
 
+		epochs, err := rt.LoadExchangeEpochs(strings.TrimSuffix(path, repo.ExtKeyArmor))
+		if err != nil {
+			rt.Warnf("cannot load epoch range: %v", err)
+		} else if !epochs.All() {
+			fmt.Printf("  key epochs: %s\n", epochs)
+		}
+
 		return nil
 	})
 	if err != nil {
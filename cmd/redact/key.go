@@ -26,6 +26,10 @@ key's epoch and signature. For more detailed look, please see
 			rt.keyInitCmd(),
 			rt.keyListCmd(),
 			rt.keyExportCmd(),
+			rt.keyProtectCmd(),
+			rt.keyUnprotectCmd(),
+			rt.keyRestoreCmd(),
+			rt.keyRetireCmd(),
 		},
 	}
 }
@@ -36,6 +40,11 @@ func (rt *Runtime) keyDo(ctx context.Context, cmd *cli.Command) error {
 	}
 
 	rt.Infof("repo key: %v", rt.SecretKey)
+	rt.Infof("key store: %s", rt.Store().Describe())
+
+	if rt.Protected() {
+		rt.Info("secret key is protected with a passphrase")
+	}
 
 	return nil
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/julian7/redact/files"
+	"github.com/urfave/cli/v3"
+)
+
+// epochRangeFlags returns flags selecting key epochs
+func epochRangeFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.UintFlag{
+			Name:  "epoch",
+			Usage: "select a single key `epoch` only",
+		},
+		&cli.UintFlag{
+			Name:  "since-epoch",
+			Usage: "select key `epoch` and later ones, including keys generated later",
+		},
+	}
+}
+
+// epochRange returns the key epochs selected by command line options
+func epochRange(cmd *cli.Command) (files.EpochRange, error) {
+	epoch := cmd.Uint("epoch")
+	since := cmd.Uint("since-epoch")
+
+	switch {
+	case epoch > 0 && since > 0:
+		return files.EpochRange{}, fmt.Errorf("%w: --epoch and --since-epoch are mutually exclusive", ErrOptions)
+	case epoch > 0:
+		return files.EpochRange{From: uint32(epoch), To: uint32(epoch)}, nil //nolint:gosec // flag value
+	default:
+		return files.EpochRange{From: uint32(since)}, nil //nolint:gosec // flag value
+	}
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 
+	"github.com/julian7/redact/securemem"
 	"github.com/urfave/cli/v3"
 )
 
@@ -17,22 +19,57 @@ func (rt *Runtime) keyExportCmd() *cli.Command {
 This command exports a secret key in PEM format, allowing to store them in
 markup files like YAML or JSON, or being provided as textual context.
 
-The exported key can be provided as a parameter to the unlock command.`,
+The exported key can be provided as a parameter to the unlock command.
+
+With --epoch or --since-epoch, only the selected key epochs are exported.
+
+With --passphrase, the exported key is encrypted with a key derived from a
+passphrase (with Argon2id and XChaCha20-Poly1305). The passphrase is read from
+the REDACT_EXPORT_PASSPHRASE environment variable, or from the terminal (twice).
+Importing the key (like with "redact unlock --exported-key") asks for the
+passphrase, or reads it from REDACT_EXPORT_PASSPHRASE.`,
 		Before: rt.LoadSecretKey,
 		Action: rt.exportDo,
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:    "outfile",
 				Aliases: []string{"f"},
 				Value:   "-",
 				Usage:   "Output `FILENAME`. Empty string or '-' means standard output.",
 			},
-		},
+			&cli.BoolFlag{
+				Name:  "passphrase",
+				Usage: "Encrypt exported key with a passphrase",
+			},
+		}, epochRangeFlags()...),
 	}
 }
 
-func (rt *Runtime) exportDo(_ context.Context, cmd *cli.Command) error {
-	var err error
+func (rt *Runtime) exportDo(_ context.Context, cmd *cli.Command) (err error) {
+	epochs, err := epochRange(cmd)
+	if err != nil {
+		return err
+	}
+
+	secretKey, err := rt.Subset(epochs)
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+
+	export := secretKey.Export
+
+	if cmd.Bool("passphrase") {
+		passphrase, err := readNewPassphrase(ExportPassphraseEnv, "Passphrase of exported key: ")
+		if err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+
+		defer securemem.Wipe(passphrase)
+
+		export = func(writer io.Writer) error {
+			return secretKey.ExportProtected(writer, passphrase)
+		}
+	}
 
 	var writer *os.File
 
@@ -45,9 +82,15 @@ func (rt *Runtime) exportDo(_ context.Context, cmd *cli.Command) error {
 		if err != nil {
 			return fmt.Errorf("export: %w", err)
 		}
+
+		defer func() {
+			if closeErr := writer.Close(); closeErr != nil && err == nil {
+				err = fmt.Errorf("export: %w", closeErr)
+			}
+		}()
 	}
 
-	if err := rt.Export(writer); err != nil {
+	if err := export(writer); err != nil {
 		return fmt.Errorf("export: %w", err)
 	}
 
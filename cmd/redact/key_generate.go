@@ -1,13 +1,11 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"io"
+	"time"
 
-	"github.com/julian7/redact/ext"
-	"github.com/julian7/redact/kx"
+	"github.com/julian7/redact/files"
 	"github.com/urfave/cli/v3"
 )
 
@@ -16,60 +14,31 @@ func (rt *Runtime) keyGenerateCmd() *cli.Command {
 		Name:    "generate",
 		Aliases: []string{"gen", "g"},
 		Usage:   "Generates redact key",
-		Before:  rt.LoadSecretKey,
+		Before:  rt.LoadSecretKeyForUpdate,
+		Flags:   keyMetadataFlags(),
 		Action:  rt.generateDo,
 	}
 }
 
-func (rt *Runtime) generateDo(_ context.Context, _ *cli.Command) error {
+func (rt *Runtime) generateDo(_ context.Context, cmd *cli.Command) error {
 	if err := rt.SaveGitSettings(); err != nil {
 		return err
 	}
 
-	if err := rt.Generate(); err != nil {
-		return fmt.Errorf("generating secret key: %w", err)
-	}
-
-	rt.Infof("New repo key created: %v", rt.SecretKey)
-
-	if err := rt.Save(); err != nil {
-		return fmt.Errorf("saving secret key: %w", err)
-	}
-
-	extConfig, err := ext.Load(rt.Repo)
+	meta, err := keyMetadata(cmd, time.Now())
 	if err != nil {
-		return fmt.Errorf("loading extension config: %w", err)
+		return err
 	}
 
-	if extConfig != nil {
-		buf := &bytes.Buffer{}
-		if err := rt.Export(buf); err != nil {
-			return err
-		}
-
-		if err := extConfig.SaveKey(buf.Bytes()); err != nil {
-			return err
-		}
+	if err := rt.GenerateWith(meta); err != nil {
+		return fmt.Errorf("generating secret key: %w", err)
 	}
 
-	updatedKeys, err := kx.UpdateGPGKeysInKX(rt.Repo, func(w io.Writer) {
-		if err := rt.SaveTo(w); err != nil {
-			rt.Warn(err)
-		}
-	})
-	if err != nil {
-		rt.Warn(`unable to update secret keys; restore original key with "redact unlock", and try again`)
-
-		return fmt.Errorf("updating key exchange secret keys: %w", err)
-	}
+	rt.Infof("New repo key created: %v", rt.SecretKey)
 
-	if updatedKeys > 0 {
-		fmt.Printf(
-			"Updated %d key%s. Don't forget to commit new encrypted secret keys into the repo.\n",
-			updatedKeys,
-			map[bool]string{false: "s", true: ""}[updatedKeys == 1],
-		)
+	if err := rt.SaveKey(); err != nil {
+		return fmt.Errorf("saving secret key: %w", err)
 	}
 
-	return nil
+	return rt.updateKeyExchange(files.EpochRange{})
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/julian7/redact/ext"
 	"github.com/urfave/cli/v3"
@@ -14,6 +15,7 @@ func (rt *Runtime) keyInitCmd() *cli.Command {
 		Name:      "init",
 		Usage:     "Generates initial redact key",
 		ArgsUsage: " ",
+		Flags:     keyMetadataFlags(),
 		Action:    rt.initDo,
 	}
 }
@@ -22,11 +24,12 @@ func (rt *Runtime) initCmd() *cli.Command {
 	return &cli.Command{
 		Name:   "init",
 		Usage:  "Generates initial redact key (alias of redact key init)",
+		Flags:  keyMetadataFlags(),
 		Action: rt.initDo,
 	}
 }
 
-func (rt *Runtime) initDo(_ context.Context, _ *cli.Command) error {
+func (rt *Runtime) initDo(_ context.Context, cmd *cli.Command) error {
 	if err := rt.SaveGitSettings(); err != nil {
 		return err
 	}
@@ -40,7 +43,21 @@ func (rt *Runtime) initDo(_ context.Context, _ *cli.Command) error {
 		return fmt.Errorf("%w: %s", ErrKeyAlreadyExists, rt.SecretKey)
 	}
 
-	if err := rt.Generate(); err != nil {
+	served, err := rt.AgentServes()
+	if err != nil {
+		return err
+	}
+
+	if served {
+		return fmt.Errorf("%w: in the key agent", ErrKeyAlreadyExists)
+	}
+
+	meta, err := keyMetadata(cmd, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if err := rt.GenerateWith(meta); err != nil {
 		return fmt.Errorf("generating secret key: %w", err)
 	}
 
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"strings"
+	"time"
 
 	"github.com/julian7/redact/files"
 	"github.com/urfave/cli/v3"
@@ -19,9 +21,43 @@ func (rt *Runtime) keyListCmd() *cli.Command {
 func (rt *Runtime) listDo(_ context.Context, _ *cli.Command) error {
 	rt.Infof("repo key: %v", rt.SecretKey)
 
+	now := time.Now()
+
 	return files.EachKey(rt.Keys, func(_ uint32, key files.KeyHandler) error {
-		rt.Infof(" - %s", key)
+		rt.Infof(" - %s%s", key, keyDetails(key, now))
 
 		return nil
 	})
 }
+
+// keyDetails returns a human-readable summary of key metadata
+func keyDetails(key files.KeyHandler, now time.Time) string {
+	meta := key.Metadata()
+	details := []string{}
+
+	if !meta.Created.IsZero() {
+		details = append(details, "created "+meta.Created.Format(time.DateOnly))
+	}
+
+	if meta.Creator != "" {
+		details = append(details, "by "+meta.Creator)
+	}
+
+	if !meta.Expires.IsZero() {
+		if meta.Expired(now) {
+			details = append(details, "expired "+meta.Expires.Format(time.DateOnly))
+		} else {
+			details = append(details, "expires "+meta.Expires.Format(time.DateOnly))
+		}
+	}
+
+	if meta.Comment != "" {
+		details = append(details, meta.Comment)
+	}
+
+	if len(details) == 0 {
+		return ""
+	}
+
+	return " (" + strings.Join(details, ", ") + ")"
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	keyV1 "github.com/julian7/redact/files/key_v1"
+	"github.com/julian7/redact/gitutil"
+	"github.com/urfave/cli/v3"
+)
+
+// keyMetadataFlags returns flags describing newly generated keys
+func keyMetadataFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "comment",
+			Usage: "free-form comment of the new key",
+		},
+		&cli.StringFlag{
+			Name:  "expires",
+			Usage: "expiry of the new key, as a date (YYYY-MM-DD) or a duration (like 8760h)",
+		},
+	}
+}
+
+// keyMetadata assembles metadata of a new key from command line options and
+// git's user configuration
+func keyMetadata(cmd *cli.Command, now time.Time) (keyV1.Metadata, error) {
+	meta := keyV1.Metadata{
+		Created: now.UTC().Truncate(time.Second),
+		Creator: gitCreator(),
+		Comment: cmd.String("comment"),
+	}
+
+	expires, err := parseExpiry(cmd.String("expires"), meta.Created)
+	if err != nil {
+		return meta, err
+	}
+
+	meta.Expires = expires
+
+	return meta, nil
+}
+
+// parseExpiry parses key expiry, either as a date, or as a duration from now
+func parseExpiry(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		if !date.After(now) {
+			return time.Time{}, fmt.Errorf("%w: expiry %s is in the past", ErrOptions, value)
+		}
+
+		return date, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return time.Time{}, fmt.Errorf("%w: invalid expiry %q", ErrOptions, value)
+	}
+
+	return now.Add(duration), nil
+}
+
+// gitCreator returns the current git user as "Name <email>"
+func gitCreator() string {
+	name, _, _ := gitutil.GitConfigGet("user.name")
+	email, _, _ := gitutil.GitConfigGet("user.email")
+
+	if email != "" {
+		return strings.TrimSpace(fmt.Sprintf("%s <%s>", name, email))
+	}
+
+	return name
+}
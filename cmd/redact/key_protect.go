@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+)
+
+func (rt *Runtime) keyProtectCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "protect",
+		Usage: "Protects local secret key with a passphrase",
+		Description: `Protect local secret key with a passphrase
+
+The local secret key file is encrypted with a key derived from a passphrase
+(with Argon2id). The passphrase is read from the terminal, or from the
+REDACT_NEW_PASSPHRASE environment variable. Running it on a protected key
+changes its passphrase.
+
+Protected key files are unlocked with a passphrase read from the
+REDACT_PASSPHRASE environment variable, or from the terminal.`,
+		Before: rt.LoadSecretKeyForUpdate,
+		Action: rt.keyProtectDo,
+	}
+}
+
+func (rt *Runtime) keyUnprotectCmd() *cli.Command {
+	return &cli.Command{
+		Name:   "unprotect",
+		Usage:  "Removes passphrase protection of local secret key",
+		Before: rt.LoadSecretKeyForUpdate,
+		Action: rt.keyUnprotectDo,
+	}
+}
+
+func (rt *Runtime) keyProtectDo(_ context.Context, _ *cli.Command) error {
+	if rt.AgentOnly() {
+		return fmt.Errorf("%w: protecting secret key", ErrAgentOnlyKey)
+	}
+
+	passphrase, err := readNewPassphrase(NewPassphraseEnv, "New passphrase of redact key: ")
+	if err != nil {
+		return err
+	}
+
+	if err := rt.Protect(passphrase); err != nil {
+		return err
+	}
+
+	if err := rt.SaveKey(); err != nil {
+		return fmt.Errorf("saving secret key: %w", err)
+	}
+
+	rt.Info("secret key is protected with a passphrase")
+
+	return nil
+}
+
+func (rt *Runtime) keyUnprotectDo(_ context.Context, _ *cli.Command) error {
+	if !rt.Protected() {
+		rt.Info("secret key is not protected")
+
+		return nil
+	}
+
+	rt.Unprotect()
+
+	if err := rt.SaveKey(); err != nil {
+		return fmt.Errorf("saving secret key: %w", err)
+	}
+
+	rt.Info("secret key is not protected anymore")
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/julian7/redact/files"
+	"github.com/urfave/cli/v3"
+)
+
+func (rt *Runtime) keyRestoreCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "restore",
+		Usage:     "Restores local secret key from a backup",
+		ArgsUsage: "[backup]",
+		Description: `Restore local secret key from a backup
+
+Redact snapshots the previous secret key file into a backup directory next to
+it (.git/redact/backups by default), before overwriting it. Without
+arguments, this command lists available backups, oldest first. With a backup
+name, it restores that backup, after snapshotting the current key file. If the
+key agent serves the secret key, it is replaced with the restored one.`,
+		Action: rt.keyRestoreDo,
+	}
+}
+
+func (rt *Runtime) keyRestoreDo(_ context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() > 1 {
+		return fmt.Errorf("%w: redact key restore accepts a single backup", ErrOptions)
+	}
+
+	if err := rt.SetupRepo(); err != nil {
+		return fmt.Errorf("building secret key: %w", err)
+	}
+
+	store, ok := rt.Store().(files.BackupStore)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoKeyBackups, rt.Store().Describe())
+	}
+
+	if cmd.Args().Len() == 0 {
+		backups, err := store.Backups()
+		if err != nil {
+			return err
+		}
+
+		if len(backups) == 0 {
+			rt.Infof("no backups of %s", store.Describe())
+
+			return nil
+		}
+
+		for _, backup := range backups {
+			fmt.Println(backup)
+		}
+
+		return nil
+	}
+
+	data, err := store.LoadBackup(cmd.Args().First())
+	if err != nil {
+		return err
+	}
+
+	key := &files.SecretKey{Passphrase: rt.PassphraseFunc}
+	if err := key.Read(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("reading backup: %w", err)
+	}
+
+	if err := store.Save(data); err != nil {
+		return fmt.Errorf("restoring secret key: %w", err)
+	}
+
+	rt.Infof("secret key restored: %v", key)
+
+	served, err := rt.AgentServes()
+	if err != nil || !served {
+		return err
+	}
+
+	if _, err := rt.Merge(key); err != nil {
+		return err
+	}
+
+	if err := rt.SaveToAgent(); err != nil {
+		return err
+	}
+
+	rt.Info("secret key is updated in the key agent")
+
+	return nil
+}
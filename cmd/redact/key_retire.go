@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/julian7/redact/files"
+	"github.com/julian7/redact/gitutil"
+	"github.com/julian7/redact/repo"
+	"github.com/urfave/cli/v3"
+)
+
+func (rt *Runtime) keyRetireCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "retire",
+		Usage: "Retires a key epoch",
+		Description: `Retire a key epoch
+
+This command removes a key epoch from the secret key, after all files using
+it are re-encrypted with a later key (like by raising min_epoch in repository
+settings). It checks every file in HEAD and in the index of the whole repository, and it
+refuses to retire an epoch still in use, or the latest epoch.
+
+The retired epoch is recorded in repository settings (.redact/settings.json),
+and the clean filter never encrypts files with it again. The secret key is
+saved into extensions and into the key exchange without the retired epoch, and
+the epoch is removed from key backups (.git/redact/backups).
+OpenPGP recipients without any epochs left lose their secret keys.`,
+		Before: rt.LoadSecretKeyForUpdate,
+		Action: rt.keyRetireDo,
+		Flags: []cli.Flag{
+			&cli.UintFlag{
+				Name:     "epoch",
+				Usage:    "key `epoch` to retire",
+				Required: true,
+			},
+		},
+	}
+}
+
+func (rt *Runtime) keyRetireDo(_ context.Context, cmd *cli.Command) error {
+	epoch := uint32(cmd.Uint("epoch")) //nolint:gosec // flag value
+
+	if _, ok := rt.Keys[epoch]; !ok {
+		return fmt.Errorf("%w: key epoch %d not found", files.ErrInvalidKey, epoch)
+	}
+
+	if epoch == rt.LatestKey {
+		return fmt.Errorf("%w: %d", files.ErrRetireLatest, epoch)
+	}
+
+	users, err := rt.epochUsers(epoch)
+	if err != nil {
+		return err
+	}
+
+	if len(users) > 0 {
+		for _, name := range users {
+			rt.Warnf("%s is encrypted with key epoch %d", name, epoch)
+		}
+
+		return fmt.Errorf("%w: %d files are encrypted with key epoch %d", ErrEpochInUse, len(users), epoch)
+	}
+
+	if err := rt.Retire(epoch); err != nil {
+		return err
+	}
+
+	rt.Settings.Retire(epoch)
+
+	if err := repo.SaveSettings(rt.Workdir, rt.Settings); err != nil {
+		return err
+	}
+
+	if err := rt.SaveKey(); err != nil {
+		return fmt.Errorf("saving secret key: %w", err)
+	}
+
+	purged, err := rt.PurgeBackups(epoch)
+	if err != nil {
+		return fmt.Errorf("purging key backups: %w", err)
+	}
+
+	if purged > 0 {
+		rt.Infof("Key epoch %d purged from %d key backup%s.", epoch, purged, plural[purged == 1])
+	}
+
+	rt.Infof("Key epoch %d retired. Don't forget to commit %s into the repo.", epoch, repo.SettingsFile())
+
+	return rt.updateKeyExchange(files.EpochRange{})
+}
+
+// epochUsers returns files in HEAD and in the index, which are encrypted with
+// a key epoch. It checks the whole repository, regardless of the current
+// directory, and it names files relative to the top level directory.
+func (rt *Runtime) epochUsers(epoch uint32) ([]string, error) {
+	objects := map[string][]byte{}
+
+	if gitutil.HasHead() {
+		tree, err := gitutil.LsTreeFull("HEAD")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range tree {
+			if entry.Type == "blob" {
+				objects["HEAD:"+entry.Filename] = entry.ObjectID
+			}
+		}
+	}
+
+	index, err := gitutil.LsFilesFull()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range index.Items {
+		objects[":"+entry.Name] = entry.SHA1[:]
+	}
+
+	cat, err := gitutil.NewCatFile()
+	if err != nil {
+		return nil, err
+	}
+
+	defer cat.Close()
+
+	checked := map[string]bool{}
+	users := []string{}
+
+	for _, name := range slices.Sorted(maps.Keys(objects)) {
+		objectID := objects[name]
+
+		inUse, ok := checked[string(objectID)]
+		if !ok {
+			inUse, err = rt.encryptedWithEpoch(cat, objectID, epoch)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+
+			checked[string(objectID)] = inUse
+		}
+
+		if inUse {
+			users = append(users, name)
+		}
+	}
+
+	return users, nil
+}
+
+// encryptedWithEpoch returns whether a blob is encrypted with a key epoch.
+// Blobs with unreadable file headers are errors, as they may use the epoch.
+func (rt *Runtime) encryptedWithEpoch(cat *gitutil.CatFile, objectID []byte, epoch uint32) (bool, error) {
+	hdr, err := blobHeader(cat, rt.SecretKey, objectID)
+	if err != nil {
+		return false, err
+	}
+
+	return hdr != nil && hdr.Epoch == epoch, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"github.com/julian7/redact/encoder"
+	"github.com/julian7/redact/files"
+	"github.com/julian7/redact/logger"
+	"github.com/julian7/redact/repo"
+)
+
+func git(t *testing.T, args ...string) {
+	t.Helper()
+
+	if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+		t.Fatalf("git %s: %v: %s", args[0], err, out)
+	}
+}
+
+func TestEpochUsers(t *testing.T) {
+	tt := []struct {
+		name      string
+		commit    bool
+		truncated bool
+		expected  []string
+	}{
+		{name: "committed", commit: true, expected: []string{":other/a.key", "HEAD:other/a.key"}},
+		{name: "no HEAD", expected: []string{":other/a.key"}},
+		{name: "truncated", commit: true, truncated: true},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			t.Setenv("GIT_AUTHOR_NAME", "redact")
+			t.Setenv("GIT_AUTHOR_EMAIL", "redact@example.com")
+			t.Setenv("GIT_COMMITTER_NAME", "redact")
+			t.Setenv("GIT_COMMITTER_EMAIL", "redact@example.com")
+
+			if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+				t.Skipf("git init: %v: %s", err, out)
+			}
+
+			key := &files.SecretKey{}
+			defer key.Destroy()
+
+			for range 2 {
+				if err := key.Generate(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var ciphertext bytes.Buffer
+			if err := key.Encode(encoder.TypeAES256GCM96, 1, bytes.NewReader([]byte("secret")), &ciphertext); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, dir := range []string{"sub", "other"} {
+				if err := os.Mkdir(dir, 0700); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := os.WriteFile("sub/plain.txt", []byte("plain"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile("other/a.key", ciphertext.Bytes(), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.truncated {
+				if err := os.WriteFile("other/b.key", ciphertext.Bytes()[:len(files.FileMagic)+2], 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			git(t, "add", "-A")
+
+			if tc.commit {
+				git(t, "commit", "-q", "-m", "first")
+			}
+
+			t.Chdir("sub")
+
+			rt := &Runtime{Logger: logger.New(), Repo: &repo.Repo{SecretKey: key}}
+
+			users, err := rt.epochUsers(1)
+			if tc.truncated {
+				if err == nil {
+					t.Errorf("unexpected success with a truncated file: %v", users)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(users, tc.expected) {
+				t.Errorf("unexpected users of epoch 1. Expected: %v, received: %v", tc.expected, users)
+			}
+
+			users, err = rt.epochUsers(2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(users) != 0 {
+				t.Errorf("unexpected users of epoch 2: %v", users)
+			}
+		})
+	}
+}
@@ -4,9 +4,9 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"io"
 
 	"github.com/julian7/redact/ext"
+	"github.com/julian7/redact/files"
 	"github.com/julian7/redact/kx"
 	"github.com/urfave/cli/v3"
 )
@@ -16,28 +16,58 @@ func (rt *Runtime) keySaveCmd() *cli.Command {
 		Name:    "save",
 		Aliases: []string{"s"},
 		Usage:   "Saves redact key in Key Exchange",
-		Before:  rt.LoadSecretKey,
-		Action:  rt.keySaveDo,
+		Description: `Save secret key in Key Exchange
+
+This command saves the secret key into extensions, and updates secret keys of
+OpenPGP recipients in the key exchange directory. Every recipient gets the key
+epochs granted to them (see "redact openpgp grant").
+
+With --epoch or --since-epoch, only the selected key epochs are saved into
+extensions, and only OpenPGP recipients granted any of them are updated. They
+still get every key epoch granted to them.`,
+		Before: rt.LoadSecretKeyForUpdate,
+		Action: rt.keySaveDo,
+		Flags:  epochRangeFlags(),
 	}
 }
 
-func (rt *Runtime) keySaveDo(_ context.Context, _ *cli.Command) error {
+func (rt *Runtime) keySaveDo(_ context.Context, cmd *cli.Command) error {
+	epochs, err := epochRange(cmd)
+	if err != nil {
+		return err
+	}
+
+	if _, err := rt.Subset(epochs); err != nil {
+		return err
+	}
+
 	if err := rt.SaveGitSettings(); err != nil {
 		return err
 	}
 
-	if err := rt.Save(); err != nil {
+	if err := rt.SaveKey(); err != nil {
 		return fmt.Errorf("saving secret key: %w", err)
 	}
 
+	return rt.updateKeyExchange(epochs)
+}
+
+// updateKeyExchange saves keys of an epoch range into extensions, and
+// updates secret keys of OpenPGP recipients in the key exchange
+func (rt *Runtime) updateKeyExchange(epochs files.EpochRange) error {
 	extConfig, err := ext.Load(rt.Repo)
 	if err != nil {
 		return fmt.Errorf("loading extension config: %w", err)
 	}
 
-	if extConfig != nil {
+	if extConfig != nil && len(extConfig.Exts) > 0 {
+		secretKey, err := rt.Subset(epochs)
+		if err != nil {
+			return err
+		}
+
 		buf := &bytes.Buffer{}
-		if err := rt.Export(buf); err != nil {
+		if err := secretKey.Export(buf); err != nil {
 			return err
 		}
 
@@ -48,11 +78,7 @@ func (rt *Runtime) keySaveDo(_ context.Context, _ *cli.Command) error {
 		rt.Info("saved key to extensions")
 	}
 
-	updatedKeys, err := kx.UpdateGPGKeysInKX(rt.Repo, func(w io.Writer) {
-		if err := rt.SaveTo(w); err != nil {
-			rt.Warn(err)
-		}
-	})
+	updatedKeys, err := kx.UpdateGPGKeysInKX(rt.Repo, epochs)
 	if err != nil {
 		rt.Warn(`unable to update secret keys; restore original key with "redact unlock", and try again`)
 
@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 
+	"github.com/julian7/redact/agent"
+	"github.com/julian7/redact/files"
 	"github.com/urfave/cli/v3"
 )
 
@@ -13,9 +17,9 @@ func (rt *Runtime) lockCmd() *cli.Command {
 		Usage: "Locks repository",
 		Description: `Lock repository
 
-This command removes your secret key, and the filter configuration. It also
-turns secret files into their unencrypted form. The git repo will behave
-as like being not redact-aware. Locally modified or staged files can cause
+This command removes your secret key (and its backups), and the filter
+configuration. It also turns secret files into their unencrypted form. The git
+repo will behave as like being not redact-aware. Locally modified or staged files can cause
 leaking of secrets, and it's recommended to cancel all local modifications
 beforehand.`,
 		Before: rt.LoadSecretKey,
@@ -31,8 +35,16 @@ func (rt *Runtime) lockDo(_ context.Context, _ *cli.Command) error {
 		return fmt.Errorf("locking repo: %w", err)
 	}
 
-	if err := rt.Remove(); err != nil {
-		return fmt.Errorf("locking repo: %w", err)
+	if err := agent.NewClient().Remove(rt.CommonDir); err != nil && !errors.Is(err, agent.ErrNotRunning) {
+		rt.Warnf("cannot remove secret key from key agent: %v", err)
+	}
+
+	if err := rt.Remove(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		if !errors.Is(err, files.ErrReadOnlyKeyStore) {
+			return fmt.Errorf("locking repo: %w", err)
+		}
+
+		rt.Warnf("secret key is not removed: %v", err)
 	}
 
 	if err := rt.ForceReencrypt(false, func(err error) {
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/julian7/redact/repo"
+	"github.com/urfave/cli/v3"
+)
+
+// optionSetting returns a command line option as a setting. Environment
+// variables take precedence over command line flags, and empty ones are
+// ignored.
+func optionSetting(cmd *cli.Command, name, env string) repo.Setting {
+	envValue, envSet := os.LookupEnv(env)
+	if envSet && envValue != "" {
+		return repo.Setting{Value: envValue, Set: true}
+	}
+
+	if !cmd.IsSet(name) {
+		return repo.Setting{}
+	}
+
+	value := fmt.Sprint(cmd.Value(name))
+
+	// cli sets flags from empty environment variables to their zero values,
+	// which can't be told apart from the same values on the command line
+	if envSet && (value == "" || value == "false" || value == "0") {
+		return repo.Setting{}
+	}
+
+	return repo.Setting{Value: value, Set: true}
+}
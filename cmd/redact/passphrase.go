@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+const (
+	// PassphraseEnv provides the passphrase of a protected key file
+	PassphraseEnv = "REDACT_PASSPHRASE"
+	// NewPassphraseEnv provides the new passphrase of "redact key protect"
+	NewPassphraseEnv = "REDACT_NEW_PASSPHRASE"
+	// ExportPassphraseEnv provides the passphrase of encrypted exported keys
+	ExportPassphraseEnv = "REDACT_EXPORT_PASSPHRASE"
+)
+
+var (
+	ErrNoTerminal         = errors.New("no terminal to read passphrase from")
+	ErrPassphraseMismatch = errors.New("passphrases don't match")
+)
+
+// readPassphrase provides the passphrase of a protected key file from the
+// environment, or from the terminal
+func (rt *Runtime) readPassphrase() ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(PassphraseEnv); ok {
+		return []byte(passphrase), nil
+	}
+
+	return promptPassphrase("Passphrase of redact key: ")
+}
+
+// readExportPassphrase provides the passphrase of an encrypted exported key
+// from the environment, or from the terminal
+func (rt *Runtime) readExportPassphrase() ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(ExportPassphraseEnv); ok {
+		return []byte(passphrase), nil
+	}
+
+	return promptPassphrase("Passphrase of exported key: ")
+}
+
+// readNewPassphrase provides a new passphrase from an environment variable,
+// or from the terminal, asking for confirmation
+func readNewPassphrase(env, prompt string) ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(env); ok {
+		return []byte(passphrase), nil
+	}
+
+	passphrase, err := promptPassphrase(prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	confirm, err := promptPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(passphrase, confirm) {
+		return nil, ErrPassphraseMismatch
+	}
+
+	return passphrase, nil
+}
+
+// promptPassphrase reads a passphrase from the controlling terminal. Git
+// filters' standard input is not a terminal, therefore it's opened directly.
+func promptPassphrase(prompt string) ([]byte, error) {
+	tty, err := os.Open(ttyName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoTerminal, err)
+	}
+
+	defer tty.Close()
+
+	if !term.IsTerminal(int(tty.Fd())) {
+		return nil, ErrNoTerminal
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+
+	passphrase, err := term.ReadPassword(int(tty.Fd()))
+
+	fmt.Fprintln(os.Stderr)
+
+	if err != nil {
+		return nil, fmt.Errorf("reading passphrase: %w", err)
+	}
+
+	return passphrase, nil
+}
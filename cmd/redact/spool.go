@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/julian7/redact/files"
+)
+
+// spoolMemoryLimit is the amount of data kept in memory before spooling to
+// a temporary file
+const spoolMemoryLimit = 1024 * 1024
+
+// spool buffers encrypted data in memory up to spoolMemoryLimit, and in a
+// temporary file of the system's temp dir beyond that, so that arbitrarily
+// large contents can be held in constant memory. Temporary files are left
+// behind if the process is killed, therefore spools must never hold
+// plaintext: that's either streamed, or kept in guarded memory.
+type spool struct {
+	mem  bytes.Buffer
+	file *os.File
+}
+
+func (s *spool) Write(data []byte) (int, error) {
+	if s.file == nil && s.mem.Len()+len(data) > spoolMemoryLimit {
+		file, err := os.CreateTemp("", "redact-spool-")
+		if err != nil {
+			return 0, fmt.Errorf("creating spool file: %w", err)
+		}
+
+		s.file = file
+
+		if _, err := s.mem.WriteTo(s.file); err != nil {
+			return 0, fmt.Errorf("writing spool file: %w", err)
+		}
+	}
+
+	if s.file != nil {
+		return s.file.Write(data)
+	}
+
+	return s.mem.Write(data)
+}
+
+// Spooled returns whether contents are spooled into a temporary file
+func (s *spool) Spooled() bool {
+	return s.file != nil
+}
+
+// Reader returns a reader for the spooled contents from the beginning
+func (s *spool) Reader() (io.Reader, error) {
+	if s.file == nil {
+		return bytes.NewReader(s.mem.Bytes()), nil
+	}
+
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("rewinding spool file: %w", err)
+	}
+
+	return s.file, nil
+}
+
+// Close removes the temporary file
+func (s *spool) Close() error {
+	s.mem.Reset()
+
+	if s.file == nil {
+		return nil
+	}
+
+	name := s.file.Name()
+	err := s.file.Close()
+	s.file = nil
+
+	if rmErr := os.Remove(name); err == nil {
+		err = rmErr
+	}
+
+	return err
+}
+
+// removeStaleSpool removes the spool directory of earlier versions, which
+// spooled plaintext into the git common dir. Files were left behind there by
+// killed filter processes.
+func removeStaleSpool(commonDir string) error {
+	dir := filepath.Join(commonDir, files.DefaultKeyDir, "spool")
+
+	if _, err := os.Lstat(dir); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("removing stale spool files: %w", err)
+	}
+
+	return nil
+}
@@ -1,13 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"path/filepath"
-	"strings"
+	"os"
+	"slices"
 
-	"github.com/julian7/redact/encoder"
-	"github.com/julian7/redact/files"
 	"github.com/julian7/redact/gitutil"
 	"github.com/julian7/redact/logger"
 	"github.com/julian7/redact/repo"
@@ -30,11 +29,28 @@ This command lists all files in the repository (filtering is possible with
 --encrypted, --repo, --unencrypted, and --quiet options) showing encrypted
 and not encrypted files. It also detects possible problems with file
 statuses, when a file was wrongly encrypted, or not encrypted even it should
-have been.
-
-It also shows if a file is encrypted with an older key. While re-encryption
-as-is is possible with --rekey option, it's strongly recommended to replace
-these secrets instead.`,
+have been. Path bound files moved to a different path (eg. by "git mv"), and
+files encoded differently from their "redact-cipher" gitattribute are
+reported too, and they are re-encrypted with --fix.
+
+It also shows if a file is encrypted with an older key, or with a key below
+the minimum epoch (of repo settings, redact.minepoch git config,
+REDACT_MIN_EPOCH environment variable, or its "redact-epoch-min"
+gitattribute). While re-encryption as-is is possible with --rekey option, it's
+strongly recommended to replace these secrets instead.
+
+With --check, files in HEAD encrypted with a key below the minimum epoch are
+reported too, until they are committed with a later key. It fails on files
+encrypted in HEAD, but staged as plaintext, too.
+
+Files are compared to their versions in HEAD and in the working tree too:
+files encrypted in HEAD, but staged as plaintext, and files committed as
+plaintext to HEAD are reported. With --three-way, it shows the encryption
+status and key epoch of all three versions of files.
+
+With --format=json, it writes a JSON record for every file, one per line. With
+--format=sarif, it writes issues as a SARIF log, which code scanning tools can
+show as annotations of files.`,
 		Before: rt.LoadSecretKey,
 		Action: rt.statusDo,
 		Flags: []cli.Flag{
@@ -74,6 +90,16 @@ these secrets instead.`,
 				Value:   false,
 				Usage:   "Fix problems (doesn't affect files encrypted with older keys)",
 			},
+			&cli.BoolFlag{
+				Name:  "three-way",
+				Value: false,
+				Usage: "Show encryption status in HEAD, in the index, and in the working tree",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Value: formatText,
+				Usage: "Output format (text, json, or sarif)",
+			},
 			&cli.BoolFlag{
 				Name:    "rekey",
 				Aliases: []string{"R"},
@@ -85,19 +111,25 @@ these secrets instead.`,
 }
 
 type statusOptions struct {
-	Logger     *logger.Logger
-	repoOnly   bool
-	encOnly    bool
-	plainOnly  bool
-	quiet      bool
-	fixRepo    bool
-	check      bool
-	rekeyFiles bool
-	key        *files.SecretKey
-	args       []string
-	toFix      []string
-	toRekey    []string
-	issues     []string
+	Logger        *logger.Logger
+	repoOnly      bool
+	encOnly       bool
+	plainOnly     bool
+	quiet         bool
+	fixRepo       bool
+	check         bool
+	rekeyFiles    bool
+	threeWay      bool
+	eval          *statusEvaluator
+	formatter     statusFormatter
+	args          []string
+	toFix         []string
+	toRekey       []string
+	toRenormalize []string
+	headBelowMin  []string
+	// plaintextStaged are files encrypted in HEAD, but staged as plaintext
+	plaintextStaged []string
+	issues          []string
 }
 
 func (rt *Runtime) statusDo(_ context.Context, cmd *cli.Command) error {
@@ -110,45 +142,96 @@ func (rt *Runtime) statusDo(_ context.Context, cmd *cli.Command) error {
 		fixRepo:    cmd.Bool("fix"),
 		check:      cmd.Bool("check"),
 		rekeyFiles: cmd.Bool("rekey"),
+		threeWay:   cmd.Bool("three-way"),
 		args:       cmd.Args().Slice(),
 	}
 	if err := opts.validate(); err != nil {
 		return err
 	}
 
-	opts.key = rt.SecretKey
+	formatter, err := opts.newFormatter(cmd.String("format"), os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	opts.formatter = formatter
+
+	minEpoch, err := rt.MinEpoch()
+	if err != nil {
+		return err
+	}
+
+	opts.eval = &statusEvaluator{
+		key:          rt.SecretKey,
+		repo:         rt.Repo,
+		repoMinEpoch: minEpoch,
+	}
+
+	if err := opts.eval.loadHead(opts.args); err != nil {
+		return err
+	}
 
 	files, err := gitutil.LsFiles(opts.args)
 	if err != nil {
 		return err
 	}
 
-	if err := files.CheckAttrs(); err != nil {
+	if err := files.CheckAttrs(repo.AttrCipher, repo.AttrEpochMin); err != nil {
 		return err
 	}
 
 	for _, entry := range files.Errors {
-		msg := entry.Error()
-		rt.Warn(msg)
-		opts.issues = append(opts.issues, msg)
+		status := &FileStatus{Name: entry.Name, Issues: []string{entry.Error()}, Unreadable: true}
+		if err := opts.handleFileStatus(status); err != nil {
+			return err
+		}
 	}
 
+	jobs := make([]statusJob, 0, len(files.Items))
+
 	for _, entry := range files.Items {
 		if entry.Filter == repo.AttrName && entry.Status != gitutil.StatusOther {
 			if opts.encOnly || !opts.plainOnly {
-				opts.handleFileEntry(entry, true)
+				jobs = append(jobs, statusJob{entry: entry, shouldBeEncrypted: true})
 			}
 		} else {
 			if !opts.encOnly {
-				opts.handleFileEntry(entry, false)
+				jobs = append(jobs, statusJob{entry: entry, shouldBeEncrypted: false})
 			}
 		}
 	}
 
+	statuses, err := opts.eval.evaluateAll(jobs)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		if err := opts.handleFileStatus(status); err != nil {
+			return err
+		}
+	}
+
+	if opts.check {
+		if err := opts.checkHead(files); err != nil {
+			return err
+		}
+	}
+
+	if err := opts.formatter.Close(); err != nil {
+		return err
+	}
+
 	if opts.check {
 		return opts.checkIssues()
 	}
 
+	if opts.fixRepo && len(opts.toRenormalize) > 0 {
+		if err := gitutil.Renormalize(opts.toRenormalize); err != nil {
+			return fmt.Errorf("re-encrypting files: %w", err)
+		}
+	}
+
 	if opts.fixRepo || opts.rekeyFiles {
 		if err := rt.ForceReencrypt(opts.rekeyFiles, func(err error) {
 			rt.Warn(err.Error())
@@ -181,6 +264,24 @@ func (opts *statusOptions) checkIssues() error {
 		))
 	}
 
+	headBelowMinLen := len(opts.headBelowMin)
+	if headBelowMinLen > 0 {
+		err = append(err, fmt.Sprintf(
+			"%d file%s in HEAD below minimum epoch",
+			headBelowMinLen,
+			plural[headBelowMinLen == 1],
+		))
+	}
+
+	plaintextStagedLen := len(opts.plaintextStaged)
+	if plaintextStagedLen > 0 {
+		err = append(err, fmt.Sprintf(
+			"%d file%s encrypted in HEAD, plaintext staged",
+			plaintextStagedLen,
+			plural[plaintextStagedLen == 1],
+		))
+	}
+
 	issuesLen := len(opts.issues)
 	if issuesLen > 0 {
 		err = append(err, fmt.Sprintf(
@@ -202,86 +303,118 @@ func (opts *statusOptions) checkIssues() error {
 	return nil
 }
 
-func (opts *statusOptions) handleFileEntry(entry *gitutil.FileEntry, shouldBeEncrypted bool) {
-	var isEncrypted bool
-
-	var encKeyVersion, encType uint32
+// handleFileStatus reports a file's status, and collects files to fix
+func (opts *statusOptions) handleFileStatus(status *FileStatus) error {
+	for _, msg := range status.Warnings {
+		opts.Logger.Warn(msg)
+	}
 
-	reader, err := gitutil.Cat(entry.SHA1[:])
-	if err != nil {
-		msg := fmt.Sprintf("git cat-file %s: %v", entry.Name, err)
+	for _, msg := range status.Issues {
 		opts.Logger.Warn(msg)
 		opts.issues = append(opts.issues, msg)
+	}
 
-		return
+	if status.Fix {
+		opts.toFix = append(opts.toFix, status.Name)
 	}
 
-	defer reader.Close()
+	if status.Rekey {
+		opts.toRekey = append(opts.toRekey, status.Name)
+	}
 
-	hdr, err := opts.key.FileStatus(reader)
-	if err == nil {
-		encKeyVersion = hdr.Epoch
-		encType = hdr.Encoding
-		isEncrypted = true
+	if status.Renormalize {
+		opts.toRenormalize = append(opts.toRenormalize, status.Name)
 	}
 
-	msg := []string{}
+	if slices.ContainsFunc(status.Problems, func(p Problem) bool { return p.Rule == rulePlaintextStaged }) {
+		opts.plaintextStaged = append(opts.plaintextStaged, status.Name)
+	}
 
-	baseName := filepath.Base(entry.Name)
-	if strings.HasPrefix(entry.Name, repo.DefaultKeyExchangeDir+"/") || baseName == repo.GitAttributesFile {
-		if isEncrypted {
-			msg = append(msg, "should NEVER be encrypted")
-			opts.toFix = append(opts.toFix, entry.Name)
-			shouldBeEncrypted = false
-		}
-	} else if isEncrypted != shouldBeEncrypted {
-		if isEncrypted {
-			msg = append(msg, "should NOT be encrypted")
-		} else {
-			msg = append(msg, "should be encrypted")
-		}
+	if opts.repoOnly && status.Revision == "" {
+		return nil
+	}
 
-		opts.toFix = append(opts.toFix, entry.Name)
+	return opts.formatter.File(status)
+}
+
+// checkHead reports files in HEAD encrypted with a key below their minimum
+// epoch. Files identical to their index entries are checked already.
+func (opts *statusOptions) checkHead(entries *gitutil.FileEntries) error {
+	index := make(map[string]*gitutil.FileEntry, len(entries.Items))
+	for _, entry := range entries.Items {
+		index[entry.Name] = entry
 	}
 
-	if isEncrypted {
-		msg = append(msg, fmt.Sprintf("encoded with %s", encoder.Name(encType)))
-		if encKeyVersion != opts.key.LatestKey {
-			msg = append(msg, fmt.Sprintf("encrypted with key epoch %d, update to %d", encKeyVersion, opts.key.LatestKey))
-			opts.toRekey = append(opts.toRekey, entry.Name)
+	type headBlob struct {
+		blob     *gitutil.TreeEntry
+		minEpoch uint32
+	}
+
+	blobs := []headBlob{}
+
+	for _, blob := range opts.eval.headTree {
+		minEpoch := opts.eval.repoMinEpoch
+
+		if entry, ok := index[blob.Filename]; ok {
+			if bytes.Equal(entry.SHA1[:], blob.ObjectID) {
+				continue
+			}
+
+			// attribute errors are reported with the index entry
+			minEpoch, _ = opts.eval.minEpoch(entry)
 		}
 
-		if _, err := opts.key.Key(encKeyVersion); err != nil {
-			msg = append(msg, err.Error())
+		if minEpoch > 1 {
+			blobs = append(blobs, headBlob{blob: blob, minEpoch: minEpoch})
 		}
 	}
 
-	if !opts.repoOnly && (!opts.quiet || len(msg) > 0) {
-		printFileEntry(entry, isEncrypted, shouldBeEncrypted, strings.Join(msg, "; "))
+	type headResult struct {
+		epoch uint32
+		err   error
 	}
-}
 
-func printFileEntry(entry *gitutil.FileEntry, isEncrypted bool, shouldBeEncrypted bool, msg string) {
-	encryptedString := map[bool]string{
-		false: "   ",
-		true:  "enc",
-	}
-	fixString := map[bool]string{
-		false: "   ",
-		true:  "fix",
+	results, err := runWorkers(blobs, func(cat *gitutil.CatFile, item headBlob) headResult {
+		hdr, err := blobHeader(cat, opts.eval.key, item.blob.ObjectID)
+		if err != nil || hdr == nil {
+			return headResult{err: err}
+		}
+
+		return headResult{epoch: hdr.Epoch}
+	})
+	if err != nil {
+		return err
 	}
-	data := fmt.Sprintf(
-		"%s %s %s",
-		encryptedString[isEncrypted],
-		fixString[isEncrypted != shouldBeEncrypted],
-		entry.Name,
-	)
 
-	if len(msg) > 0 {
-		data = fmt.Sprintf("%s NOTE: %s", data, msg)
+	for idx, result := range results {
+		status := &FileStatus{Name: blobs[idx].blob.Filename, Revision: "HEAD", MinEpoch: blobs[idx].minEpoch}
+
+		switch {
+		case result.err != nil:
+			status.Issues = append(status.Issues, fmt.Sprintf("HEAD:%s: %v", status.Name, result.err))
+			status.Unreadable = true
+		case result.epoch > 0 && result.epoch < status.MinEpoch:
+			status.Encrypted = true
+			status.ShouldBeEncrypted = true
+			status.Epoch = result.epoch
+			_, err := opts.eval.key.Key(result.epoch)
+			status.KeyAvailable = err == nil
+			status.addProblem(ruleHeadEpochBelowMinimum, fmt.Sprintf(
+				"encrypted with key epoch %d, below minimum epoch %d",
+				result.epoch,
+				status.MinEpoch,
+			))
+			opts.headBelowMin = append(opts.headBelowMin, status.Name)
+		default:
+			continue
+		}
+
+		if err := opts.handleFileStatus(status); err != nil {
+			return err
+		}
 	}
 
-	fmt.Println(data)
+	return nil
 }
 
 func (opts statusOptions) validate() error {
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/julian7/redact/encoder"
+	"github.com/julian7/redact/files"
+	"github.com/julian7/redact/gitutil"
+	"github.com/julian7/redact/repo"
+)
+
+// StatusRule is a kind of problem found by status evaluation
+type StatusRule struct {
+	ID          string
+	Description string
+	// Level is the severity of the problem: error, warning, or note
+	Level string
+}
+
+var (
+	ruleMissingEncryption = &StatusRule{
+		ID:          "redact/missing-encryption",
+		Description: "File should be encrypted, but it is plaintext",
+		Level:       "error",
+	}
+	ruleUnexpectedEncryption = &StatusRule{
+		ID:          "redact/unexpected-encryption",
+		Description: "File should not be encrypted, but it is",
+		Level:       "error",
+	}
+	ruleEpochBelowMinimum = &StatusRule{
+		ID:          "redact/epoch-below-minimum",
+		Description: "File is encrypted with a key below its minimum epoch",
+		Level:       "error",
+	}
+	ruleOutdatedEpoch = &StatusRule{
+		ID:          "redact/outdated-epoch",
+		Description: "File is encrypted with an older key",
+		Level:       "note",
+	}
+	ruleKeyUnavailable = &StatusRule{
+		ID:          "redact/key-unavailable",
+		Description: "File is encrypted with a key missing from the secret key",
+		Level:       "warning",
+	}
+	rulePathBinding = &StatusRule{
+		ID:          "redact/path-binding",
+		Description: "Path bound file is bound to a different path",
+		Level:       "warning",
+	}
+	ruleCipherMismatch = &StatusRule{
+		ID:          "redact/cipher-mismatch",
+		Description: "File is encoded differently from its redact-cipher gitattribute",
+		Level:       "warning",
+	}
+	ruleHeadEpochBelowMinimum = &StatusRule{
+		ID:          "redact/head-epoch-below-minimum",
+		Description: "File in HEAD is encrypted with a key below its minimum epoch",
+		Level:       "error",
+	}
+	rulePlaintextStaged = &StatusRule{
+		ID:          "redact/plaintext-staged",
+		Description: "File is encrypted in HEAD, but plaintext is staged",
+		Level:       "error",
+	}
+	rulePlaintextInHead = &StatusRule{
+		ID:          "redact/plaintext-in-head",
+		Description: "File is plaintext in HEAD, its secrets are in the history",
+		Level:       "warning",
+	}
+	ruleEncryptedWorktree = &StatusRule{
+		ID:          "redact/encrypted-worktree",
+		Description: "File is encrypted in the working tree",
+		Level:       "note",
+	}
+	ruleStatusError = &StatusRule{
+		ID:          "redact/status-error",
+		Description: "File status cannot be evaluated",
+		Level:       "error",
+	}
+
+	statusRules = []*StatusRule{
+		ruleMissingEncryption,
+		ruleUnexpectedEncryption,
+		ruleEpochBelowMinimum,
+		ruleOutdatedEpoch,
+		ruleKeyUnavailable,
+		rulePathBinding,
+		ruleCipherMismatch,
+		ruleHeadEpochBelowMinimum,
+		rulePlaintextStaged,
+		rulePlaintextInHead,
+		ruleEncryptedWorktree,
+		ruleStatusError,
+	}
+)
+
+// Problem is a problem of a file found by status evaluation
+type Problem struct {
+	Rule    *StatusRule
+	Message string
+}
+
+// VersionState is the state of a version of a file: in HEAD, in the index, or
+// in the working tree
+type VersionState struct {
+	Present   bool
+	Encrypted bool
+	// Encoding and Epoch are set for encrypted versions only
+	Encoding uint32
+	Epoch    uint32
+}
+
+// FileStatus is the evaluated encryption status of a file in the index. It is
+// independent of how it's reported.
+type FileStatus struct {
+	Name string
+	// Revision is set if the file is not in the index, but in a commit
+	// (like HEAD)
+	Revision          string
+	Untracked         bool
+	Encrypted         bool
+	ShouldBeEncrypted bool
+	// Encoding and Epoch are set for encrypted files only
+	Encoding uint32
+	Epoch    uint32
+	// Head and Worktree are states of the file in HEAD, and in the working
+	// tree. The index state is in the fields above.
+	Head         VersionState
+	Worktree     VersionState
+	MinEpoch     uint32
+	KeyAvailable bool
+	// Problems are problems of the file itself
+	Problems []Problem
+	// Issues are errors of evaluating the file, and Warnings are problems
+	// not affecting its status
+	Issues   []string
+	Warnings []string
+	// Unreadable is set if the file couldn't be read from the index, and
+	// its status is unknown
+	Unreadable  bool
+	Fix         bool
+	Rekey       bool
+	Renormalize bool
+}
+
+// statusEvaluator evaluates file statuses. It holds read-only state only,
+// therefore it is safe for concurrent use.
+type statusEvaluator struct {
+	key          *files.SecretKey
+	repo         *repo.Repo
+	repoMinEpoch uint32
+	// headTree holds blobs of HEAD, and head indexes them by file name
+	headTree []*gitutil.TreeEntry
+	head     map[string]*gitutil.TreeEntry
+}
+
+// statusJob is a file to evaluate
+type statusJob struct {
+	entry             *gitutil.FileEntry
+	shouldBeEncrypted bool
+}
+
+// loadHead lists blobs of HEAD, possibly filtered by paths. HEAD is empty in
+// new repositories without commits.
+func (ev *statusEvaluator) loadHead(paths []string) error {
+	ev.headTree = []*gitutil.TreeEntry{}
+	ev.head = map[string]*gitutil.TreeEntry{}
+
+	if !gitutil.HasHead() {
+		return nil
+	}
+
+	tree, err := gitutil.LsTreeRecursive("HEAD", paths)
+	if err != nil {
+		return err
+	}
+
+	for _, blob := range tree {
+		if blob.Type != "blob" {
+			continue
+		}
+
+		ev.headTree = append(ev.headTree, blob)
+		ev.head[blob.Filename] = blob
+	}
+
+	return nil
+}
+
+// evaluateAll evaluates files concurrently, and returns their statuses sorted
+// by path name
+func (ev *statusEvaluator) evaluateAll(jobs []statusJob) ([]*FileStatus, error) {
+	slices.SortFunc(jobs, func(a, b statusJob) int {
+		return strings.Compare(a.entry.Name, b.entry.Name)
+	})
+
+	return runWorkers(jobs, ev.evaluate)
+}
+
+// evaluate returns the status of a single file in HEAD, in the index, and in
+// the working tree
+func (ev *statusEvaluator) evaluate(cat *gitutil.CatFile, job statusJob) *FileStatus {
+	status := ev.evaluateIndex(cat, job)
+	if status.Unreadable {
+		return status
+	}
+
+	ev.evaluateHead(cat, job.entry, status)
+	ev.evaluateWorktree(job.entry, status)
+
+	switch {
+	case status.Head.Encrypted && !status.Encrypted && !status.Untracked:
+		status.addProblem(rulePlaintextStaged, "was encrypted in HEAD, plaintext staged")
+	case status.Head.Present && !status.Head.Encrypted && status.Encrypted && status.ShouldBeEncrypted:
+		status.addProblem(rulePlaintextInHead, "was plaintext in HEAD, encrypted staged")
+	}
+
+	if status.Worktree.Encrypted && status.ShouldBeEncrypted {
+		status.addProblem(ruleEncryptedWorktree, "encrypted in the working tree")
+	}
+
+	return status
+}
+
+// evaluateIndex returns the status of a file in the index
+func (ev *statusEvaluator) evaluateIndex(cat *gitutil.CatFile, job statusJob) *FileStatus {
+	entry := job.entry
+	status := &FileStatus{
+		Name:              entry.Name,
+		Untracked:         entry.Status == gitutil.StatusOther,
+		ShouldBeEncrypted: job.shouldBeEncrypted,
+	}
+
+	hdr, err := indexHeader(cat, ev.key, entry)
+	if err != nil {
+		status.Issues = append(status.Issues, fmt.Sprintf("git cat-file %s: %v", entry.Name, err))
+		status.Unreadable = true
+
+		return status
+	}
+
+	if hdr != nil {
+		status.Encrypted = true
+		status.Encoding = hdr.Encoding
+		status.Epoch = hdr.Epoch
+	}
+
+	baseName := filepath.Base(entry.Name)
+	if ev.repo.IsExchangeFile(entry.Name) || baseName == repo.GitAttributesFile {
+		status.ShouldBeEncrypted = false
+
+		if status.Encrypted {
+			status.addProblem(ruleUnexpectedEncryption, "should NEVER be encrypted")
+			status.Fix = true
+		}
+	} else if status.Encrypted != status.ShouldBeEncrypted {
+		if status.Encrypted {
+			status.addProblem(ruleUnexpectedEncryption, "should NOT be encrypted")
+		} else {
+			status.addProblem(ruleMissingEncryption, "should be encrypted")
+		}
+
+		status.Fix = true
+	}
+
+	if !status.Encrypted {
+		return status
+	}
+
+	minEpoch, err := ev.minEpoch(entry)
+	if err != nil {
+		status.Issues = append(status.Issues, fmt.Sprintf("%s: %v", entry.Name, err))
+	}
+
+	status.MinEpoch = minEpoch
+
+	if status.Epoch < minEpoch {
+		status.addProblem(ruleEpochBelowMinimum, fmt.Sprintf(
+			"encrypted with key epoch %d, below minimum epoch %d, update to %d",
+			status.Epoch,
+			minEpoch,
+			ev.key.LatestKey,
+		))
+		status.Rekey = true
+	} else if status.Epoch != ev.key.LatestKey {
+		status.addProblem(ruleOutdatedEpoch, fmt.Sprintf(
+			"encrypted with key epoch %d, update to %d",
+			status.Epoch,
+			ev.key.LatestKey,
+		))
+		status.Rekey = true
+	}
+
+	if _, err := ev.key.Key(status.Epoch); err != nil {
+		status.addProblem(ruleKeyUnavailable, err.Error())
+	} else {
+		status.KeyAvailable = true
+
+		if hdr.PathBound() && !ev.checkPathBinding(cat, entry, status) {
+			status.addProblem(rulePathBinding, "bound to a different path")
+			status.Renormalize = true
+		}
+	}
+
+	wanted, ok, err := repo.ParseCipherAttr(entry.Attrs[repo.AttrCipher])
+	if err != nil {
+		status.Issues = append(status.Issues, fmt.Sprintf("%s: %v", entry.Name, err))
+	}
+
+	if ok && wanted != status.Encoding && status.ShouldBeEncrypted {
+		status.addProblem(ruleCipherMismatch, fmt.Sprintf("%s requires %s", repo.AttrCipher, encoder.Name(wanted)))
+		status.Renormalize = true
+	}
+
+	if status.Renormalize {
+		status.Fix = true
+	}
+
+	return status
+}
+
+// evaluateHead sets the state of a file in HEAD. Blobs identical to the
+// index entry are not read again.
+func (ev *statusEvaluator) evaluateHead(cat *gitutil.CatFile, entry *gitutil.FileEntry, status *FileStatus) {
+	blob, ok := ev.head[entry.Name]
+	if !ok {
+		return
+	}
+
+	status.Head.Present = true
+
+	if !status.Untracked && bytes.Equal(blob.ObjectID, entry.SHA1[:]) {
+		status.Head = status.Index()
+
+		return
+	}
+
+	hdr, err := blobHeader(cat, ev.key, blob.ObjectID)
+	if err != nil {
+		status.Issues = append(status.Issues, fmt.Sprintf("git cat-file HEAD:%s: %v", entry.Name, err))
+
+		return
+	}
+
+	status.Head.setHeader(hdr)
+}
+
+// evaluateWorktree sets the state of a file in the working tree. Only regular
+// files can be encrypted.
+func (ev *statusEvaluator) evaluateWorktree(entry *gitutil.FileEntry, status *FileStatus) {
+	info, err := os.Lstat(entry.Name)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			status.Warnings = append(status.Warnings, err.Error())
+		}
+
+		return
+	}
+
+	status.Worktree.Present = true
+
+	if !info.Mode().IsRegular() {
+		return
+	}
+
+	file, err := os.Open(entry.Name)
+	if err != nil {
+		status.Warnings = append(status.Warnings, err.Error())
+
+		return
+	}
+
+	defer file.Close()
+
+	hdr, err := ev.key.FileStatus(file)
+	if err != nil && !errors.Is(err, files.ErrInvalidPreamble) {
+		status.Warnings = append(status.Warnings, fmt.Sprintf("%s: %v", entry.Name, err))
+
+		return
+	}
+
+	status.Worktree.setHeader(hdr)
+}
+
+// Index returns the state of the file in the index
+func (status *FileStatus) Index() VersionState {
+	if status.Untracked || status.Unreadable {
+		return VersionState{}
+	}
+
+	return VersionState{
+		Present:   true,
+		Encrypted: status.Encrypted,
+		Encoding:  status.Encoding,
+		Epoch:     status.Epoch,
+	}
+}
+
+// setHeader sets the state from a file header, or to plaintext if it's nil
+func (state *VersionState) setHeader(hdr *files.FileHeader) {
+	state.Encrypted = hdr != nil
+
+	if hdr != nil {
+		state.Encoding = hdr.Encoding
+		state.Epoch = hdr.Epoch
+	}
+}
+
+func (status *FileStatus) addProblem(rule *StatusRule, msg string) {
+	status.Problems = append(status.Problems, Problem{Rule: rule, Message: msg})
+}
+
+// AllProblems returns problems of the file, including errors of evaluating it
+func (status *FileStatus) AllProblems() []Problem {
+	problems := slices.Clone(status.Problems)
+
+	for _, msg := range status.Issues {
+		problems = append(problems, Problem{Rule: ruleStatusError, Message: msg})
+	}
+
+	return problems
+}
+
+// Notes returns remarks of the file for human readers: its problems, and its
+// encoding type
+func (status *FileStatus) Notes() []string {
+	notes := make([]string, 0, len(status.Problems)+1)
+	encoded := !status.Encrypted
+
+	for _, problem := range status.Problems {
+		stateProblem := problem.Rule == ruleMissingEncryption || problem.Rule == ruleUnexpectedEncryption
+		if !encoded && !stateProblem {
+			notes = append(notes, status.encodedNote())
+			encoded = true
+		}
+
+		notes = append(notes, problem.Message)
+	}
+
+	if !encoded {
+		notes = append(notes, status.encodedNote())
+	}
+
+	return notes
+}
+
+func (status *FileStatus) encodedNote() string {
+	return fmt.Sprintf("encoded with %s", encoder.Name(status.Encoding))
+}
+
+// indexHeader returns the file header of a file in the index, or nil if it's
+// not encrypted. Untracked files are not encrypted.
+func indexHeader(cat *gitutil.CatFile, key *files.SecretKey, entry *gitutil.FileEntry) (*files.FileHeader, error) {
+	if entry.Status == gitutil.StatusOther {
+		return nil, nil
+	}
+
+	return blobHeader(cat, key, entry.SHA1[:])
+}
+
+// blobHeader returns the file header of a blob, or nil if it's not encrypted.
+// Blobs with the file preamble, but with an unreadable header are errors.
+func blobHeader(cat *gitutil.CatFile, key *files.SecretKey, objectID []byte) (*files.FileHeader, error) {
+	reader, err := cat.Blob(objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr, err := key.FileStatus(reader)
+	if err != nil {
+		if errors.Is(err, files.ErrInvalidPreamble) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return hdr, nil
+}
+
+// minEpoch returns the minimum key epoch of a file, from the repository's
+// minimum epoch, and its redact-epoch-min attribute
+func (ev *statusEvaluator) minEpoch(entry *gitutil.FileEntry) (uint32, error) {
+	epoch, _, err := repo.ParseEpochMinAttr(entry.Attrs[repo.AttrEpochMin])
+
+	return max(epoch, ev.repoMinEpoch), err
+}
+
+// checkPathBinding verifies a path bound file can be decrypted at its current
+// path. Files moved without changes (eg. by "git mv") are still bound to their
+// previous path.
+func (ev *statusEvaluator) checkPathBinding(cat *gitutil.CatFile, entry *gitutil.FileEntry, status *FileStatus) bool {
+	reader, err := cat.Blob(entry.SHA1[:])
+	if err != nil {
+		status.Warnings = append(status.Warnings, fmt.Sprintf("git cat-file %s: %v", entry.Name, err))
+
+		return true
+	}
+
+	err = ev.key.DecodeWithOptions(&files.FileOptions{Path: entry.Name}, reader, io.Discard)
+	if err != nil && !errors.Is(err, files.ErrAuthentication) {
+		status.Warnings = append(status.Warnings, fmt.Sprintf("decoding %s: %v", entry.Name, err))
+	}
+
+	return !errors.Is(err, files.ErrAuthentication)
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/julian7/redact/encoder"
+	"github.com/julian7/redact/gitutil"
+	"github.com/julian7/redact/logger"
+)
+
+// Output formats of redact status
+const (
+	formatText  = "text"
+	formatJSON  = "json"
+	formatSARIF = "sarif"
+)
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+	redactURI    = "https://github.com/julian7/redact"
+)
+
+// statusFormatter reports evaluated file statuses
+type statusFormatter interface {
+	// File reports the status of a file
+	File(status *FileStatus) error
+	// Close finishes the report
+	Close() error
+}
+
+func (opts *statusOptions) newFormatter(format string, out io.Writer) (statusFormatter, error) {
+	switch format {
+	case formatText:
+		return &textFormatter{out: out, logger: opts.Logger, quiet: opts.quiet, threeWay: opts.threeWay}, nil
+	case formatJSON:
+		return &jsonFormatter{encoder: json.NewEncoder(out), quiet: opts.quiet}, nil
+	case formatSARIF:
+		prefix, err := gitutil.Prefix()
+		if err != nil {
+			return nil, err
+		}
+
+		return &sarifFormatter{out: out, prefix: prefix, results: []sarifResult{}}, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown format %q", ErrOptions, format)
+}
+
+// textFormatter prints file statuses in columns for human readers. Problems
+// of files in commits are logged as warnings.
+type textFormatter struct {
+	out      io.Writer
+	logger   *logger.Logger
+	quiet    bool
+	threeWay bool
+}
+
+func (f *textFormatter) File(status *FileStatus) error {
+	if status.Revision != "" {
+		for _, problem := range status.Problems {
+			f.logger.Warnf("%s:%s: %s", status.Revision, status.Name, problem.Message)
+		}
+
+		return nil
+	}
+
+	if status.Unreadable {
+		return nil
+	}
+
+	notes := status.Notes()
+	if f.quiet && len(notes) == 0 {
+		return nil
+	}
+
+	if f.threeWay {
+		return printThreeWayEntry(f.out, status, strings.Join(notes, "; "))
+	}
+
+	return printFileEntry(f.out, status, strings.Join(notes, "; "))
+}
+
+func (f *textFormatter) Close() error {
+	return nil
+}
+
+// statusRecord is a file status in machine-readable form
+type statusRecord struct {
+	Path              string         `json:"path"`
+	Revision          string         `json:"revision,omitempty"`
+	ShouldBeEncrypted bool           `json:"should_be_encrypted"`
+	Encrypted         bool           `json:"encrypted"`
+	Encoder           string         `json:"encoder,omitempty"`
+	Epoch             uint32         `json:"epoch,omitempty"`
+	MinEpoch          uint32         `json:"min_epoch,omitempty"`
+	KeyAvailable      bool           `json:"key_available"`
+	Head              *versionRecord `json:"head,omitempty"`
+	Index             *versionRecord `json:"index,omitempty"`
+	Worktree          *versionRecord `json:"worktree,omitempty"`
+	Issues            []statusIssue  `json:"issues"`
+}
+
+// versionRecord is the state of a version of a file in machine-readable form
+type versionRecord struct {
+	Present   bool   `json:"present"`
+	Encrypted bool   `json:"encrypted"`
+	Encoder   string `json:"encoder,omitempty"`
+	Epoch     uint32 `json:"epoch,omitempty"`
+}
+
+func newVersionRecord(state VersionState) *versionRecord {
+	record := &versionRecord{Present: state.Present, Encrypted: state.Encrypted}
+
+	if state.Encrypted {
+		record.Encoder = encoder.Name(state.Encoding)
+		record.Epoch = state.Epoch
+	}
+
+	return record
+}
+
+type statusIssue struct {
+	Rule    string `json:"rule"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+func newStatusRecord(status *FileStatus) *statusRecord {
+	record := &statusRecord{
+		Path:              filepath.ToSlash(status.Name),
+		Revision:          status.Revision,
+		ShouldBeEncrypted: status.ShouldBeEncrypted,
+		Encrypted:         status.Encrypted,
+		MinEpoch:          status.MinEpoch,
+		KeyAvailable:      status.KeyAvailable,
+		Issues:            []statusIssue{},
+	}
+
+	if status.Encrypted {
+		record.Encoder = encoder.Name(status.Encoding)
+		record.Epoch = status.Epoch
+	}
+
+	if status.Revision == "" && !status.Unreadable {
+		record.Head = newVersionRecord(status.Head)
+		record.Index = newVersionRecord(status.Index())
+		record.Worktree = newVersionRecord(status.Worktree)
+	}
+
+	for _, problem := range status.AllProblems() {
+		record.Issues = append(record.Issues, statusIssue{
+			Rule:    problem.Rule.ID,
+			Level:   problem.Rule.Level,
+			Message: problem.Message,
+		})
+	}
+
+	return record
+}
+
+// jsonFormatter writes a JSON record for every file, one per line
+type jsonFormatter struct {
+	encoder *json.Encoder
+	quiet   bool
+}
+
+func (f *jsonFormatter) File(status *FileStatus) error {
+	record := newStatusRecord(status)
+	if f.quiet && len(record.Issues) == 0 {
+		return nil
+	}
+
+	if err := f.encoder.Encode(record); err != nil {
+		return fmt.Errorf("writing status: %w", err)
+	}
+
+	return nil
+}
+
+func (f *jsonFormatter) Close() error {
+	return nil
+}
+
+// sarifFormatter writes problems of files as a SARIF log, which code scanning
+// tools can show as annotations of files. Locations are relative to the top
+// level directory of the repository.
+type sarifFormatter struct {
+	out     io.Writer
+	prefix  string
+	results []sarifResult
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId"`
+}
+
+func (f *sarifFormatter) File(status *FileStatus) error {
+	uri := (&url.URL{Path: path.Join(f.prefix, filepath.ToSlash(status.Name))}).String()
+
+	for _, problem := range status.AllProblems() {
+		msg := fmt.Sprintf("%s: %s", status.Name, problem.Message)
+		if status.Revision != "" {
+			msg = fmt.Sprintf("%s:%s", status.Revision, msg)
+		}
+
+		f.results = append(f.results, sarifResult{
+			RuleID:    problem.Rule.ID,
+			RuleIndex: ruleIndex(problem.Rule),
+			Level:     problem.Rule.Level,
+			Message:   sarifMessage{Text: msg},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: uri, URIBaseID: "%SRCROOT%"},
+				},
+			}},
+		})
+	}
+
+	return nil
+}
+
+func (f *sarifFormatter) Close() error {
+	rules := make([]sarifRule, 0, len(statusRules))
+
+	for _, rule := range statusRules {
+		rules = append(rules, sarifRule{
+			ID:                   rule.ID,
+			ShortDescription:     sarifMessage{Text: rule.Description},
+			DefaultConfiguration: sarifConfiguration{Level: rule.Level},
+		})
+	}
+
+	log := &sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "redact",
+				Version:        version,
+				InformationURI: redactURI,
+				Rules:          rules,
+			}},
+			Results: f.results,
+		}},
+	}
+
+	encoder := json.NewEncoder(f.out)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(log); err != nil {
+		return fmt.Errorf("writing status: %w", err)
+	}
+
+	return nil
+}
+
+func ruleIndex(rule *StatusRule) int {
+	for idx, item := range statusRules {
+		if item == rule {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+func printFileEntry(out io.Writer, status *FileStatus, msg string) error {
+	encryptedString := map[bool]string{
+		false: "   ",
+		true:  "enc",
+	}
+	fixString := map[bool]string{
+		false: "   ",
+		true:  "fix",
+	}
+	data := fmt.Sprintf(
+		"%s %s %s",
+		encryptedString[status.Encrypted],
+		fixString[status.Encrypted != status.ShouldBeEncrypted],
+		status.Name,
+	)
+
+	if len(msg) > 0 {
+		data = fmt.Sprintf("%s NOTE: %s", data, msg)
+	}
+
+	_, err := fmt.Fprintln(out, data)
+
+	return err
+}
+
+// printThreeWayEntry prints encryption status of a file in HEAD, in the
+// index, and in the working tree
+func printThreeWayEntry(out io.Writer, status *FileStatus, msg string) error {
+	fixString := map[bool]string{
+		false: "   ",
+		true:  "fix",
+	}
+	data := fmt.Sprintf(
+		"%-9s %-9s %-9s %s %s",
+		versionString(status.Head),
+		versionString(status.Index()),
+		versionString(status.Worktree),
+		fixString[status.Encrypted != status.ShouldBeEncrypted],
+		status.Name,
+	)
+
+	if len(msg) > 0 {
+		data = fmt.Sprintf("%s NOTE: %s", data, msg)
+	}
+
+	_, err := fmt.Fprintln(out, data)
+
+	return err
+}
+
+// versionString describes a version of a file: "-" if it's missing, "enc:N"
+// if it's encrypted with key epoch N, or "plain"
+func versionString(state VersionState) string {
+	switch {
+	case !state.Present:
+		return "-"
+	case state.Encrypted:
+		return fmt.Sprintf("enc:%d", state.Epoch)
+	}
+
+	return "plain"
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/julian7/redact/agent"
+	"github.com/julian7/redact/encoder"
+	"github.com/julian7/redact/logger"
+	"github.com/julian7/redact/repo"
+)
+
+// TestCheckPlaintextStaged checks status --check fails on files encrypted in
+// HEAD, but staged as plaintext after their filter attribute is dropped
+func TestCheckPlaintextStaged(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("GIT_AUTHOR_NAME", "redact")
+	t.Setenv("GIT_AUTHOR_EMAIL", "redact@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "redact")
+	t.Setenv("GIT_COMMITTER_EMAIL", "redact@example.com")
+	t.Setenv(repo.DefaultKeyPEMEnv, "")
+	t.Setenv(repo.KeyFDEnv, "")
+	t.Setenv(agent.SocketEnv, filepath.Join(t.TempDir(), "run", "agent.sock"))
+
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Skipf("git init: %v: %s", err, out)
+	}
+
+	setup := &repo.Repo{}
+	if err := setup.SetupRepo(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setup.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setup.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	var ciphertext bytes.Buffer
+	if err := setup.Encode(encoder.TypeAES256GCM96, 1, bytes.NewReader([]byte("secret")), &ciphertext); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(".gitattributes", []byte("*.key filter=redact\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile("a.key", ciphertext.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	git(t, "add", "-A")
+	git(t, "commit", "-q", "-m", "first")
+
+	check := func() error {
+		rt := &Runtime{Logger: logger.New(), Repo: &repo.Repo{}}
+		defer func() {
+			if rt.SecretKey != nil {
+				rt.Destroy()
+			}
+		}()
+
+		return rt.statusCmd().Run(context.Background(), []string{"status", "--check", "--quiet"})
+	}
+
+	if err := check(); err != nil {
+		t.Fatalf("unexpected error of encrypted file: %v", err)
+	}
+
+	if err := os.WriteFile(".gitattributes", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile("a.key", []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	git(t, "add", "-A")
+
+	if err := check(); !errors.Is(err, ErrEncDiscrepancies) {
+		t.Errorf("unexpected error of plaintext staged: %v", err)
+	}
+}
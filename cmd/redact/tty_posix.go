@@ -0,0 +1,6 @@
+//go:build !windows
+
+package main
+
+// ttyName is the controlling terminal's device
+const ttyName = "/dev/tty"
@@ -0,0 +1,6 @@
+//go:build windows
+
+package main
+
+// ttyName is the console's input device
+const ttyName = "CONIN$"
@@ -3,9 +3,14 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 
 	"github.com/julian7/redact/ext"
+	"github.com/julian7/redact/files"
+	"github.com/julian7/redact/repo"
 	"github.com/urfave/cli/v3"
 )
 
@@ -23,7 +28,21 @@ exchange.
 
 Alternatively, a secret key file can be provided. This allows unlocking the
 repository where other ways are not available. Providing '-' reads the key
-from standard input.`,
+from standard input. The passphrase of encrypted exported keys is read from
+the REDACT_EXPORT_PASSPHRASE environment variable, or from the terminal.
+
+Epochs of the new secret key are merged into the existing local secret key,
+so epochs missing from the key exchange are kept. Unlock refuses to proceed,
+if the same epoch holds different secret keys. The previous key file is
+snapshotted before it is overwritten (see "redact key restore").
+
+With --agent, the secret key is loaded into the key agent only, instead of
+saving it into the repository (see "redact agent").
+
+With --ephemeral, the secret key is never saved. Every redact invocation
+reads the exported secret key from the REDACT_KEY_PEM environment variable,
+or from the file descriptor in REDACT_KEY_FD. This command only configures
+git filters, and checks out encrypted files.`,
 		Action: rt.unlockDo,
 		Flags: []cli.Flag{
 			&cli.StringFlag{
@@ -44,6 +63,16 @@ from standard input.`,
 				Usage:   "Use specific exported secret key file",
 				Sources: cli.EnvVars("REDACT_UNLOCK_EXPORTED_KEY"),
 			},
+			&cli.BoolFlag{
+				Name:    "agent",
+				Usage:   "Load secret key into the key agent only",
+				Sources: cli.EnvVars("REDACT_UNLOCK_AGENT"),
+			},
+			&cli.BoolFlag{
+				Name:    "ephemeral",
+				Usage:   "Read exported secret key from REDACT_KEY_PEM or REDACT_KEY_FD without saving it",
+				Sources: cli.EnvVars("REDACT_UNLOCK_EPHEMERAL"),
+			},
 		},
 		Commands: []*cli.Command{
 			rt.unlockGpgCmd(),
@@ -64,6 +93,18 @@ func (rt *Runtime) unlockDo(_ context.Context, cmd *cli.Command) error {
 		return fmt.Errorf("building secret key: %w", err)
 	}
 
+	if cmd.Bool("ephemeral") {
+		if keyFile != "" || pemFile != "" || extName != "" || cmd.Bool("agent") {
+			return fmt.Errorf("%w: --ephemeral is mutually exclusive with other key sources", ErrOptions)
+		}
+
+		return rt.unlockEphemeral()
+	}
+
+	if err := rt.loadExistingKey(cmd); err != nil {
+		return err
+	}
+
 	if extName == "" && (keyFile != "" || pemFile != "") {
 		if err := rt.readSecretKey(keyFile, pemFile); err != nil {
 			return err
@@ -74,7 +115,7 @@ func (rt *Runtime) unlockDo(_ context.Context, cmd *cli.Command) error {
 		}
 	}
 
-	if err := rt.Save(); err != nil {
+	if err := rt.storeUnlockedKey(cmd); err != nil {
 		return err
 	}
 
@@ -93,6 +134,103 @@ func (rt *Runtime) unlockDo(_ context.Context, cmd *cli.Command) error {
 	return nil
 }
 
+// unlockEphemeral checks the exported secret key from the environment, and
+// configures git filters without saving the key
+func (rt *Runtime) unlockEphemeral() error {
+	if !rt.Ephemeral() {
+		return fmt.Errorf(
+			"%w: --ephemeral requires %s or %s",
+			ErrOptions,
+			repo.DefaultKeyPEMEnv,
+			repo.KeyFDEnv,
+		)
+	}
+
+	if err := rt.Load(rt.StrictPermissionChecks); err != nil {
+		return fmt.Errorf("loading secret key from %s: %w", rt.Store().Describe(), err)
+	}
+
+	if err := rt.SaveGitSettings(); err != nil {
+		return err
+	}
+
+	if err := rt.ForceReencrypt(false, func(err error) {
+		rt.Warn(err.Error())
+	}); err != nil {
+		return err
+	}
+
+	fmt.Println("Repo is unlocked (ephemeral).")
+
+	return nil
+}
+
+// storeUnlockedKey loads the secret key into the key agent with --agent, or
+// saves it into the repository otherwise. The agent is updated too, if it
+// serves the key.
+func (rt *Runtime) storeUnlockedKey(cmd *cli.Command) error {
+	if !cmd.Bool("agent") {
+		if err := rt.Save(); err != nil {
+			return fmt.Errorf("saving secret key: %w", err)
+		}
+
+		served, err := rt.AgentServes()
+		if err != nil || !served {
+			return err
+		}
+
+		return rt.SaveToAgent()
+	}
+
+	if err := rt.SaveToAgent(); err != nil {
+		return err
+	}
+
+	rt.Info("secret key is loaded into the key agent")
+
+	return nil
+}
+
+// loadExistingKey loads the local secret key (or the one in the key agent
+// with --agent), if available, for new epochs to be merged into. Without
+// --agent, keys served by the agent are merged too.
+func (rt *Runtime) loadExistingKey(cmd *cli.Command) error {
+	if cmd.Bool("agent") {
+		_, err := rt.LoadFromAgent()
+
+		return err
+	}
+
+	if err := rt.LoadKeyForUpdate(); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+
+		return fmt.Errorf("loading existing secret key: %w", err)
+	}
+
+	return nil
+}
+
+// mergeSecretKey merges a raw secret key into the loaded one
+func (rt *Runtime) mergeSecretKey(reader io.Reader) error {
+	key := &files.SecretKey{Passphrase: rt.PassphraseFunc}
+	if err := key.Read(reader); err != nil {
+		return err
+	}
+
+	added, err := rt.Merge(key)
+	if err != nil {
+		return err
+	}
+
+	if len(added) > 0 {
+		rt.Infof("merged key epochs: %v", added)
+	}
+
+	return nil
+}
+
 func (rt *Runtime) readSecretKey(keyFile, pemFile string) error {
 	var fname string
 	if keyFile != "" {
@@ -108,7 +246,7 @@ func (rt *Runtime) readSecretKey(keyFile, pemFile string) error {
 	defer f.Close()
 
 	if keyFile != "" {
-		err = rt.Read(f)
+		err = rt.mergeSecretKey(f)
 	} else {
 		err = rt.Import(f)
 	}
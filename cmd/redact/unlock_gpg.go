@@ -43,6 +43,10 @@ func (rt *Runtime) unlockGpgDo(_ context.Context, cmd *cli.Command) error {
 		return fmt.Errorf("building secret key: %w", err)
 	}
 
+	if err := rt.loadExistingKey(cmd); err != nil {
+		return err
+	}
+
 	var key string
 
 	key, err = rt.loadKeyFromGPG(cmd.String("gpgkey"))
@@ -65,6 +69,10 @@ func (rt *Runtime) unlockGpgDo(_ context.Context, cmd *cli.Command) error {
 		return err
 	}
 
+	if err := rt.storeUnlockedKey(cmd); err != nil {
+		return err
+	}
+
 	if err := rt.SaveGitSettings(); err != nil {
 		return err
 	}
@@ -151,5 +159,9 @@ func (rt *Runtime) loadKeyFromGPG(gpgkey string) (string, error) {
 
 	defer reader.Close()
 
-	return fmt.Sprintf("%x", *key), kx.LoadSecretKeyFromReader(rt.SecretKey, reader)
+	if err := rt.mergeSecretKey(reader); err != nil {
+		return fmt.Sprintf("%x", *key), fmt.Errorf("reading unencrypted secret key: %w", err)
+	}
+
+	return fmt.Sprintf("%x", *key), nil
 }
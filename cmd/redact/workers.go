@@ -0,0 +1,57 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/julian7/redact/gitutil"
+)
+
+// runWorkers calls fn for all items on a bounded pool of workers, and returns
+// their results in the order of items. Every worker reads blobs through its
+// own git cat-file process, as they are not safe for concurrent use.
+func runWorkers[T, R any](items []T, fn func(*gitutil.CatFile, T) R) ([]R, error) {
+	workers := min(runtime.NumCPU(), len(items))
+	cats := make([]*gitutil.CatFile, 0, workers)
+
+	defer func() {
+		for _, cat := range cats {
+			_ = cat.Close()
+		}
+	}()
+
+	for range workers {
+		cat, err := gitutil.NewCatFile()
+		if err != nil {
+			return nil, err
+		}
+
+		cats = append(cats, cat)
+	}
+
+	results := make([]R, len(items))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+
+	for _, cat := range cats {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for idx := range next {
+				results[idx] = fn(cat, items[idx])
+			}
+		}()
+	}
+
+	for idx := range items {
+		next <- idx
+	}
+
+	close(next)
+	wg.Wait()
+
+	return results, nil
+}
@@ -3,6 +3,7 @@ package encoder_test
 import (
 	"bytes"
 	_ "embed"
+	"encoding/hex"
 	"testing"
 
 	"github.com/julian7/redact/encoder"
@@ -19,6 +20,10 @@ var (
 	sampleAESCiphertext []byte
 	//go:embed fixtures/ciphertext-chacha20-poly1305.bin
 	sampleChaChaCiphertext []byte
+	//go:embed fixtures/ciphertext-xchacha20-poly1305.bin
+	sampleXChaChaCiphertext []byte
+	//go:embed fixtures/ciphertext-aes256-gcm-siv.bin
+	sampleAESSIVCiphertext []byte
 )
 
 func TestEncode(t *testing.T) {
@@ -29,6 +34,8 @@ func TestEncode(t *testing.T) {
 	}{
 		{name: "AES256-GCM96", factory: encoder.NewAES256GCM96, cipher: sampleAESCiphertext},
 		{name: "ChaCha20-Poly1305", factory: encoder.NewChaCha20Poly1305, cipher: sampleChaChaCiphertext},
+		{name: "XChaCha20-Poly1305", factory: encoder.NewXChaCha20Poly1305, cipher: sampleXChaChaCiphertext},
+		{name: "AES256-GCM-SIV", factory: encoder.NewAES256GCMSIV, cipher: sampleAESSIVCiphertext},
 	}
 
 	for _, tc := range tt {
@@ -73,6 +80,8 @@ func TestDecode(t *testing.T) {
 	}{
 		{name: "AES256-GCM96", factory: encoder.NewAES256GCM96, cipher: sampleAESCiphertext},
 		{name: "ChaCha20-Poly1305", factory: encoder.NewChaCha20Poly1305, cipher: sampleChaChaCiphertext},
+		{name: "XChaCha20-Poly1305", factory: encoder.NewXChaCha20Poly1305, cipher: sampleXChaChaCiphertext},
+		{name: "AES256-GCM-SIV", factory: encoder.NewAES256GCMSIV, cipher: sampleAESSIVCiphertext},
 	}
 
 	for _, tc := range tt {
@@ -105,3 +114,295 @@ func TestDecode(t *testing.T) {
 		})
 	}
 }
+
+// TestAES256GCMSIVVectors checks AES256-GCM-SIV against RFC 8452, Appendix C.2
+// (AES-256-GCM-SIV), and C.3 (counter wrap)
+func TestAES256GCMSIVVectors(t *testing.T) {
+	tt := []struct {
+		name      string
+		key       string
+		nonce     string
+		aad       string
+		plaintext string
+		result    string
+	}{
+		{
+			name:   "empty",
+			key:    "0100000000000000000000000000000000000000000000000000000000000000",
+			nonce:  "030000000000000000000000",
+			result: "07f5f4169bbf55a8400cd47ea6fd400f",
+		},
+		{
+			name:      "8 bytes",
+			key:       "0100000000000000000000000000000000000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			plaintext: "0100000000000000",
+			result:    "c2ef328e5c71c83b843122130f7364b761e0b97427e3df28",
+		},
+		{
+			name:      "12 bytes",
+			key:       "0100000000000000000000000000000000000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			plaintext: "010000000000000000000000",
+			result:    "9aab2aeb3faa0a34aea8e2b18ca50da9ae6559e48fd10f6e5c9ca17e",
+		},
+		{
+			name:      "16 bytes",
+			key:       "0100000000000000000000000000000000000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			plaintext: "01000000000000000000000000000000",
+			result:    "85a01b63025ba19b7fd3ddfc033b3e76c9eac6fa700942702e90862383c6c366",
+		},
+		{
+			name:      "32 bytes",
+			key:       "0100000000000000000000000000000000000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			plaintext: "0100000000000000000000000000000002000000000000000000000000000000",
+			result:    "4a6a9db4c8c6549201b9edb53006cba821ec9cf850948a7c86c68ac7539d027fe819e63abcd020b006a976397632eb5d",
+		},
+		{
+			name:      "48 bytes",
+			key:       "0100000000000000000000000000000000000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			plaintext: "010000000000000000000000000000000200000000000000000000000000000003000000000000000000000000000000",
+			result:    "c00d121893a9fa603f48ccc1ca3c57ce7499245ea0046db16c53c7c66fe717e39cf6c748837b61f6ee3adcee17534ed5790bc96880a99ba804bd12c0e6a22cc4",
+		},
+		{
+			name:      "64 bytes",
+			key:       "0100000000000000000000000000000000000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			plaintext: "01000000000000000000000000000000020000000000000000000000000000000300000000000000000000000000000004000000000000000000000000000000",
+			result:    "c2d5160a1f8683834910acdafc41fbb1632d4a353e8b905ec9a5499ac34f96c7e1049eb080883891a4db8caaa1f99dd004d80487540735234e3744512c6f90ce112864c269fc0d9d88c61fa47e39aa08",
+		},
+		{
+			name:      "aad, 8 bytes",
+			key:       "0100000000000000000000000000000000000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			aad:       "01",
+			plaintext: "0200000000000000",
+			result:    "1de22967237a813291213f267e3b452f02d01ae33e4ec854",
+		},
+		{
+			name:      "aad, 12 bytes",
+			key:       "0100000000000000000000000000000000000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			aad:       "01",
+			plaintext: "020000000000000000000000",
+			result:    "163d6f9cc1b346cd453a2e4cc1a4a19ae800941ccdc57cc8413c277f",
+		},
+		{
+			name:      "aad, 16 bytes",
+			key:       "0100000000000000000000000000000000000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			aad:       "01",
+			plaintext: "02000000000000000000000000000000",
+			result:    "c91545823cc24f17dbb0e9e807d5ec17b292d28ff61189e8e49f3875ef91aff7",
+		},
+		{
+			name:      "aad, 32 bytes",
+			key:       "0100000000000000000000000000000000000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			aad:       "01",
+			plaintext: "0200000000000000000000000000000003000000000000000000000000000000",
+			result:    "07dad364bfc2b9da89116d7bef6daaaf6f255510aa654f920ac81b94e8bad365aea1bad12702e1965604374aab96dbbc",
+		},
+		{
+			name:      "aad, 48 bytes",
+			key:       "0100000000000000000000000000000000000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			aad:       "01",
+			plaintext: "020000000000000000000000000000000300000000000000000000000000000004000000000000000000000000000000",
+			result:    "c67a1f0f567a5198aa1fcc8e3f21314336f7f51ca8b1af61feac35a86416fa47fbca3b5f749cdf564527f2314f42fe2503332742b228c647173616cfd44c54eb",
+		},
+		{
+			name:      "aad, 64 bytes",
+			key:       "0100000000000000000000000000000000000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			aad:       "01",
+			plaintext: "02000000000000000000000000000000030000000000000000000000000000000400000000000000000000000000000005000000000000000000000000000000",
+			result:    "67fd45e126bfb9a79930c43aad2d36967d3f0e4d217c1e551f59727870beefc98cb933a8fce9de887b1e40799988db1fc3f91880ed405b2dd298318858467c895bde0285037c5de81e5b570a049b62a0",
+		},
+		{
+			name:      "12 bytes aad, 4 bytes",
+			key:       "0100000000000000000000000000000000000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			aad:       "010000000000000000000000",
+			plaintext: "02000000",
+			result:    "22b3f4cd1835e517741dfddccfa07fa4661b74cf",
+		},
+		{
+			name:      "18 bytes aad, 20 bytes",
+			key:       "0100000000000000000000000000000000000000000000000000000000000000",
+			nonce:     "030000000000000000000000",
+			aad:       "010000000000000000000000000000000200",
+			plaintext: "0300000000000000000000000000000004000000",
+			result:    "43dd0163cdb48f9fe3212bf61b201976067f342bb879ad976d8242acc188ab59cabfe307",
+		},
+		{
+			name:   "random key, empty",
+			key:    "e66021d5eb8e4f4066d4adb9c33560e4f46e44bb3da0015c94f7088736864200",
+			nonce:  "e0eaf5284d884a0e77d31646",
+			result: "169fbb2fbf389a995f6390af22228a62",
+		},
+		{
+			name:      "random key, 5 bytes aad, 3 bytes",
+			key:       "bae8e37fc83441b16034566b7a806c46bb91c3c5aedb64a6c590bc84d1a5e269",
+			nonce:     "e4b47801afc0577e34699b9e",
+			aad:       "4fbdc66f14",
+			plaintext: "671fdd",
+			result:    "0eaccb93da9bb81333aee0c785b240d319719d",
+		},
+		{
+			name:      "random key, 10 bytes aad, 6 bytes",
+			key:       "6545fc880c94a95198874296d5cc1fd161320b6920ce07787f86743b275d1ab3",
+			nonce:     "2f6d1f0434d8848c1177441f",
+			aad:       "6787f3ea22c127aaf195",
+			plaintext: "195495860f04",
+			result:    "a254dad4f3f96b62b84dc40c84636a5ec12020ec8c2c",
+		},
+		{
+			name:      "random key, 15 bytes aad, 9 bytes",
+			key:       "d1894728b3fed1473c528b8426a582995929a1499e9ad8780c8d63d0ab4149c0",
+			nonce:     "9f572c614b4745914474e7c7",
+			aad:       "489c8fde2be2cf97e74e932d4ed87d",
+			plaintext: "c9882e5386fd9f92ec",
+			result:    "0df9e308678244c44bc0fd3dc6628dfe55ebb0b9fb2295c8c2",
+		},
+		{
+			name:      "random key, 20 bytes aad, 12 bytes",
+			key:       "a44102952ef94b02b805249bac80e6f61455bfac8308a2d40d8c845117808235",
+			nonce:     "5c9e940fea2f582950a70d5a",
+			aad:       "0da55210cc1c1b0abde3b2f204d1e9f8b06bc47f",
+			plaintext: "1db2316fd568378da107b52b",
+			result:    "8dbeb9f7255bf5769dd56692404099c2587f64979f21826706d497d5",
+		},
+		{
+			name:      "random key, 25 bytes aad, 15 bytes",
+			key:       "9745b3d1ae06556fb6aa7890bebc18fe6b3db4da3d57aa94842b9803a96e07fb",
+			nonce:     "6de71860f762ebfbd08284e4",
+			aad:       "f37de21c7ff901cfe8a69615a93fdf7a98cad481796245709f",
+			plaintext: "21702de0de18baa9c9596291b08466",
+			result:    "793576dfa5c0f88729a7ed3c2f1bffb3080d28f6ebb5d3648ce97bd5ba67fd",
+		},
+		{
+			name:      "random key, 35 bytes aad, 21 bytes",
+			key:       "3c535de192eaed3822a2fbbe2ca9dfc88255e14a661b8aa82cc54236093bbc23",
+			nonce:     "688089e55540db1872504e1c",
+			aad:       "734320ccc9d9bbbb19cb81b2af4ecbc3e72834321f7aa0f70b7282b4f33df23f167541",
+			plaintext: "ced532ce4159b035277d4dfbb7db62968b13cd4eec",
+			result:    "626660c26ea6612fb17ad91e8e767639edd6c9faee9d6c7029675b89eaf4ba1ded1a286594",
+		},
+		{
+			name:      "counter wrap",
+			key:       "0000000000000000000000000000000000000000000000000000000000000000",
+			nonce:     "000000000000000000000000",
+			plaintext: "000000000000000000000000000000004db923dc793ee6497c76dcc03a98e108",
+			result:    "f3f80f2cf0cb2dd9c5984fcda908456cc537703b5ba70324a6793a7bf218d3eaffffffff000000000000000000000000",
+		},
+		{
+			name:      "counter wrap, partial block",
+			key:       "0000000000000000000000000000000000000000000000000000000000000000",
+			nonce:     "000000000000000000000000",
+			plaintext: "eb3640277c7ffd1303c7a542d02d3e4c0000000000000000",
+			result:    "18ce4f0b8cb4d0cac65fea8f79257b20888e53e72299e56dffffffff000000000000000000000000",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			enc, err := encoder.NewAES256GCMSIV(mustDecodeHex(t, tc.key))
+			if err != nil {
+				t.Fatalf("cannot create encoder: %v", err)
+			}
+
+			aead, err := enc.AEAD()
+			if err != nil {
+				t.Fatalf("cannot get AEAD: %v", err)
+			}
+
+			nonce := mustDecodeHex(t, tc.nonce)
+			aad := mustDecodeHex(t, tc.aad)
+			plaintext := mustDecodeHex(t, tc.plaintext)
+			result := mustDecodeHex(t, tc.result)
+
+			if ret := aead.Seal(nil, nonce, plaintext, aad); !bytes.Equal(ret, result) {
+				t.Errorf("Encrypted message not matching: %x", ret)
+			}
+
+			ret, err := aead.Open(nil, nonce, result, aad)
+			if err != nil {
+				t.Errorf("cannot decode: %v", err)
+			} else if !bytes.Equal(ret, plaintext) {
+				t.Errorf("Decrypted message not matching: %x", ret)
+			}
+
+			for _, pos := range []int{0, len(result) - 1} {
+				tampered := bytes.Clone(result)
+				tampered[pos] ^= 1
+
+				if _, err := aead.Open(nil, nonce, tampered, aad); err == nil {
+					t.Errorf("message tampered at byte %d decrypted successfully", pos)
+				}
+			}
+		})
+	}
+}
+
+// FuzzAES256GCMSIV checks AES256-GCM-SIV opens what it seals, and it rejects
+// messages with tampered tags
+func FuzzAES256GCMSIV(f *testing.F) {
+	f.Add(sampleKey[:encoder.AES256KeySize], sampleNonce[:12], samplePlaintext, []byte{}, uint(0))
+	f.Add(make([]byte, encoder.AES256KeySize), make([]byte, 12), make([]byte, 48), []byte{1}, uint(7))
+
+	f.Fuzz(func(t *testing.T, key, nonce, plaintext, aad []byte, bit uint) {
+		if len(key) < encoder.AES256KeySize || len(nonce) < 12 {
+			t.Skip()
+		}
+
+		nonce = nonce[:12]
+
+		enc, err := encoder.NewAES256GCMSIV(key)
+		if err != nil {
+			t.Fatalf("cannot create encoder: %v", err)
+		}
+
+		aead, err := enc.AEAD()
+		if err != nil {
+			t.Fatalf("cannot get AEAD: %v", err)
+		}
+
+		sealed := aead.Seal(nil, nonce, plaintext, aad)
+		if len(sealed) != len(plaintext)+aead.Overhead() {
+			t.Fatalf("unexpected sealed length %d of %d bytes", len(sealed), len(plaintext))
+		}
+
+		opened, err := aead.Open(nil, nonce, sealed, aad)
+		if err != nil {
+			t.Fatalf("cannot decode: %v", err)
+		}
+
+		if !bytes.Equal(opened, plaintext) {
+			t.Fatalf("Decrypted message not matching: %x", opened)
+		}
+
+		tampered := bytes.Clone(sealed)
+		tagBit := bit % uint(aead.Overhead()*8)
+		tampered[len(plaintext)+int(tagBit/8)] ^= 1 << (tagBit % 8)
+
+		if _, err := aead.Open(nil, nonce, tampered, aad); err == nil {
+			t.Fatalf("message with tampered tag bit %d decrypted successfully", tagBit)
+		}
+	})
+}
+
+func mustDecodeHex(t *testing.T, data string) []byte {
+	t.Helper()
+
+	ret, err := hex.DecodeString(data)
+	if err != nil {
+		t.Fatalf("cannot decode hex string %q: %v", data, err)
+	}
+
+	return ret
+}
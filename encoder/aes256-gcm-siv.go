@@ -0,0 +1,277 @@
+package encoder
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+const (
+	gcmSIVNonceSize = 12
+	gcmSIVTagSize   = 16
+	gcmSIVBlockSize = 16
+	// gcmSIVMaxPlaintext is the largest message allowed by RFC 8452
+	gcmSIVMaxPlaintext = 1 << 36
+)
+
+var errGCMSIVOpen = errors.New("cipher: message authentication failed")
+
+// AES256GCMSIV can take a KeyHandler, and stores encryption and HMAC keys
+type AES256GCMSIV []byte
+
+// NewAES256GCMSIV returns a new Encoder initialized with a key handler
+func NewAES256GCMSIV(key []byte) (AEAD, error) {
+	if len(key) < AES256KeySize {
+		return nil, ErrKeyTooSmall
+	}
+
+	return AES256GCMSIV(key[:AES256KeySize]), nil
+}
+
+func (enc AES256GCMSIV) KeySize() int { return AES256KeySize }
+
+func (enc AES256GCMSIV) String() string { return "AES256-GCM-SIV" }
+
+func (enc AES256GCMSIV) AEAD() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(enc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gcmSIV{block: block}, nil
+}
+
+// gcmSIV implements AES-GCM-SIV as described in RFC 8452. Per-nonce
+// encryption and authentication keys are derived from the key-generating
+// key, and the tag is calculated from the plaintext before encryption,
+// which makes it resistant to nonce reuse.
+//
+// Neither the standard library nor golang.org/x/crypto implements it, and
+// the stream encoder needs a cipher.AEAD taking explicit nonces. It is tested
+// against test vectors of RFC 8452, and fuzzed.
+type gcmSIV struct {
+	block cipher.Block
+}
+
+func (g *gcmSIV) NonceSize() int { return gcmSIVNonceSize }
+
+func (g *gcmSIV) Overhead() int { return gcmSIVTagSize }
+
+func (g *gcmSIV) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != gcmSIVNonceSize {
+		panic("encoder: incorrect nonce length given to AES-GCM-SIV")
+	}
+
+	if uint64(len(plaintext)) > gcmSIVMaxPlaintext {
+		panic("encoder: message too large for AES-GCM-SIV")
+	}
+
+	authKey, encBlock := g.deriveKeys(nonce)
+
+	var tag [gcmSIVTagSize]byte
+
+	g.calculateTag(tag[:], authKey, encBlock, nonce, plaintext, additionalData)
+
+	ret, out := sliceForAppend(dst, len(plaintext)+gcmSIVTagSize)
+	gcmSIVCTR(encBlock, tag[:], out[:len(plaintext)], plaintext)
+	copy(out[len(plaintext):], tag[:])
+
+	return ret
+}
+
+func (g *gcmSIV) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != gcmSIVNonceSize {
+		panic("encoder: incorrect nonce length given to AES-GCM-SIV")
+	}
+
+	if len(ciphertext) < gcmSIVTagSize || uint64(len(ciphertext)) > gcmSIVMaxPlaintext+gcmSIVTagSize {
+		return nil, errGCMSIVOpen
+	}
+
+	tag := ciphertext[len(ciphertext)-gcmSIVTagSize:]
+	ciphertext = ciphertext[:len(ciphertext)-gcmSIVTagSize]
+
+	authKey, encBlock := g.deriveKeys(nonce)
+
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	gcmSIVCTR(encBlock, tag, out, ciphertext)
+
+	var expected [gcmSIVTagSize]byte
+
+	g.calculateTag(expected[:], authKey, encBlock, nonce, out, additionalData)
+
+	if subtle.ConstantTimeCompare(expected[:], tag) != 1 {
+		clear(out)
+
+		return nil, errGCMSIVOpen
+	}
+
+	return ret, nil
+}
+
+// deriveKeys derives message authentication and encryption keys for a nonce
+func (g *gcmSIV) deriveKeys(nonce []byte) ([]byte, cipher.Block) {
+	var input, output [gcmSIVBlockSize]byte
+
+	keys := make([]byte, 0, gcmSIVBlockSize+AES256KeySize)
+
+	copy(input[4:], nonce)
+
+	for counter := uint32(0); len(keys) < cap(keys); counter++ {
+		binary.LittleEndian.PutUint32(input[:4], counter)
+		g.block.Encrypt(output[:], input[:])
+		keys = append(keys, output[:8]...)
+	}
+
+	encBlock, err := aes.NewCipher(keys[gcmSIVBlockSize:])
+	if err != nil {
+		// key size is fixed, this cannot happen
+		panic(err)
+	}
+
+	return keys[:gcmSIVBlockSize], encBlock
+}
+
+func (g *gcmSIV) calculateTag(tag, authKey []byte, encBlock cipher.Block, nonce, plaintext, additionalData []byte) {
+	var lengths [gcmSIVBlockSize]byte
+
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(additionalData))*8)
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(plaintext))*8)
+
+	hash := newPolyval(authKey)
+	hash.update(additionalData)
+	hash.update(plaintext)
+	hash.update(lengths[:])
+	hash.sum(tag)
+
+	for i := range nonce {
+		tag[i] ^= nonce[i]
+	}
+
+	tag[gcmSIVTagSize-1] &= 0x7f
+	encBlock.Encrypt(tag, tag)
+}
+
+// gcmSIVCTR is AES-CTR with a 32-bit little endian counter, starting from the
+// tag with its most significant bit set
+func gcmSIVCTR(block cipher.Block, tag, dst, src []byte) {
+	var counter, stream [gcmSIVBlockSize]byte
+
+	copy(counter[:], tag)
+	counter[gcmSIVBlockSize-1] |= 0x80
+
+	for len(src) > 0 {
+		block.Encrypt(stream[:], counter[:])
+		binary.LittleEndian.PutUint32(counter[:4], binary.LittleEndian.Uint32(counter[:4])+1)
+
+		n := subtle.XORBytes(dst, src, stream[:])
+		dst = dst[n:]
+		src = src[n:]
+	}
+}
+
+// polyval is the POLYVAL universal hash function of RFC 8452. Field elements
+// are little endian, and multiplication is a*b*x^-128 modulo
+// x^128 + x^127 + x^126 + x^121 + 1.
+type polyval struct {
+	hLo, hHi uint64
+	sLo, sHi uint64
+}
+
+func newPolyval(key []byte) *polyval {
+	return &polyval{
+		hLo: binary.LittleEndian.Uint64(key[:8]),
+		hHi: binary.LittleEndian.Uint64(key[8:]),
+	}
+}
+
+// update hashes data, padded with zeros to full blocks
+func (p *polyval) update(data []byte) {
+	var block [gcmSIVBlockSize]byte
+
+	for len(data) > 0 {
+		n := copy(block[:], data)
+		clear(block[n:])
+		data = data[n:]
+
+		p.sLo ^= binary.LittleEndian.Uint64(block[:8])
+		p.sHi ^= binary.LittleEndian.Uint64(block[8:])
+		p.sLo, p.sHi = polyvalMul(p.sLo, p.sHi, p.hLo, p.hHi)
+	}
+}
+
+func (p *polyval) sum(out []byte) {
+	binary.LittleEndian.PutUint64(out[:8], p.sLo)
+	binary.LittleEndian.PutUint64(out[8:], p.sHi)
+}
+
+// polyvalMul multiplies two field elements, with Montgomery reduction
+func polyvalMul(aLo, aHi, bLo, bHi uint64) (uint64, uint64) {
+	z0Lo, z0Hi := clmul(aLo, bLo)
+	z2Lo, z2Hi := clmul(aHi, bHi)
+	m0Lo, m0Hi := clmul(aLo, bHi)
+	m1Lo, m1Hi := clmul(aHi, bLo)
+
+	r0 := z0Lo
+	r1 := z0Hi ^ m0Lo ^ m1Lo
+	r2 := z2Lo ^ m0Hi ^ m1Hi
+	r3 := z2Hi
+
+	// eliminate the lowest two words by adding multiples of the modulus,
+	// then divide by x^128
+	r1 ^= (r0 << 57) ^ (r0 << 62) ^ (r0 << 63)
+	r2 ^= r0 ^ (r0 >> 7) ^ (r0 >> 2) ^ (r0 >> 1)
+	r2 ^= (r1 << 57) ^ (r1 << 62) ^ (r1 << 63)
+	r3 ^= r1 ^ (r1 >> 7) ^ (r1 >> 2) ^ (r1 >> 1)
+
+	return r2, r3
+}
+
+// clmul is a constant time carry-less multiplication of two 64-bit
+// polynomials, returning the low and high words of the product
+func clmul(x, y uint64) (uint64, uint64) {
+	lo := bmul64(x, y)
+	hi := bits.Reverse64(bmul64(bits.Reverse64(x), bits.Reverse64(y))) >> 1
+
+	return lo, hi
+}
+
+// bmul64 returns the low 64 bits of a carry-less multiplication, using
+// integer multiplications with holes in their operands to absorb carries
+func bmul64(x, y uint64) uint64 {
+	const (
+		m0 = 0x1111111111111111
+		m1 = 0x2222222222222222
+		m2 = 0x4444444444444444
+		m3 = 0x8888888888888888
+	)
+
+	x0, x1, x2, x3 := x&m0, x&m1, x&m2, x&m3
+	y0, y1, y2, y3 := y&m0, y&m1, y&m2, y&m3
+
+	z0 := (x0 * y0) ^ (x1 * y3) ^ (x2 * y2) ^ (x3 * y1)
+	z1 := (x0 * y1) ^ (x1 * y0) ^ (x2 * y3) ^ (x3 * y2)
+	z2 := (x0 * y2) ^ (x1 * y1) ^ (x2 * y0) ^ (x3 * y3)
+	z3 := (x0 * y3) ^ (x1 * y2) ^ (x2 * y1) ^ (x3 * y0)
+
+	return (z0 & m0) | (z1 & m1) | (z2 & m2) | (z3 & m3)
+}
+
+// sliceForAppend extends in by n bytes, returning the whole slice, and the
+// appended part
+func sliceForAppend(in []byte, n int) ([]byte, []byte) {
+	total := len(in) + n
+	if cap(in) >= total {
+		head := in[:total]
+
+		return head, head[len(in):]
+	}
+
+	head := make([]byte, total)
+	copy(head, in)
+
+	return head, head[len(in):]
+}
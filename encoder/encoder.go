@@ -13,6 +13,10 @@ const (
 	TypeAES256GCM96 = uint32(0)
 	// TypeChaCha20Poly1305 is ChaCha20-Poly1305 encoding type
 	TypeChaCha20Poly1305 = uint32(1)
+	// TypeXChaCha20Poly1305 is XChaCha20-Poly1305 encoding type
+	TypeXChaCha20Poly1305 = uint32(2)
+	// TypeAES256GCMSIV is AES256-GCM-SIV encoding type
+	TypeAES256GCMSIV = uint32(3)
 )
 
 // Encoder can encode and decode data
@@ -43,8 +47,10 @@ var (
 	ErrKeyTooSmall         = errors.New("key too small")
 
 	encoders = map[uint32]Entry{
-		TypeAES256GCM96:      {factory: NewAES256GCM96, name: "AES256-GCM96"},
-		TypeChaCha20Poly1305: {factory: NewChaCha20Poly1305, name: "ChaCha20-Poly1305"},
+		TypeAES256GCM96:       {factory: NewAES256GCM96, name: "AES256-GCM96"},
+		TypeChaCha20Poly1305:  {factory: NewChaCha20Poly1305, name: "ChaCha20-Poly1305"},
+		TypeXChaCha20Poly1305: {factory: NewXChaCha20Poly1305, name: "XChaCha20-Poly1305"},
+		TypeAES256GCMSIV:      {factory: NewAES256GCMSIV, name: "AES256-GCM-SIV"},
 	}
 )
 
@@ -95,3 +95,31 @@ func TestUnegisterEncoderError(t *testing.T) {
 		return
 	}
 }
+
+func TestFindEncoder(t *testing.T) {
+	tt := []struct {
+		name    string
+		encType uint32
+		err     error
+	}{
+		{name: "aes256-gcm96", encType: encoder.TypeAES256GCM96},
+		{name: "ChaCha20-Poly1305", encType: encoder.TypeChaCha20Poly1305},
+		{name: "xchacha20-poly1305", encType: encoder.TypeXChaCha20Poly1305},
+		{name: "AES256-GCM-SIV", encType: encoder.TypeAES256GCMSIV},
+		{name: "rot13", err: encoder.ErrEncoderNotFound},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			encType, err := encoder.FindEncoder(tc.name)
+			if err := tester.AssertError(tc.err, err); err != nil {
+				t.Error(err)
+			}
+
+			if err == nil && encType != tc.encType {
+				t.Errorf("unexpected encoder type. Expected: %d, received: %d", tc.encType, encType)
+			}
+		})
+	}
+}
@@ -18,10 +18,14 @@ func NewHMAC256(key []byte) HMAC256 {
 	return HMAC256(key[:HMAC256KeySize])
 }
 
-func (key HMAC256) Sum(plaintext []byte) ([]byte, error) {
+// Sum calculates HMAC-256 of all data parts, as if they were concatenated
+func (key HMAC256) Sum(parts ...[]byte) ([]byte, error) {
 	nonce := hmac.New(sha256.New, []byte(key))
-	if _, err := nonce.Write(plaintext); err != nil {
-		return nil, fmt.Errorf("calculating HMAC IV: %w", err)
+
+	for _, part := range parts {
+		if _, err := nonce.Write(part); err != nil {
+			return nil, fmt.Errorf("calculating HMAC IV: %w", err)
+		}
 	}
 
 	return nonce.Sum(nil), nil
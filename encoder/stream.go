@@ -0,0 +1,293 @@
+package encoder
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/julian7/redact/securemem"
+)
+
+const (
+	// DefaultSegmentSize is the default plaintext size of a stream segment
+	DefaultSegmentSize = 64 * 1024
+	// MaxSegmentSize is the largest plaintext segment size accepted
+	MaxSegmentSize = 16 * 1024 * 1024
+
+	segmentFinal = byte(1)
+)
+
+var (
+	ErrAuthentication  = errors.New("message authentication failed")
+	ErrSegmentSize     = errors.New("invalid segment size")
+	ErrStreamClosed    = errors.New("stream already closed")
+	ErrStreamTruncated = errors.New("stream truncated")
+)
+
+// StreamWriter encrypts a stream into a sequence of fixed-size authenticated
+// segments, in the spirit of the STREAM construction.
+//
+// Plaintext is buffered in guarded memory, which is wiped on Close.
+//
+// Every segment is sealed separately, with its own nonce. The associated data
+// of a segment contains an optional stream header, its index, a flag marking
+// the final segment, and the authentication tag of the previous segment.
+// The header is not written into the stream, only authenticated. This way segments cannot be
+// reordered, dropped, or spliced from other streams, and truncation is
+// detected by the missing final segment.
+//
+// Nonces are still convergent: they are calculated from the HMAC of the
+// segment's associated data and plaintext, and they are stored in front of
+// each segment.
+type StreamWriter struct {
+	enc     *Encoder
+	aead    cipher.AEAD
+	writer  io.Writer
+	header  []byte
+	secret  *securemem.Buffer
+	buf     []byte
+	out     []byte
+	prevTag []byte
+	index   uint64
+	closed  bool
+}
+
+// StreamReader decrypts a stream written by StreamWriter. It keeps at most one
+// segment in memory. Plaintext is buffered in guarded memory, which is wiped
+// when the end of the stream is reached, or on Destroy.
+type StreamReader struct {
+	enc      *Encoder
+	aead     cipher.AEAD
+	reader   *bufio.Reader
+	header   []byte
+	wireSize int
+	secret   *securemem.Buffer
+	buf      []byte
+	plain    []byte
+	prevTag  []byte
+	index    uint64
+	done     bool
+	closed   bool
+}
+
+// NewStreamWriter returns a StreamWriter, which writes encrypted segments of
+// segmentSize bytes of plaintext into writer. Header is authenticated with
+// every segment. Close must be called to flush the final segment.
+func (e *Encoder) NewStreamWriter(writer io.Writer, segmentSize int, header []byte) (*StreamWriter, error) {
+	if segmentSize <= 0 || segmentSize > MaxSegmentSize {
+		return nil, fmt.Errorf("%w: %d", ErrSegmentSize, segmentSize)
+	}
+
+	aead, err := e.enc.AEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	secret := securemem.New(segmentSize)
+
+	return &StreamWriter{
+		enc:    e,
+		aead:   aead,
+		writer: writer,
+		header: bytes.Clone(header),
+		secret: secret,
+		buf:    secret.Bytes()[:0:segmentSize],
+		out:    make([]byte, 0, aead.NonceSize()+segmentSize+aead.Overhead()),
+	}, nil
+}
+
+// Write buffers plaintext, and emits all completed segments
+func (w *StreamWriter) Write(data []byte) (int, error) {
+	if w.closed {
+		return 0, ErrStreamClosed
+	}
+
+	written := 0
+
+	for len(data) > 0 {
+		if len(w.buf) == cap(w.buf) {
+			if err := w.seal(0); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(w.buf[len(w.buf):cap(w.buf)], data)
+		w.buf = w.buf[:len(w.buf)+n]
+		data = data[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+// Close emits the final segment, and wipes the plaintext buffer. It doesn't
+// close the underlying writer.
+func (w *StreamWriter) Close() error {
+	if w.closed {
+		return ErrStreamClosed
+	}
+
+	defer w.Destroy()
+
+	return w.seal(segmentFinal)
+}
+
+// Destroy wipes the plaintext buffer without emitting the final segment. The
+// stream can't be written afterwards.
+func (w *StreamWriter) Destroy() {
+	w.closed = true
+	w.buf = nil
+	w.secret.Destroy()
+}
+
+func (w *StreamWriter) seal(flags byte) error {
+	ad := segmentAD(w.header, w.index, flags, w.prevTag)
+
+	sum, err := w.enc.hmac.Sum(ad, w.buf)
+	if err != nil {
+		return fmt.Errorf("getting HMAC nonce: %w", err)
+	}
+
+	nonce := sum[:w.aead.NonceSize()]
+	w.out = append(w.out[:0], nonce...)
+	w.out = w.aead.Seal(w.out, nonce, w.buf, ad)
+	w.prevTag = append(w.prevTag[:0], w.out[len(w.out)-w.aead.Overhead():]...)
+
+	if _, err := w.writer.Write(w.out); err != nil {
+		return err
+	}
+
+	w.buf = w.buf[:0]
+	w.index++
+
+	return nil
+}
+
+// NewStreamReader returns a StreamReader, which reads segments of segmentSize
+// bytes of plaintext from reader. Header must match the one the stream was
+// written with.
+func (e *Encoder) NewStreamReader(reader io.Reader, segmentSize int, header []byte) (*StreamReader, error) {
+	if segmentSize <= 0 || segmentSize > MaxSegmentSize {
+		return nil, fmt.Errorf("%w: %d", ErrSegmentSize, segmentSize)
+	}
+
+	aead, err := e.enc.AEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	wireSize := aead.NonceSize() + segmentSize + aead.Overhead()
+
+	secret := securemem.New(segmentSize)
+
+	return &StreamReader{
+		enc:      e,
+		aead:     aead,
+		reader:   bufio.NewReaderSize(reader, wireSize+1),
+		header:   bytes.Clone(header),
+		wireSize: wireSize,
+		secret:   secret,
+		buf:      secret.Bytes()[:0],
+	}, nil
+}
+
+// Read reads decrypted plaintext. Segments are authenticated before their
+// contents are returned, but a truncated stream is reported only when its end
+// is reached.
+func (r *StreamReader) Read(data []byte) (int, error) {
+	if r.closed {
+		return 0, ErrStreamClosed
+	}
+
+	for len(r.plain) == 0 {
+		if r.done {
+			r.buf = nil
+			r.secret.Destroy()
+
+			return 0, io.EOF
+		}
+
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(data, r.plain)
+	r.plain = r.plain[n:]
+
+	return n, nil
+}
+
+// Destroy wipes the plaintext buffer. The stream can't be read afterwards.
+func (r *StreamReader) Destroy() {
+	r.closed = true
+	r.buf = nil
+	r.plain = nil
+	r.secret.Destroy()
+}
+
+func (r *StreamReader) open() error {
+	var flags byte
+
+	segment, err := r.reader.Peek(r.wireSize + 1)
+	if len(segment) > r.wireSize {
+		segment = segment[:r.wireSize]
+	} else {
+		if !errors.Is(err, io.EOF) {
+			return fmt.Errorf("reading segment %d: %w", r.index, err)
+		}
+
+		flags |= segmentFinal
+	}
+
+	nonceSize := r.aead.NonceSize()
+
+	switch {
+	case len(segment) == 0:
+		return fmt.Errorf("%w: no final segment", ErrStreamTruncated)
+	case len(segment) < nonceSize+r.aead.Overhead():
+		return fmt.Errorf("segment %d: %w", r.index, ErrCyphertextSmall)
+	}
+
+	ad := segmentAD(r.header, r.index, flags, r.prevTag)
+	nonce := segment[:nonceSize]
+
+	plain, err := r.aead.Open(r.buf[:0], nonce, segment[nonceSize:], ad)
+	if err != nil {
+		return fmt.Errorf("decrypting segment %d with %s: %w", r.index, r.enc.name, ErrAuthentication)
+	}
+
+	hmacSum, err := r.enc.hmac.Sum(ad, plain)
+	if err != nil {
+		return fmt.Errorf("getting HMAC nonce: %w", err)
+	}
+
+	if !bytes.Equal(hmacSum[:nonceSize], nonce) {
+		return fmt.Errorf("segment %d: %w", r.index, ErrInvalidChecksum)
+	}
+
+	r.prevTag = append(r.prevTag[:0], segment[len(segment)-r.aead.Overhead():]...)
+
+	if _, err := r.reader.Discard(len(segment)); err != nil {
+		return fmt.Errorf("reading segment %d: %w", r.index, err)
+	}
+
+	r.plain = plain
+	r.done = flags&segmentFinal != 0
+	r.index++
+
+	return nil
+}
+
+func segmentAD(header []byte, index uint64, flags byte, prevTag []byte) []byte {
+	ad := make([]byte, 0, len(header)+9+len(prevTag))
+	ad = append(ad, header...)
+	ad = binary.BigEndian.AppendUint64(ad, index)
+	ad = append(ad, flags)
+
+	return append(ad, prevTag...)
+}
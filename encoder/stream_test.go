@@ -0,0 +1,316 @@
+package encoder_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/julian7/redact/encoder"
+	"github.com/julian7/redact/securemem"
+)
+
+const testSegmentSize = 64
+
+// segmentWireSize is the encrypted size of a full segment with AES256-GCM96
+const segmentWireSize = 12 + testSegmentSize + 16
+
+func streamEncode(t *testing.T, plaintext, header []byte) []byte {
+	t.Helper()
+
+	enc, err := encoder.NewEncoder(encoder.TypeAES256GCM96, sampleKey)
+	if err != nil {
+		t.Fatalf("cannot create encoder: %v", err)
+	}
+
+	out := &bytes.Buffer{}
+
+	writer, err := enc.NewStreamWriter(out, testSegmentSize, header)
+	if err != nil {
+		t.Fatalf("cannot create stream writer: %v", err)
+	}
+
+	// write in odd chunks to exercise segment boundaries
+	for len(plaintext) > 0 {
+		n := min(len(plaintext), 7)
+
+		if _, err := writer.Write(plaintext[:n]); err != nil {
+			t.Fatalf("cannot write stream: %v", err)
+		}
+
+		plaintext = plaintext[n:]
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("cannot close stream: %v", err)
+	}
+
+	return out.Bytes()
+}
+
+func streamDecode(ciphertext, header []byte) ([]byte, error) {
+	enc, err := encoder.NewEncoder(encoder.TypeAES256GCM96, sampleKey)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := enc.NewStreamReader(bytes.NewReader(ciphertext), testSegmentSize, header)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(reader)
+}
+
+func TestStreamRoundtrip(t *testing.T) {
+	tt := []struct {
+		name     string
+		size     int
+		segments int
+	}{
+		{name: "empty", size: 0, segments: 1},
+		{name: "single byte", size: 1, segments: 1},
+		{name: "short segment", size: testSegmentSize - 1, segments: 1},
+		{name: "full segment", size: testSegmentSize, segments: 1},
+		{name: "segment and a byte", size: testSegmentSize + 1, segments: 2},
+		{name: "multiple segments", size: 3 * testSegmentSize, segments: 3},
+		{name: "large", size: len(samplePlaintext), segments: (len(samplePlaintext) + testSegmentSize - 1) / testSegmentSize},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			plaintext := bytes.Repeat(samplePlaintext, 2)[:tc.size]
+			ciphertext := streamEncode(t, plaintext, nil)
+
+			expectedLen := tc.size + tc.segments*(12+16)
+			if len(ciphertext) != expectedLen {
+				t.Errorf("unexpected ciphertext size. Expected: %d, received: %d", expectedLen, len(ciphertext))
+			}
+
+			if again := streamEncode(t, plaintext, nil); !bytes.Equal(ciphertext, again) {
+				t.Error("encryption is not convergent")
+			}
+
+			received, err := streamDecode(ciphertext, nil)
+			if err != nil {
+				t.Errorf("cannot decode: %v", err)
+
+				return
+			}
+
+			if !bytes.Equal(received, plaintext) {
+				t.Errorf("decoded message not matching: %q", received)
+			}
+		})
+	}
+}
+
+func TestStreamEncoders(t *testing.T) {
+	plaintext := samplePlaintext[:3*testSegmentSize+10]
+
+	for _, encType := range []uint32{
+		encoder.TypeAES256GCM96,
+		encoder.TypeChaCha20Poly1305,
+		encoder.TypeXChaCha20Poly1305,
+		encoder.TypeAES256GCMSIV,
+	} {
+		t.Run(encoder.Name(encType), func(t *testing.T) {
+			enc, err := encoder.NewEncoder(encType, sampleKey)
+			if err != nil {
+				t.Fatalf("cannot create encoder: %v", err)
+			}
+
+			out := &bytes.Buffer{}
+
+			writer, err := enc.NewStreamWriter(out, testSegmentSize, nil)
+			if err != nil {
+				t.Fatalf("cannot create stream writer: %v", err)
+			}
+
+			if _, err := writer.Write(plaintext); err != nil {
+				t.Fatalf("cannot write stream: %v", err)
+			}
+
+			if err := writer.Close(); err != nil {
+				t.Fatalf("cannot close stream: %v", err)
+			}
+
+			reader, err := enc.NewStreamReader(out, testSegmentSize, nil)
+			if err != nil {
+				t.Fatalf("cannot create stream reader: %v", err)
+			}
+
+			received, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("cannot decode: %v", err)
+			}
+
+			if !bytes.Equal(received, plaintext) {
+				t.Errorf("decoded message not matching: %q", received)
+			}
+		})
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	plaintext := samplePlaintext[:3*testSegmentSize+10]
+	other := bytes.ToUpper(plaintext)
+	ciphertext := streamEncode(t, plaintext, nil)
+	otherCiphertext := streamEncode(t, other, nil)
+
+	segment := func(data []byte, idx int) []byte {
+		return data[idx*segmentWireSize : min(len(data), (idx+1)*segmentWireSize)]
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	tt := []struct {
+		name       string
+		ciphertext []byte
+	}{
+		{name: "empty", ciphertext: []byte{}},
+		{name: "truncated at segment boundary", ciphertext: ciphertext[:3*segmentWireSize]},
+		{name: "truncated in segment", ciphertext: ciphertext[:len(ciphertext)-1]},
+		{
+			name: "reordered segments",
+			ciphertext: join(
+				segment(ciphertext, 1), segment(ciphertext, 0), segment(ciphertext, 2), segment(ciphertext, 3),
+			),
+		},
+		{
+			name:       "dropped segment",
+			ciphertext: join(segment(ciphertext, 0), segment(ciphertext, 2), segment(ciphertext, 3)),
+		},
+		{
+			name: "spliced streams",
+			ciphertext: join(
+				segment(ciphertext, 0), segment(otherCiphertext, 1), segment(otherCiphertext, 2), segment(otherCiphertext, 3),
+			),
+		},
+		{
+			name: "extended stream",
+			ciphertext: join(
+				ciphertext, segment(otherCiphertext, 3),
+			),
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := streamDecode(tc.ciphertext, nil)
+			if err == nil {
+				t.Error("tampered stream decoded successfully")
+			}
+		})
+	}
+}
+
+func TestStreamHeader(t *testing.T) {
+	plaintext := samplePlaintext[:2*testSegmentSize+10]
+	header := []byte("header")
+	ciphertext := streamEncode(t, plaintext, header)
+
+	if bytes.Equal(ciphertext, streamEncode(t, plaintext, nil)) {
+		t.Error("header doesn't change ciphertext")
+	}
+
+	tt := []struct {
+		name   string
+		header []byte
+		err    error
+	}{
+		{name: "matching header", header: header, err: nil},
+		{name: "missing header", header: nil, err: encoder.ErrAuthentication},
+		{name: "different header", header: []byte("Header"), err: encoder.ErrAuthentication},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			received, err := streamDecode(ciphertext, tc.header)
+			if !errors.Is(err, tc.err) {
+				t.Errorf("unexpected error. Expected: %v, received: %v", tc.err, err)
+			}
+
+			if err == nil && !bytes.Equal(received, plaintext) {
+				t.Errorf("decoded message not matching: %q", received)
+			}
+		})
+	}
+}
+
+func TestStreamSegmentSize(t *testing.T) {
+	enc, err := encoder.NewEncoder(encoder.TypeAES256GCM96, sampleKey)
+	if err != nil {
+		t.Fatalf("cannot create encoder: %v", err)
+	}
+
+	for _, size := range []int{-1, 0, encoder.MaxSegmentSize + 1} {
+		if _, err := enc.NewStreamWriter(io.Discard, size, nil); !errors.Is(err, encoder.ErrSegmentSize) {
+			t.Errorf("unexpected writer error for segment size %d: %v", size, err)
+		}
+
+		if _, err := enc.NewStreamReader(bytes.NewReader(nil), size, nil); !errors.Is(err, encoder.ErrSegmentSize) {
+			t.Errorf("unexpected reader error for segment size %d: %v", size, err)
+		}
+	}
+}
+
+func TestStreamWipe(t *testing.T) {
+	before := securemem.Outstanding()
+	ciphertext := streamEncode(t, bytes.Repeat([]byte("secret"), 50), nil)
+
+	if received := securemem.Outstanding(); received != before {
+		t.Errorf("plaintext buffer of writer is not wiped on close: %d buffers", received-before)
+	}
+
+	if _, err := streamDecode(ciphertext, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if received := securemem.Outstanding(); received != before {
+		t.Errorf("plaintext buffer of reader is not wiped at end of stream: %d buffers", received-before)
+	}
+
+	enc, err := encoder.NewEncoder(encoder.TypeAES256GCM96, sampleKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := enc.NewStreamReader(bytes.NewReader(ciphertext), testSegmentSize, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := reader.Read(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	reader.Destroy()
+
+	if received := securemem.Outstanding(); received != before {
+		t.Errorf("plaintext buffer of reader is not wiped on destroy: %d buffers", received-before)
+	}
+
+	if _, err := reader.Read(make([]byte, 10)); !errors.Is(err, encoder.ErrStreamClosed) {
+		t.Errorf("destroyed reader is readable: %v", err)
+	}
+
+	writer, err := enc.NewStreamWriter(io.Discard, testSegmentSize, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer.Destroy()
+
+	if received := securemem.Outstanding(); received != before {
+		t.Errorf("plaintext buffer of writer is not wiped on destroy: %d buffers", received-before)
+	}
+
+	if _, err := writer.Write([]byte("secret")); !errors.Is(err, encoder.ErrStreamClosed) {
+		t.Errorf("destroyed writer is writable: %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package encoder
+
+import (
+	"crypto/cipher"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// XChaCha20Poly1305KeySize is the standard key size for XChaCha20-Poly1305 encoding
+	XChaCha20Poly1305KeySize = 32
+)
+
+// XChaCha20Poly1305 can take a KeyHandler, and stores encryption and HMAC keys
+type XChaCha20Poly1305 []byte
+
+// NewXChaCha20Poly1305 returns a new Encoder initialized with a key handler
+func NewXChaCha20Poly1305(key []byte) (AEAD, error) {
+	if len(key) < XChaCha20Poly1305KeySize {
+		return nil, ErrKeyTooSmall
+	}
+
+	return XChaCha20Poly1305(key[:XChaCha20Poly1305KeySize]), nil
+}
+
+func (enc XChaCha20Poly1305) KeySize() int { return XChaCha20Poly1305KeySize }
+
+func (enc XChaCha20Poly1305) String() string { return "XChaCha20-Poly1305" }
+
+func (enc XChaCha20Poly1305) AEAD() (cipher.AEAD, error) {
+	return chacha20poly1305.NewX(enc)
+}
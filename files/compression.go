@@ -0,0 +1,175 @@
+package files
+
+import (
+	"bufio"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/julian7/redact/encoder"
+)
+
+// Compression schemes. They are recorded in the file header's flags.
+const (
+	// CompressionNone doesn't compress files
+	CompressionNone = uint8(iota)
+	// CompressionDeflate compresses files with DEFLATE (RFC 1951)
+	CompressionDeflate
+
+	// DefaultCompressionMinSize is the smallest file size compressed by
+	// default. Smaller files rarely benefit from compression.
+	DefaultCompressionMinSize = 1024
+	// MaxCompressionMinSize is the largest minimum file size, one segment.
+	// The encoder buffers this much input to decide about compression.
+	MaxCompressionMinSize = encoder.DefaultSegmentSize
+
+	compressionShift = 8
+	compressionMask  = uint32(0xf) << compressionShift
+)
+
+var (
+	ErrInvalidCompression = errors.New("invalid compression")
+	ErrTrailingData       = errors.New("trailing data after compressed stream")
+
+	compressionNames = map[uint8]string{
+		CompressionNone:    "none",
+		CompressionDeflate: "deflate",
+	}
+)
+
+// Compression describes how plaintext is compressed before padding and
+// encryption
+type Compression struct {
+	Scheme uint8
+	// MinSize is the smallest plaintext size to be compressed. Smaller files
+	// are stored uncompressed.
+	MinSize int
+}
+
+// ParseCompression parses compression descriptions, like "none", "deflate",
+// or "deflate:4096" (with minimum file size)
+func ParseCompression(desc string) (Compression, error) {
+	name, param, hasParam := strings.Cut(strings.ToLower(strings.TrimSpace(desc)), ":")
+
+	for scheme, schemeName := range compressionNames {
+		if name != schemeName {
+			continue
+		}
+
+		if scheme == CompressionNone {
+			if hasParam {
+				return Compression{}, fmt.Errorf("%w: %q takes no parameters", ErrInvalidCompression, desc)
+			}
+
+			return Compression{Scheme: scheme}, nil
+		}
+
+		minSize := DefaultCompressionMinSize
+
+		if hasParam {
+			var err error
+
+			minSize, err = strconv.Atoi(param)
+			if err != nil || minSize < 0 || minSize > MaxCompressionMinSize {
+				return Compression{}, fmt.Errorf(
+					"%w: %q requires a minimum size between 0 and %d",
+					ErrInvalidCompression,
+					desc,
+					MaxCompressionMinSize,
+				)
+			}
+		}
+
+		return Compression{Scheme: scheme, MinSize: minSize}, nil
+	}
+
+	return Compression{}, fmt.Errorf("%w: unknown scheme %q", ErrInvalidCompression, desc)
+}
+
+func (c Compression) validate() error {
+	if _, ok := compressionNames[c.Scheme]; !ok {
+		return fmt.Errorf("%w: unknown scheme %d", ErrInvalidCompression, c.Scheme)
+	}
+
+	if c.MinSize < 0 || c.MinSize > MaxCompressionMinSize {
+		return fmt.Errorf("%w: invalid minimum size %d", ErrInvalidCompression, c.MinSize)
+	}
+
+	return nil
+}
+
+func (c Compression) String() string {
+	name, ok := compressionNames[c.Scheme]
+	if !ok {
+		return "unknown"
+	}
+
+	if c.Scheme != CompressionNone {
+		return fmt.Sprintf("%s:%d", name, c.MinSize)
+	}
+
+	return name
+}
+
+// Compression returns the compression scheme the file is encoded with
+func (h *FileHeader) Compression() uint8 {
+	return uint8((h.Flags & compressionMask) >> compressionShift)
+}
+
+func (h *FileHeader) setCompression(scheme uint8) {
+	h.Flags = h.Flags&^compressionMask | uint32(scheme)<<compressionShift&compressionMask
+}
+
+// shouldCompress decides whether input is large enough to be compressed. It
+// peeks into the input, which has to be buffered for at least MinSize bytes.
+func (c Compression) shouldCompress(in *bufio.Reader) (bool, error) {
+	if c.Scheme == CompressionNone {
+		return false, nil
+	}
+
+	_, err := in.Peek(c.MinSize)
+	if err == nil {
+		return true, nil
+	}
+
+	if errors.Is(err, io.EOF) {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// newCompressor returns a writer compressing data into writer
+func newCompressor(writer io.Writer) (io.WriteCloser, error) {
+	return flate.NewWriter(writer, flate.BestCompression)
+}
+
+// decompress decompresses reader into writer. Compressed streams are
+// self-delimiting, therefore the rest of the input is written to trailer,
+// which receives padding, if any.
+func decompress(writer io.Writer, reader io.Reader, trailer io.Writer) error {
+	in := bufio.NewReader(reader)
+
+	inflater := flate.NewReader(in)
+	defer inflater.Close()
+
+	if err := copyStream(writer, inflater, "decompressing stream", "writing decoded stream"); err != nil {
+		return err
+	}
+
+	return copyStream(trailer, in, "decoding stream", "checking padding")
+}
+
+// noTrailer rejects any data written to it
+type noTrailer struct{}
+
+func (noTrailer) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	return 0, ErrTrailingData
+}
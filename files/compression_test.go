@@ -0,0 +1,153 @@
+package files_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/julian7/redact/files"
+)
+
+func TestParseCompression(t *testing.T) {
+	tt := []struct {
+		desc        string
+		compression files.Compression
+		err         error
+	}{
+		{desc: "none", compression: files.Compression{Scheme: files.CompressionNone}},
+		{desc: "Deflate", compression: files.Compression{Scheme: files.CompressionDeflate, MinSize: files.DefaultCompressionMinSize}},
+		{desc: "deflate:0", compression: files.Compression{Scheme: files.CompressionDeflate}},
+		{desc: "deflate:4096", compression: files.Compression{Scheme: files.CompressionDeflate, MinSize: 4096}},
+		{desc: "deflate:65536", compression: files.Compression{Scheme: files.CompressionDeflate, MinSize: 65536}},
+		{desc: "deflate:-1", err: files.ErrInvalidCompression},
+		{desc: "deflate:65537", err: files.ErrInvalidCompression},
+		{desc: "none:1", err: files.ErrInvalidCompression},
+		{desc: "lzma", err: files.ErrInvalidCompression},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			compression, err := files.ParseCompression(tc.desc)
+			if !errors.Is(err, tc.err) {
+				t.Errorf("unexpected error. Expected: %v, received: %v", tc.err, err)
+			}
+
+			if compression != tc.compression {
+				t.Errorf("unexpected compression. Expected: %v, received: %v", tc.compression, compression)
+			}
+		})
+	}
+}
+
+func TestCompressionRoundtrip(t *testing.T) { //nolint:funlen
+	k, err := genGitRepo()
+	if err != nil {
+		t.Error(err)
+
+		return
+	}
+
+	if err := writeKey(k); err != nil {
+		t.Error(err)
+
+		return
+	}
+
+	if err := k.Load(false); err != nil {
+		t.Error(err)
+
+		return
+	}
+
+	large := bytes.Repeat([]byte(samplePlaintext), 1000)
+
+	tt := []struct {
+		name        string
+		compression string
+		padding     string
+		plaintext   []byte
+		compressed  bool
+	}{
+		{name: "empty", compression: "deflate:0", padding: "none", plaintext: []byte{}, compressed: true},
+		{name: "below threshold", compression: "deflate", padding: "none", plaintext: []byte(samplePlaintext), compressed: false},
+		{name: "above threshold", compression: "deflate:16", padding: "none", plaintext: []byte(samplePlaintext), compressed: true},
+		{name: "multiple segments", compression: "deflate", padding: "none", plaintext: large, compressed: true},
+		{name: "padded", compression: "deflate", padding: "pow2", plaintext: large, compressed: true},
+		{name: "padded below threshold", compression: "deflate", padding: "block:64", plaintext: []byte("foo\x80"), compressed: false},
+		{name: "disabled", compression: "none", padding: "none", plaintext: large, compressed: false},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			compression, err := files.ParseCompression(tc.compression)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			padding, err := files.ParsePadding(tc.padding)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opts := &files.FileOptions{Compression: compression, Padding: padding}
+			ciphertext := bytes.NewBuffer(nil)
+
+			if err := k.EncodeWithOptions(0, 1, opts, bytes.NewReader(tc.plaintext), ciphertext); err != nil {
+				t.Fatalf("cannot encode: %v", err)
+			}
+
+			hdr, err := k.FileStatus(bytes.NewReader(ciphertext.Bytes()))
+			if err != nil {
+				t.Fatalf("cannot read header: %v", err)
+			}
+
+			if compressed := hdr.Compression() != files.CompressionNone; compressed != tc.compressed {
+				t.Errorf("unexpected compression in header: %d", hdr.Compression())
+			}
+
+			if hdr.Padding() != padding.Scheme {
+				t.Errorf("unexpected padding scheme in header: %d", hdr.Padding())
+			}
+
+			if tc.compressed && len(tc.plaintext) > 10000 && ciphertext.Len() > len(tc.plaintext)/10 {
+				t.Errorf("ciphertext is not compressed: %d bytes", ciphertext.Len())
+			}
+
+			out := bytes.NewBuffer(nil)
+			if err := k.Decode(ciphertext, out); err != nil {
+				t.Fatalf("cannot decode: %v", err)
+			}
+
+			if !bytes.Equal(out.Bytes(), tc.plaintext) {
+				t.Errorf("decoded plaintext not matching: %q", out.Bytes())
+			}
+		})
+	}
+}
+
+func TestCompressionLimit(t *testing.T) {
+	k, err := genGitRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeKey(k); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k.Load(false); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &files.FileOptions{
+		Compression: files.Compression{Scheme: files.CompressionDeflate, MinSize: files.MaxCompressionMinSize + 1},
+	}
+
+	err = k.EncodeWithOptions(0, 1, opts, bytes.NewReader([]byte(samplePlaintext)), io.Discard)
+	if !errors.Is(err, files.ErrInvalidCompression) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
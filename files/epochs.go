@@ -0,0 +1,223 @@
+package files
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/julian7/redact/securemem"
+)
+
+var (
+	ErrInvalidEpochRange = errors.New("invalid epoch range")
+	ErrNoKeysInRange     = errors.New("no keys in epoch range")
+	ErrRetireLatest      = errors.New("latest key epoch cannot be retired")
+)
+
+// EpochRange selects key epochs between From and To, inclusive. Zero values
+// mean no lower or upper bound, therefore the zero value selects all epochs.
+type EpochRange struct {
+	From uint32
+	To   uint32
+}
+
+// ParseEpochRange parses epoch ranges in the format of EpochRange.String
+func ParseEpochRange(text string) (EpochRange, error) {
+	text = strings.TrimSpace(text)
+	if text == "" || text == "all" {
+		return EpochRange{}, nil
+	}
+
+	from, to, isRange := strings.Cut(text, "-")
+
+	first, err := strconv.ParseUint(from, 10, 32)
+	if err != nil || first == 0 {
+		return EpochRange{}, fmt.Errorf("%w: %q", ErrInvalidEpochRange, text)
+	}
+
+	ret := EpochRange{From: uint32(first)}
+
+	switch {
+	case !isRange:
+		ret.To = ret.From
+	case to != "":
+		last, err := strconv.ParseUint(to, 10, 32)
+		if err != nil || uint32(last) < ret.From {
+			return EpochRange{}, fmt.Errorf("%w: %q", ErrInvalidEpochRange, text)
+		}
+
+		ret.To = uint32(last)
+	}
+
+	return ret, nil
+}
+
+// All returns whether the range selects all epochs
+func (r EpochRange) All() bool {
+	return r.From <= 1 && r.To == 0
+}
+
+// Contains returns whether an epoch is in the range
+func (r EpochRange) Contains(epoch uint32) bool {
+	return epoch >= r.From && (r.To == 0 || epoch <= r.To)
+}
+
+// Intersect returns epochs selected by both ranges. Disjoint ranges return
+// an empty range.
+func (r EpochRange) Intersect(other EpochRange) EpochRange {
+	ret := EpochRange{From: max(r.From, other.From), To: r.To}
+	if ret.To == 0 || (other.To != 0 && other.To < ret.To) {
+		ret.To = other.To
+	}
+
+	return ret
+}
+
+// String returns the range as "all", "N" (a single epoch), "N-" (epoch N
+// and later ones), or "N-M"
+func (r EpochRange) String() string {
+	switch {
+	case r.All():
+		return "all"
+	case r.To == 0:
+		return fmt.Sprintf("%d-", max(r.From, 1))
+	case r.From == r.To:
+		return strconv.FormatUint(uint64(r.From), 10)
+	default:
+		return fmt.Sprintf("%d-%d", max(r.From, 1), r.To)
+	}
+}
+
+// Subset returns a secret key with keys of epochs in a range only. Keys are
+// shared with the original secret key.
+func (k *SecretKey) Subset(epochs EpochRange) (*SecretKey, error) {
+	ret := &SecretKey{store: k.store, Keys: map[uint32]KeyHandler{}}
+
+	for epoch, key := range k.Keys {
+		if !epochs.Contains(epoch) {
+			continue
+		}
+
+		ret.Keys[epoch] = key
+		if epoch > ret.LatestKey {
+			ret.LatestKey = epoch
+		}
+	}
+
+	if len(ret.Keys) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoKeysInRange, epochs)
+	}
+
+	return ret, nil
+}
+
+// Retire removes the key of an epoch, and wipes it from memory. The latest
+// key can't be retired.
+func (k *SecretKey) Retire(epoch uint32) error {
+	key, ok := k.Keys[epoch]
+	if !ok {
+		return fmt.Errorf("%w: key epoch %d not found", ErrInvalidKey, epoch)
+	}
+
+	if epoch == k.LatestKey {
+		return fmt.Errorf("%w: %d", ErrRetireLatest, epoch)
+	}
+
+	delete(k.Keys, epoch)
+	key.Destroy()
+
+	return nil
+}
+
+// PurgeBackups removes a retired key epoch from snapshots of the key store.
+// Snapshots are rewritten without the epoch in their original form, protected
+// ones with the passphrase of the secret key. Snapshots without other epochs,
+// and protected snapshots which can't be read with the passphrase, are wiped.
+// It returns the number of snapshots changed.
+func (k *SecretKey) PurgeBackups(epoch uint32) (int, error) {
+	store, ok := k.store.(BackupStore)
+	if !ok {
+		return 0, nil
+	}
+
+	backups, err := store.Backups()
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+
+	for _, name := range backups {
+		changed, err := k.purgeBackup(store, name, epoch)
+		if err != nil {
+			return purged, fmt.Errorf("key backup %s: %w", name, err)
+		}
+
+		if changed {
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// purgeBackup removes a key epoch from a snapshot
+func (k *SecretKey) purgeBackup(store BackupStore, name string, epoch uint32) (bool, error) {
+	data, err := store.LoadBackup(name)
+	if err != nil {
+		return false, err
+	}
+
+	defer securemem.Wipe(data)
+
+	protected := isProtectedKeyFile(data)
+	backup := &SecretKey{}
+
+	defer backup.Destroy()
+
+	if protected && k.Protected() {
+		if err := backup.Protect(k.passphrase.Bytes()); err != nil {
+			return false, err
+		}
+	}
+
+	if err := backup.Read(bytes.NewReader(data)); err != nil {
+		if protected && (errors.Is(err, ErrWrongPassphrase) || errors.Is(err, ErrPassphraseRequired)) {
+			return true, store.RemoveBackup(name)
+		}
+
+		return false, err
+	}
+
+	key, ok := backup.Keys[epoch]
+	if !ok {
+		return false, nil
+	}
+
+	if len(backup.Keys) == 1 {
+		return true, store.RemoveBackup(name)
+	}
+
+	delete(backup.Keys, epoch)
+	key.Destroy()
+
+	backup.LatestKey = slices.Max(slices.Collect(maps.Keys(backup.Keys)))
+
+	save := backup.SaveTo
+	if protected {
+		save = backup.saveProtectedTo
+	}
+
+	buf := securemem.New(0)
+	defer buf.Destroy()
+
+	if err := save(buf); err != nil {
+		return false, err
+	}
+
+	return true, store.SaveBackup(name, buf.Bytes())
+}
@@ -0,0 +1,262 @@
+package files_test
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/julian7/redact/files"
+)
+
+func TestParseEpochRange(t *testing.T) {
+	tt := []struct {
+		name     string
+		text     string
+		expected files.EpochRange
+		err      error
+	}{
+		{name: "empty", text: "", expected: files.EpochRange{}},
+		{name: "all", text: "all", expected: files.EpochRange{}},
+		{name: "single", text: "3", expected: files.EpochRange{From: 3, To: 3}},
+		{name: "since", text: "3-", expected: files.EpochRange{From: 3}},
+		{name: "range", text: "2-5", expected: files.EpochRange{From: 2, To: 5}},
+		{name: "zero", text: "0", err: files.ErrInvalidEpochRange},
+		{name: "reversed", text: "5-2", err: files.ErrInvalidEpochRange},
+		{name: "garbage", text: "two", err: files.ErrInvalidEpochRange},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			received, err := files.ParseEpochRange(tc.text)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if received != tc.expected {
+				t.Errorf("expected %+v, received %+v", tc.expected, received)
+			}
+
+			if tc.err == nil {
+				roundtrip, err := files.ParseEpochRange(received.String())
+				if err != nil || roundtrip != received {
+					t.Errorf("%q doesn't roundtrip: %+v, %v", received.String(), roundtrip, err)
+				}
+			}
+		})
+	}
+}
+
+func TestEpochRangeIntersect(t *testing.T) {
+	tt := []struct {
+		name     string
+		a, b     files.EpochRange
+		expected files.EpochRange
+	}{
+		{name: "all", a: files.EpochRange{}, b: files.EpochRange{From: 2}, expected: files.EpochRange{From: 2}},
+		{name: "since", a: files.EpochRange{From: 2}, b: files.EpochRange{From: 1, To: 4}, expected: files.EpochRange{From: 2, To: 4}},
+		{name: "single", a: files.EpochRange{From: 3, To: 3}, b: files.EpochRange{From: 2, To: 5}, expected: files.EpochRange{From: 3, To: 3}},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if received := tc.a.Intersect(tc.b); received != tc.expected {
+				t.Errorf("expected %+v, received %+v", tc.expected, received)
+			}
+
+			if received := tc.b.Intersect(tc.a); received != tc.expected {
+				t.Errorf("expected %+v reversed, received %+v", tc.expected, received)
+			}
+		})
+	}
+}
+
+func TestSubset(t *testing.T) {
+	k := &files.SecretKey{}
+	for range 4 {
+		if err := k.Generate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subset, err := k.Subset(files.EpochRange{From: 2, To: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(subset.Keys) != 2 || subset.LatestKey != 3 || subset.Keys[1] != nil {
+		t.Errorf("unexpected subset: %v", subset)
+	}
+
+	if _, err := k.Subset(files.EpochRange{From: 5}); !errors.Is(err, files.ErrNoKeysInRange) {
+		t.Errorf("unexpected error of empty subset: %v", err)
+	}
+}
+
+func TestRetire(t *testing.T) {
+	k := &files.SecretKey{}
+	for range 3 {
+		if err := k.Generate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tt := []struct {
+		name  string
+		epoch uint32
+		err   error
+	}{
+		{name: "latest", epoch: 3, err: files.ErrRetireLatest},
+		{name: "missing", epoch: 4, err: files.ErrInvalidKey},
+		{name: "retired", epoch: 2},
+		{name: "retired again", epoch: 2, err: files.ErrInvalidKey},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if err := k.Retire(tc.epoch); !errors.Is(err, tc.err) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	if len(k.Keys) != 2 || k.LatestKey != 3 || k.Keys[2] != nil {
+		t.Errorf("unexpected key after retirement: %v", k)
+	}
+}
+
+func TestPurgeBackups(t *testing.T) {
+	store, err := files.NewFileStore(filepath.Join(t.TempDir(), "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := files.NewSecretKeyIn(store)
+
+	for range 3 {
+		if err := k.Generate(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := k.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tt := []struct {
+		epoch    uint32
+		purged   int
+		expected [][]uint32
+	}{
+		{epoch: 2, purged: 1, expected: [][]uint32{{1}, {1}}},
+		{epoch: 1, purged: 2, expected: [][]uint32{}},
+	}
+
+	for _, tc := range tt {
+		purged, err := k.PurgeBackups(tc.epoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if purged != tc.purged {
+			t.Errorf("epoch %d: unexpected number of purged backups: %d", tc.epoch, purged)
+		}
+
+		backups, err := store.Backups()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		epochs := [][]uint32{}
+
+		for _, name := range backups {
+			data, err := store.LoadBackup(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			backup := &files.SecretKey{}
+			if err := backup.Read(bytes.NewReader(data)); err != nil {
+				t.Fatalf("epoch %d: reading backup %s: %v", tc.epoch, name, err)
+			}
+
+			epochs = append(epochs, slices.Sorted(maps.Keys(backup.Keys)))
+
+			if backup.LatestKey != slices.Max(epochs[len(epochs)-1]) {
+				t.Errorf("epoch %d: unexpected latest key of backup %s: %d", tc.epoch, name, backup.LatestKey)
+			}
+		}
+
+		if !reflect.DeepEqual(epochs, tc.expected) {
+			t.Errorf("epoch %d: unexpected backups. Expected: %v, received: %v", tc.epoch, tc.expected, epochs)
+		}
+	}
+}
+
+func TestPurgeProtectedBackups(t *testing.T) {
+	store, err := files.NewFileStore(filepath.Join(t.TempDir(), "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := files.NewSecretKeyIn(store)
+
+	for _, passphrase := range []string{"old passphrase", "new passphrase"} {
+		if err := k.Protect([]byte(passphrase)); err != nil {
+			t.Fatal(err)
+		}
+
+		for range 2 {
+			if err := k.Generate(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := k.Save(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// snapshots: {1} and {1,2} with the old passphrase, {1,2,3} with the new one
+	purged, err := k.PurgeBackups(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if purged != 3 {
+		t.Errorf("unexpected number of purged backups: %d", purged)
+	}
+
+	backups, err := store.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(backups) != 1 {
+		t.Fatalf("unexpected backups: %v", backups)
+	}
+
+	data, err := store.LoadBackup(backups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup := &files.SecretKey{}
+	if err := backup.Protect([]byte("new passphrase")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backup.Read(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if epochs := slices.Sorted(maps.Keys(backup.Keys)); !reflect.DeepEqual(epochs, []uint32{2, 3}) {
+		t.Errorf("unexpected epochs of protected backup: %v", epochs)
+	}
+}
@@ -1,35 +1,171 @@
 package files
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/julian7/redact/encoder"
+	"github.com/julian7/redact/securemem"
 )
 
 const (
-	// FileMagic magic string the encoded file starts with
-	FileMagic = "\000REDACTED\000"
+	// FileMagic magic string the encoded file starts with. It is followed by
+	// a single byte of file format version.
+	FileMagic = "\000REDACTED"
+	// FileFormatV0 is the original file format, where the whole payload is
+	// sealed as a single AEAD message
+	FileFormatV0 = 0
+	// FileFormatV1 is the streaming file format, where the payload is split
+	// into fixed-size authenticated segments
+	FileFormatV1 = 1
+	// FileFormatV2 is the streaming file format with flags, where the whole
+	// file header is authenticated with every segment
+	FileFormatV2 = 2
+	// FileFormatCurrent is the file format used for encoding
+	FileFormatCurrent = FileFormatV2
+
+	// FlagPathBound marks files bound to their repository-relative path
+	FlagPathBound = uint32(1) << 0
+
+	// supportedFlags is the set of header flags this version understands
+	supportedFlags = FlagPathBound | paddingMask | compressionMask
 )
 
 var (
 	ErrAlreadyEncoded     = errors.New("already encoded")
+	ErrAuthentication     = errors.New("file header or contents failed authentication")
 	ErrExcessiveRights    = errors.New("excessive rights")
 	ErrInsufficientRights = errors.New("insufficient rights")
+	ErrInvalidFileFormat  = errors.New("invalid file format version")
 	ErrInvalidPreamble    = errors.New("invalid file preamble")
+	ErrPathRequired       = errors.New("file path is required for path bound files")
 )
 
+// FileOptions contains optional, per-file settings of encoding and decoding
+type FileOptions struct {
+	// Path is the repository-relative path of the file
+	Path string
+	// BindPath binds the encoded file to Path: it can be decoded only with
+	// the same path provided
+	BindPath bool
+	// Padding pads plaintext before encryption
+	Padding Padding
+	// Compression compresses plaintext before padding and encryption
+	Compression Compression
+}
+
+// FileHeader is the plaintext header of an encoded file
 type FileHeader struct {
-	Preamble [10]byte
+	Preamble [9]byte
+	Version  uint8
 	Encoding uint32
 	Epoch    uint32
+	// SegmentSize is the plaintext size of a segment (format v1 and up)
+	SegmentSize uint32
+	// Flags are optional features of the encoded file (format v2 and up)
+	Flags uint32
+}
+
+// fileHeaderV0 is the fixed part of the file header, shared by all formats
+type fileHeaderV0 struct {
+	Preamble [9]byte
+	Version  uint8
+	Encoding uint32
+	Epoch    uint32
+}
+
+// NewFileHeader returns a file header of the current file format
+func NewFileHeader(encodingFormat uint32, epoch uint32) *FileHeader {
+	header := &FileHeader{
+		Version:     FileFormatCurrent,
+		Encoding:    encodingFormat,
+		Epoch:       epoch,
+		SegmentSize: encoder.DefaultSegmentSize,
+	}
+	copy(header.Preamble[:], FileMagic)
+
+	return header
+}
+
+// MarshalBinary returns the header's binary representation
+func (h *FileHeader) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, len(h.Preamble)+21)
+	data = append(data, h.Preamble[:]...)
+	data = append(data, h.Version)
+	data = binary.BigEndian.AppendUint32(data, h.Encoding)
+	data = binary.BigEndian.AppendUint32(data, h.Epoch)
+
+	if h.Version >= FileFormatV1 {
+		data = binary.BigEndian.AppendUint32(data, h.SegmentSize)
+	}
+
+	if h.Version >= FileFormatV2 {
+		data = binary.BigEndian.AppendUint32(data, h.Flags)
+	}
+
+	return data, nil
+}
+
+// PathBound returns whether the file is bound to its path
+func (h *FileHeader) PathBound() bool {
+	return h.Flags&FlagPathBound != 0
+}
+
+// associatedData returns data to be authenticated with the payload. From
+// format v2, it is the whole serialized header, therefore tampering with any
+// of its fields makes decryption fail. Path bound files authenticate their
+// path too, which is also mixed into nonce derivation this way.
+func (h *FileHeader) associatedData(opts *FileOptions) ([]byte, error) {
+	if h.Version < FileFormatV2 {
+		return nil, nil
+	}
+
+	data, err := h.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	if !h.PathBound() {
+		return data, nil
+	}
+
+	if opts == nil || opts.Path == "" {
+		return nil, ErrPathRequired
+	}
+
+	filePath := CleanPath(opts.Path)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(filePath))) //nolint:gosec
+	data = append(data, filePath...)
+
+	return data, nil
+}
+
+// CleanPath normalizes a repository-relative path for path binding
+func CleanPath(filePath string) string {
+	return strings.TrimPrefix(path.Clean(filepath.ToSlash(filePath)), "./")
 }
 
 // Encode encodes an IO stream into another IO stream
 func (k *SecretKey) Encode(encodingFormat uint32, epoch uint32, reader io.Reader, writer io.Writer) error {
+	return k.EncodeWithOptions(encodingFormat, epoch, nil, reader, writer)
+}
+
+// EncodeWithOptions encodes an IO stream into another IO stream, with
+// optional per-file settings
+func (k *SecretKey) EncodeWithOptions(
+	encodingFormat uint32,
+	epoch uint32,
+	opts *FileOptions,
+	reader io.Reader,
+	writer io.Writer,
+) error {
 	key, err := k.Key(epoch)
 	if err != nil {
 		return fmt.Errorf("encoding stream: %w", err)
@@ -40,30 +176,104 @@ func (k *SecretKey) Encode(encodingFormat uint32, epoch uint32, reader io.Reader
 		return fmt.Errorf("setting up encoder: %w", err)
 	}
 
-	in, err := io.ReadAll(reader)
-	if err != nil {
+	header := NewFileHeader(encodingFormat, epoch)
+
+	var (
+		padding     Padding
+		compression Compression
+	)
+
+	if opts != nil {
+		if opts.BindPath {
+			header.Flags |= FlagPathBound
+		}
+
+		padding = opts.Padding
+		compression = opts.Compression
+	}
+
+	if err := padding.validate(); err != nil {
+		return err
+	}
+
+	if err := compression.validate(); err != nil {
+		return err
+	}
+
+	in := bufio.NewReaderSize(reader, max(compression.MinSize, 4096))
+
+	magic, err := in.Peek(len(FileMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
 		return fmt.Errorf("reading input stream: %w", err)
 	}
 
-	if bytes.Equal(in[0:len(FileMagic)], []byte(FileMagic)) {
+	if bytes.Equal(magic, []byte(FileMagic)) {
 		return ErrAlreadyEncoded
 	}
 
-	out, err := enc.Encode(in)
+	compress, err := compression.shouldCompress(in)
 	if err != nil {
-		return fmt.Errorf("encoding stream: %w", err)
+		return fmt.Errorf("reading input stream: %w", err)
 	}
 
-	header := FileHeader{Encoding: encodingFormat, Epoch: epoch}
-	copy(header.Preamble[:], FileMagic)
+	header.setPadding(padding.Scheme)
 
-	err = binary.Write(writer, binary.BigEndian, header)
+	if compress {
+		header.setCompression(compression.Scheme)
+	}
+
+	hdr, err := header.MarshalBinary()
 	if err != nil {
-		return fmt.Errorf("writing file header: %w", err)
+		return fmt.Errorf("building file header: %w", err)
 	}
 
-	_, err = writer.Write(out)
+	ad, err := header.associatedData(opts)
 	if err != nil {
+		return fmt.Errorf("building file header: %w", err)
+	}
+
+	stream, err := enc.NewStreamWriter(writer, int(header.SegmentSize), ad)
+	if err != nil {
+		return fmt.Errorf("encoding stream: %w", err)
+	}
+
+	defer stream.Destroy()
+
+	if _, err = writer.Write(hdr); err != nil {
+		return fmt.Errorf("writing file header: %w", err)
+	}
+
+	var plain io.Writer = stream
+
+	padder := &padWriter{Writer: stream, padding: padding}
+	if padding.Scheme != PaddingNone {
+		plain = padder
+	}
+
+	if compress {
+		compressor, err := newCompressor(plain)
+		if err != nil {
+			return fmt.Errorf("compressing stream: %w", err)
+		}
+
+		if err = copyStream(compressor, in, "reading input stream", "compressing stream"); err != nil {
+			return err
+		}
+
+		if err = compressor.Close(); err != nil {
+			return fmt.Errorf("compressing stream: %w", err)
+		}
+	} else if err = copyStream(plain, in, "reading input stream", "writing encoded stream"); err != nil {
+		return err
+	}
+
+	if padding.Scheme != PaddingNone {
+		if err = padder.Close(); err != nil {
+			return fmt.Errorf("writing encoded stream: %w", err)
+		}
+	}
+
+	if err = stream.Close(); err != nil {
 		return fmt.Errorf("writing encoded stream: %w", err)
 	}
 
@@ -72,6 +282,12 @@ func (k *SecretKey) Encode(encodingFormat uint32, epoch uint32, reader io.Reader
 
 // Decode encodes an IO stream into another IO stream
 func (k *SecretKey) Decode(reader io.Reader, writer io.Writer) error {
+	return k.DecodeWithOptions(nil, reader, writer)
+}
+
+// DecodeWithOptions decodes an IO stream into another IO stream, with
+// optional per-file settings. Path bound files require opts.Path.
+func (k *SecretKey) DecodeWithOptions(opts *FileOptions, reader io.Reader, writer io.Writer) error {
 	var header FileHeader
 
 	err := k.readHeader(reader, &header)
@@ -89,6 +305,83 @@ func (k *SecretKey) Decode(reader io.Reader, writer io.Writer) error {
 		return fmt.Errorf("setting up encoder: %w", err)
 	}
 
+	if header.Version == FileFormatV0 {
+		return decodeSingleShot(enc, reader, writer)
+	}
+
+	if unknown := header.Flags &^ supportedFlags; unknown != 0 {
+		return fmt.Errorf("%w: unsupported flags %#x", ErrInvalidFileFormat, unknown)
+	}
+
+	if _, ok := paddingNames[header.Padding()]; !ok {
+		return fmt.Errorf("%w: unsupported padding scheme %d", ErrInvalidFileFormat, header.Padding())
+	}
+
+	if _, ok := compressionNames[header.Compression()]; !ok {
+		return fmt.Errorf("%w: unsupported compression scheme %d", ErrInvalidFileFormat, header.Compression())
+	}
+
+	ad, err := header.associatedData(opts)
+	if err != nil {
+		return fmt.Errorf("building file header: %w", err)
+	}
+
+	stream, err := enc.NewStreamReader(reader, int(header.SegmentSize), ad)
+	if err != nil {
+		return fmt.Errorf("decoding stream: %w", err)
+	}
+
+	defer stream.Destroy()
+
+	err = decodePayload(&header, stream, writer)
+
+	if ad != nil && errors.Is(err, encoder.ErrAuthentication) {
+		if header.PathBound() {
+			return fmt.Errorf("%w, or file is bound to a path other than %q: %w", ErrAuthentication, opts.Path, err)
+		}
+
+		return fmt.Errorf("%w: %w", ErrAuthentication, err)
+	}
+
+	return err
+}
+
+// decodePayload decodes the decrypted payload, removing compression and
+// padding
+func decodePayload(header *FileHeader, reader io.Reader, writer io.Writer) error {
+	padded := header.Padding() != PaddingNone
+	compressed := header.Compression() != CompressionNone
+
+	var trailer io.Writer = noTrailer{}
+
+	unpadder := &unpadWriter{Writer: writer}
+
+	switch {
+	case compressed && padded:
+		unpadder.Writer = trailer
+		trailer = unpadder
+	case padded:
+		writer = unpadder
+	}
+
+	var err error
+
+	if compressed {
+		err = decompress(writer, reader, trailer)
+	} else {
+		err = copyStream(writer, reader, "decoding stream", "writing decoded stream")
+	}
+
+	if err == nil && padded {
+		err = unpadder.Close()
+	}
+
+	return err
+}
+
+// decodeSingleShot decodes the payload of a v0 file, which has to be read
+// into memory as a whole
+func decodeSingleShot(enc *encoder.Encoder, reader io.Reader, writer io.Writer) error {
 	in, err := io.ReadAll(reader)
 	if err != nil {
 		return fmt.Errorf("reading stream: %w", err)
@@ -99,6 +392,8 @@ func (k *SecretKey) Decode(reader io.Reader, writer io.Writer) error {
 		return fmt.Errorf("decoding stream: %w", err)
 	}
 
+	defer securemem.Wipe(out)
+
 	_, err = writer.Write(out)
 	if err != nil {
 		return fmt.Errorf("writing decoded stream: %w", err)
@@ -120,14 +415,80 @@ func (k *SecretKey) FileStatus(reader io.Reader) (*FileHeader, error) {
 }
 
 func (k *SecretKey) readHeader(reader io.Reader, header *FileHeader) error {
-	err := binary.Read(reader, binary.BigEndian, header)
+	var fixed fileHeaderV0
+
+	data := make([]byte, binary.Size(fixed))
+
+	n, err := io.ReadFull(reader, data)
 	if err != nil {
+		// files shorter than a header are not encrypted, unless they start
+		// with the preamble
+		short := errors.Is(err, io.EOF) || n > 0 && errors.Is(err, io.ErrUnexpectedEOF)
+		if short && !bytes.HasPrefix(data[:n], []byte(FileMagic)) {
+			return ErrInvalidPreamble
+		}
+
+		return fmt.Errorf("reading file header: %w", err)
+	}
+
+	if _, err := binary.Decode(data, binary.BigEndian, &fixed); err != nil {
 		return fmt.Errorf("reading file header: %w", err)
 	}
 
-	if !bytes.Equal(header.Preamble[:], []byte(FileMagic)) {
+	if !bytes.Equal(fixed.Preamble[:], []byte(FileMagic)) {
 		return ErrInvalidPreamble
 	}
 
+	*header = FileHeader{
+		Preamble: fixed.Preamble,
+		Version:  fixed.Version,
+		Encoding: fixed.Encoding,
+		Epoch:    fixed.Epoch,
+	}
+
+	switch header.Version {
+	case FileFormatV0:
+	case FileFormatV1:
+		if err := binary.Read(reader, binary.BigEndian, &header.SegmentSize); err != nil {
+			return fmt.Errorf("reading file header: %w", err)
+		}
+	case FileFormatV2:
+		ext := struct{ SegmentSize, Flags uint32 }{}
+		if err := binary.Read(reader, binary.BigEndian, &ext); err != nil {
+			return fmt.Errorf("reading file header: %w", err)
+		}
+
+		header.SegmentSize = ext.SegmentSize
+		header.Flags = ext.Flags
+	default:
+		return fmt.Errorf("%w: %d", ErrInvalidFileFormat, header.Version)
+	}
+
 	return nil
 }
+
+// copyStream copies data from reader to writer, annotating read and write
+// errors separately
+func copyStream(writer io.Writer, reader io.Reader, readMsg, writeMsg string) error {
+	secret := securemem.New(32 * 1024)
+	defer secret.Destroy()
+
+	buf := secret.Bytes()
+
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			if _, werr := writer.Write(buf[:n]); werr != nil {
+				return fmt.Errorf("%s: %w", writeMsg, werr)
+			}
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return fmt.Errorf("%s: %w", readMsg, err)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package files_test
 import (
 	"bytes"
 	"crypto/cipher"
+	_ "embed"
 	"encoding/binary"
 	"errors"
 	"io"
@@ -16,6 +17,11 @@ import (
 )
 
 var (
+	//go:embed fixtures/ciphertext-v1.bin
+	sampleStreamCiphertext string
+	//go:embed fixtures/ciphertext-v2.bin
+	sampleAuthCiphertext string
+
 	samplePlaintext = "Lorem ipsum dolor sit amet, consectetur adipiscing " +
 		"elit. Fusce odio lacus, feugiat a elit ut, hendrerit venenatis en" +
 		"im. Duis vehicula, purus nec cursus iaculis, purus magna elementu" +
@@ -88,6 +94,25 @@ func genCiphertextHeader(encType, epoch uint32) []byte {
 	return out.Bytes()
 }
 
+func genStreamHeader(version uint8, encType, epoch, segmentSize uint32) []byte {
+	out := bytes.NewBuffer(nil)
+	_, _ = out.WriteString("\x00REDACTED")
+	_ = out.WriteByte(version)
+	_ = binary.Write(out, binary.BigEndian, encType)
+	_ = binary.Write(out, binary.BigEndian, epoch)
+	_ = binary.Write(out, binary.BigEndian, segmentSize)
+
+	return out.Bytes()
+}
+
+// tamperHeader overwrites a 32-bit header field at offset
+func tamperHeader(ciphertext string, offset int, value uint32) string {
+	out := []byte(ciphertext)
+	binary.BigEndian.PutUint32(out[offset:], value)
+
+	return string(out)
+}
+
 func ensureFailingEncoder() uint32 {
 	failEncoderID := uint32(50000)
 	_ = encoder.RegisterEncoder(failEncoderID, "failing-encoder", newFailingEncoder)
@@ -180,6 +205,25 @@ func TestEncode(t *testing.T) { //nolint:funlen
 			err:     errors.New("writing encoded stream: unexpected EOF"),
 			output:  "",
 		},
+		{
+			name:    "already encoded",
+			enctype: 0,
+			epoch:   1,
+			reader:  bytes.NewReader([]byte(sampleStreamCiphertext)),
+			writer:  bytes.NewBuffer(nil),
+			err:     errors.New("already encoded"),
+			output:  "",
+		},
+		{
+			name:    "short input",
+			enctype: 0,
+			epoch:   1,
+			reader:  bytes.NewReader([]byte("foo")),
+			writer:  bytes.NewBuffer(nil),
+			err:     nil,
+			output: "\x00REDACTED\x02\x00\x00\x00\x00\x00\x00\x00\x01\x00\x01\x00\x00\x00\x00\x00\x00" +
+				";\x14\xa7\n/h3^\xe6\x1fC4\x1c\x19\xf6b\x89\xae\x9dJ\f\xa2_\xdb\x87O\x85)\xfc\xb0>",
+		},
 		{
 			name:    "successful",
 			enctype: 0,
@@ -187,7 +231,7 @@ func TestEncode(t *testing.T) { //nolint:funlen
 			reader:  bytes.NewReader([]byte(samplePlaintext)),
 			writer:  bytes.NewBuffer(nil),
 			err:     nil,
-			output:  sampleCiphertext,
+			output:  sampleAuthCiphertext,
 		},
 	}
 
@@ -295,6 +339,94 @@ func TestDecode(t *testing.T) { //nolint:funlen
 			err:    errors.New("writing decoded stream: short write"),
 			output: "",
 		},
+		{
+			name:   "unknown file format",
+			reader: bytes.NewReader(genStreamHeader(5, 0, 1, 65536)),
+			writer: bytes.NewBuffer(nil),
+			err:    errors.New("invalid file format version: 5"),
+			output: "",
+		},
+		{
+			name:   "invalid segment size",
+			reader: bytes.NewReader(genStreamHeader(1, 0, 1, 0)),
+			writer: bytes.NewBuffer(nil),
+			err:    errors.New("decoding stream: invalid segment size: 0"),
+			output: "",
+		},
+		{
+			name:   "truncated stream",
+			reader: bytes.NewReader(genStreamHeader(1, 0, 1, 65536)),
+			writer: bytes.NewBuffer(nil),
+			err:    errors.New("decoding stream: stream truncated: no final segment"),
+			output: "",
+		},
+		{
+			name:   "tampered stream",
+			reader: bytes.NewReader([]byte(sampleStreamCiphertext[:len(sampleStreamCiphertext)-1] + "\x00")),
+			writer: bytes.NewBuffer(nil),
+			err: errors.New(
+				"decoding stream: decrypting segment 0 with AES256-GCM96: message authentication failed",
+			),
+			output: "",
+		},
+		{
+			name:   "tampered epoch in v1 header",
+			reader: bytes.NewReader([]byte(tamperHeader(sampleStreamCiphertext, 14, 2))),
+			writer: bytes.NewBuffer(nil),
+			err:    nil,
+			output: samplePlaintext,
+		},
+		{
+			name:   "tampered epoch",
+			reader: bytes.NewReader([]byte(tamperHeader(sampleAuthCiphertext, 14, 2))),
+			writer: bytes.NewBuffer(nil),
+			err: errors.New(
+				"file header or contents failed authentication: " +
+					"decoding stream: decrypting segment 0 with AES256-GCM96: message authentication failed",
+			),
+			output: "",
+		},
+		{
+			name:   "tampered encoding",
+			reader: bytes.NewReader([]byte(tamperHeader(sampleAuthCiphertext, 10, encoder.TypeChaCha20Poly1305))),
+			writer: bytes.NewBuffer(nil),
+			err: errors.New(
+				"file header or contents failed authentication: " +
+					"decoding stream: decrypting segment 0 with ChaCha20-Poly1305: message authentication failed",
+			),
+			output: "",
+		},
+		{
+			name:   "tampered segment size",
+			reader: bytes.NewReader([]byte(tamperHeader(sampleAuthCiphertext, 18, 65537))),
+			writer: bytes.NewBuffer(nil),
+			err: errors.New(
+				"file header or contents failed authentication: " +
+					"decoding stream: decrypting segment 0 with AES256-GCM96: message authentication failed",
+			),
+			output: "",
+		},
+		{
+			name:   "unsupported padding",
+			reader: bytes.NewReader([]byte(tamperHeader(sampleAuthCiphertext, 22, 0xf0))),
+			writer: bytes.NewBuffer(nil),
+			err:    errors.New("invalid file format version: unsupported padding scheme 15"),
+			output: "",
+		},
+		{
+			name:   "unsupported compression",
+			reader: bytes.NewReader([]byte(tamperHeader(sampleAuthCiphertext, 22, 0xf00))),
+			writer: bytes.NewBuffer(nil),
+			err:    errors.New("invalid file format version: unsupported compression scheme 15"),
+			output: "",
+		},
+		{
+			name:   "unsupported flags",
+			reader: bytes.NewReader([]byte(tamperHeader(sampleAuthCiphertext, 22, 0x80000000))),
+			writer: bytes.NewBuffer(nil),
+			err:    errors.New("invalid file format version: unsupported flags 0x80000000"),
+			output: "",
+		},
 		{
 			name:   "successful",
 			reader: bytes.NewReader([]byte(sampleCiphertext)),
@@ -302,6 +434,20 @@ func TestDecode(t *testing.T) { //nolint:funlen
 			err:    nil,
 			output: samplePlaintext,
 		},
+		{
+			name:   "successful stream",
+			reader: bytes.NewReader([]byte(sampleStreamCiphertext)),
+			writer: bytes.NewBuffer(nil),
+			err:    nil,
+			output: samplePlaintext,
+		},
+		{
+			name:   "successful authenticated stream",
+			reader: bytes.NewReader([]byte(sampleAuthCiphertext)),
+			writer: bytes.NewBuffer(nil),
+			err:    nil,
+			output: samplePlaintext,
+		},
 	}
 	for _, tc := range tt {
 		tc := tc
@@ -351,7 +497,12 @@ var testFileStatusCases = []struct {
 	epoch    uint32
 }{
 	{"plaintext", samplePlaintext, files.ErrInvalidPreamble, 0},
+	{"empty", "", files.ErrInvalidPreamble, 0},
+	{"short plaintext", "foo", files.ErrInvalidPreamble, 0},
+	{"truncated", sampleCiphertext[:len(files.FileMagic)+2], io.ErrUnexpectedEOF, 0},
 	{"encrypted", sampleCiphertext, nil, 1},
+	{"encrypted stream", sampleStreamCiphertext, nil, 1},
+	{"encrypted authenticated stream", sampleAuthCiphertext, nil, 1},
 }
 
 func TestFileStatus(t *testing.T) {
@@ -407,3 +558,86 @@ func TestFileStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestPathBinding(t *testing.T) { //nolint:funlen
+	k, err := genGitRepo()
+	if err != nil {
+		t.Error(err)
+
+		return
+	}
+
+	if err := writeKey(k); err != nil {
+		t.Error(err)
+
+		return
+	}
+
+	if err := k.Load(false); err != nil {
+		t.Error(err)
+
+		return
+	}
+
+	encode := func(opts *files.FileOptions) ([]byte, error) {
+		out := bytes.NewBuffer(nil)
+		err := k.EncodeWithOptions(0, 1, opts, bytes.NewReader([]byte(samplePlaintext)), out)
+
+		return out.Bytes(), err
+	}
+
+	bound, err := encode(&files.FileOptions{Path: "secrets/prod.key", BindPath: true})
+	if err != nil {
+		t.Errorf("cannot encode path bound file: %v", err)
+
+		return
+	}
+
+	unbound, err := encode(&files.FileOptions{Path: "secrets/prod.key"})
+	if err != nil {
+		t.Errorf("cannot encode file: %v", err)
+
+		return
+	}
+
+	if bytes.Equal(bound[len(files.FileMagic)+17:], unbound[len(files.FileMagic)+17:]) {
+		t.Error("path binding doesn't change ciphertext")
+	}
+
+	if _, err := encode(&files.FileOptions{BindPath: true}); !errors.Is(err, files.ErrPathRequired) {
+		t.Errorf("unexpected error encoding without path: %v", err)
+	}
+
+	tt := []struct {
+		name       string
+		ciphertext []byte
+		opts       *files.FileOptions
+		err        error
+	}{
+		{name: "same path", ciphertext: bound, opts: &files.FileOptions{Path: "secrets/prod.key"}},
+		{name: "same path normalized", ciphertext: bound, opts: &files.FileOptions{Path: "./secrets//prod.key"}},
+		{name: "no path", ciphertext: bound, opts: nil, err: files.ErrPathRequired},
+		{name: "other path", ciphertext: bound, opts: &files.FileOptions{Path: "secrets/staging.key"}, err: files.ErrAuthentication},
+		{name: "unbound file with any path", ciphertext: unbound, opts: &files.FileOptions{Path: "secrets/staging.key"}},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			out := bytes.NewBuffer(nil)
+
+			err := k.DecodeWithOptions(tc.opts, bytes.NewReader(tc.ciphertext), out)
+			if !errors.Is(err, tc.err) {
+				t.Errorf("unexpected error. Expected: %v, received: %v", tc.err, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if err := checkString(samplePlaintext, out.String()); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
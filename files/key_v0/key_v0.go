@@ -4,6 +4,8 @@ import (
 	"crypto/rand"
 	"crypto/sha1" //nolint:gosec
 	"fmt"
+
+	"github.com/julian7/redact/securemem"
 )
 
 const (
@@ -13,8 +15,8 @@ const (
 
 // KeyV0 stores an AES-256 and a HMAC SHA-256 key
 type KeyV0 struct {
-	Epoch      uint32
-	SecretData [SecretSize]byte
+	Epoch  uint32
+	secret *securemem.Buffer
 }
 
 // NewKey creates a new Key struct based on parameter input
@@ -34,14 +36,25 @@ func (k *KeyV0) Type() uint32 {
 	return 0
 }
 
-// Secret returns Secret key
+// Secret returns Secret key. It is kept in guarded memory, which is
+// allocated on first use.
 func (k *KeyV0) Secret() []byte {
-	return k.SecretData[:]
+	if k.secret == nil {
+		k.secret = securemem.New(SecretSize)
+	}
+
+	return k.secret.Bytes()
+}
+
+// Destroy wipes the secret key from memory
+func (k *KeyV0) Destroy() {
+	k.secret.Destroy()
+	k.secret = nil
 }
 
 // Generate generates new keys to secret key
 func (k *KeyV0) Generate() error {
-	_, err := rand.Read(k.SecretData[:])
+	_, err := rand.Read(k.Secret())
 	if err != nil {
 		return fmt.Errorf("generating Secret key: %w", err)
 	}
@@ -70,7 +70,7 @@ func TestString(t *testing.T) {
 	key := keyv0.KeyV0{
 		Epoch: 1,
 	}
-	copy(key.SecretData[:], sampleCode+sampleCode+sampleCode)
+	copy(key.Secret(), sampleCode+sampleCode+sampleCode)
 	strval := key.String()    // nolint:ifshort
 	expected := "#1 0006b8c0" // nolint:ifshort
 
@@ -0,0 +1,188 @@
+package keyv1
+
+import (
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/julian7/redact/securemem"
+)
+
+const (
+	// SecretSize is the size of a 32-byte AES and 64-byte HMAC key (for SHA-256 and HMAC SHA-256)
+	SecretSize = 96
+	// AlgorithmAEADHMAC is the algorithm of keys consisting of a 256-bit AEAD
+	// key, and a 512-bit HMAC SHA-256 key
+	AlgorithmAEADHMAC = "AEAD-256+HMAC-SHA256"
+
+	// maxFieldSize is the maximum size of variable length fields
+	maxFieldSize = 0xffff
+)
+
+var ErrInvalidRecord = errors.New("invalid key record")
+
+// Metadata contains descriptive information of a key
+type Metadata struct {
+	// Created is the creation time of the key
+	Created time.Time
+	// Creator identifies who created the key (like "Name <email>")
+	Creator string
+	// Algorithm describes how the key is used
+	Algorithm string
+	// Expires is the expiry time of the key (zero if it doesn't expire)
+	Expires time.Time
+	// Comment is a free-form comment
+	Comment string
+}
+
+// Expired returns whether the key is expired at a certain time
+func (m Metadata) Expired(now time.Time) bool {
+	return !m.Expires.IsZero() && !now.Before(m.Expires)
+}
+
+// KeyV1 stores an AES-256 and a HMAC SHA-256 key, with metadata
+type KeyV1 struct {
+	Epoch  uint32
+	secret *securemem.Buffer
+	Meta   Metadata
+}
+
+// NewKey creates a new Key struct based on parameter input
+func NewKey(epoch uint32, meta Metadata) *KeyV1 {
+	if meta.Algorithm == "" {
+		meta.Algorithm = AlgorithmAEADHMAC
+	}
+
+	return &KeyV1{Epoch: epoch, Meta: meta}
+}
+
+// FromSecret creates a key from existing secret data, with no metadata other
+// than the algorithm. It is used for migrating v0 keys.
+func FromSecret(epoch uint32, secret []byte) *KeyV1 {
+	key := NewKey(epoch, Metadata{})
+	copy(key.Secret(), secret)
+
+	return key
+}
+
+// Version returns key epoch version
+func (k *KeyV1) Version() uint32 {
+	return k.Epoch
+}
+
+// Type returns key format type
+func (k *KeyV1) Type() uint32 {
+	return 1
+}
+
+// Secret returns Secret key. It is kept in guarded memory, which is
+// allocated on first use.
+func (k *KeyV1) Secret() []byte {
+	if k.secret == nil {
+		k.secret = securemem.New(SecretSize)
+	}
+
+	return k.secret.Bytes()
+}
+
+// Destroy wipes the secret key from memory
+func (k *KeyV1) Destroy() {
+	k.secret.Destroy()
+	k.secret = nil
+}
+
+// Metadata returns key metadata
+func (k *KeyV1) Metadata() Metadata {
+	return k.Meta
+}
+
+// Generate generates new keys to secret key
+func (k *KeyV1) Generate() error {
+	_, err := rand.Read(k.Secret())
+	if err != nil {
+		return fmt.Errorf("generating Secret key: %w", err)
+	}
+
+	return nil
+}
+
+func (k *KeyV1) String() string {
+	return fmt.Sprintf("#%d %s", k.Epoch, fmt.Sprintf("%x", sha1.Sum(k.Secret()))[:8]) //nolint:gosec
+}
+
+// AppendBinary appends the key's binary representation to data:
+//
+//	epoch uint32 | secret [96]byte | created int64 | expires int64 |
+//	algorithm, creator, comment (each: length uint16 | bytes)
+//
+// Times are Unix timestamps in seconds, 0 meaning no value.
+func (k *KeyV1) AppendBinary(data []byte) ([]byte, error) {
+	// growing data once doesn't leave copies of the secret behind
+	data = slices.Grow(data, 4+SecretSize+8+8+3*2+len(k.Meta.Algorithm)+len(k.Meta.Creator)+len(k.Meta.Comment))
+	data = binary.BigEndian.AppendUint32(data, k.Epoch)
+	data = append(data, k.Secret()...)
+	data = binary.BigEndian.AppendUint64(data, uint64(unixTime(k.Meta.Created))) //nolint:gosec
+	data = binary.BigEndian.AppendUint64(data, uint64(unixTime(k.Meta.Expires))) //nolint:gosec
+
+	for _, field := range []string{k.Meta.Algorithm, k.Meta.Creator, k.Meta.Comment} {
+		if len(field) > maxFieldSize {
+			return nil, fmt.Errorf("%w: metadata of key #%d is too long", ErrInvalidRecord, k.Epoch)
+		}
+
+		data = binary.BigEndian.AppendUint16(data, uint16(len(field))) //nolint:gosec
+		data = append(data, field...)
+	}
+
+	return data, nil
+}
+
+// Parse reads a key from the beginning of data, returning the rest of data
+func Parse(data []byte) (*KeyV1, []byte, error) {
+	const fixedSize = 4 + SecretSize + 8 + 8
+
+	if len(data) < fixedSize {
+		return nil, nil, fmt.Errorf("%w: truncated", ErrInvalidRecord)
+	}
+
+	key := &KeyV1{Epoch: binary.BigEndian.Uint32(data)}
+	copy(key.Secret(), data[4:])
+	key.Meta.Created = fromUnixTime(int64(binary.BigEndian.Uint64(data[4+SecretSize:])))   //nolint:gosec
+	key.Meta.Expires = fromUnixTime(int64(binary.BigEndian.Uint64(data[4+SecretSize+8:]))) //nolint:gosec
+	data = data[fixedSize:]
+
+	for _, field := range []*string{&key.Meta.Algorithm, &key.Meta.Creator, &key.Meta.Comment} {
+		if len(data) < 2 {
+			return nil, nil, fmt.Errorf("%w: truncated", ErrInvalidRecord)
+		}
+
+		size := int(binary.BigEndian.Uint16(data))
+		if len(data) < 2+size {
+			return nil, nil, fmt.Errorf("%w: truncated", ErrInvalidRecord)
+		}
+
+		*field = string(data[2 : 2+size])
+		data = data[2+size:]
+	}
+
+	return key, data, nil
+}
+
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.Unix()
+}
+
+func fromUnixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(sec, 0).UTC()
+}
@@ -0,0 +1,125 @@
+package keyv1_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	keyv1 "github.com/julian7/redact/files/key_v1"
+	"github.com/julian7/redact/securemem"
+)
+
+const (
+	sampleCode = "0123456789abcdefghijklmnopqrstuv"
+)
+
+func TestNewKey(t *testing.T) {
+	key := keyv1.NewKey(5, keyv1.Metadata{Comment: "test"})
+
+	if key.Version() != 5 {
+		t.Errorf("invalid epoch\nExpected: %d\nReceived: %d", 5, key.Version())
+	}
+
+	if key.Type() != 1 {
+		t.Errorf("invalid key format type: %d", key.Type())
+	}
+
+	if meta := key.Metadata(); meta.Algorithm != keyv1.AlgorithmAEADHMAC || meta.Comment != "test" {
+		t.Errorf("invalid metadata: %+v", meta)
+	}
+}
+
+func TestString(t *testing.T) {
+	key := keyv1.FromSecret(1, []byte(sampleCode+sampleCode+sampleCode))
+	expected := "#1 0006b8c0"
+
+	if strval := key.String(); strval != expected {
+		t.Errorf(
+			"Invalid string representation of key\nExpected: %s\nReceived: %s",
+			expected,
+			strval,
+		)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	tt := []struct {
+		name    string
+		expires time.Time
+		expired bool
+	}{
+		{name: "no expiry", expired: false},
+		{name: "future", expires: now.Add(time.Hour), expired: false},
+		{name: "now", expires: now, expired: true},
+		{name: "past", expires: now.Add(-time.Hour), expired: true},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			meta := keyv1.Metadata{Expires: tc.expires}
+			if meta.Expired(now) != tc.expired {
+				t.Errorf("unexpected expiry: %v", !tc.expired)
+			}
+		})
+	}
+}
+
+func TestMarshalRoundtrip(t *testing.T) {
+	key := keyv1.NewKey(3, keyv1.Metadata{
+		Created: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Creator: "Jane Doe <jane@example.com>",
+		Comment: "rotated after incident",
+	})
+	if err := key.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := key.AppendBinary([]byte("prefix"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, rest, err := keyv1.Parse(append(data[len("prefix"):], "rest"...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(rest) != "rest" {
+		t.Errorf("unexpected rest: %q", rest)
+	}
+
+	if parsed.Epoch != key.Epoch || parsed.Meta != key.Meta || !bytes.Equal(parsed.Secret(), key.Secret()) {
+		t.Errorf("parsed key doesn't match.\nExpected: %+v\nReceived: %+v", key.Meta, parsed.Meta)
+	}
+
+	for size := range len(data) - len("prefix") {
+		if _, _, err := keyv1.Parse(data[len("prefix") : len("prefix")+size]); !errors.Is(err, keyv1.ErrInvalidRecord) {
+			t.Errorf("truncated record of %d bytes parsed: %v", size, err)
+
+			break
+		}
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	before := securemem.Outstanding()
+
+	key := keyv1.NewKey(1, keyv1.Metadata{})
+	if err := key.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if securemem.Outstanding() != before+1 {
+		t.Errorf("secret is not in guarded memory: %d buffers", securemem.Outstanding()-before)
+	}
+
+	key.Destroy()
+
+	if securemem.Outstanding() != before {
+		t.Errorf("secret is not released: %d buffers", securemem.Outstanding()-before)
+	}
+}
@@ -1,5 +1,7 @@
 package files
 
+import keyV1 "github.com/julian7/redact/files/key_v1"
+
 // KeyHandler interface
 type KeyHandler interface {
 	// Type returns key format type
@@ -13,6 +15,11 @@ type KeyHandler interface {
 	Generate() error
 	// Secret returns the Secret key
 	Secret() []byte
+	// Metadata returns descriptive information of the key
+	Metadata() keyV1.Metadata
+	// Destroy wipes the secret key from memory. The key can't be used
+	// afterwards.
+	Destroy()
 	// String provides a string representation of the key. It is safe to show
 	// it publicly.
 	String() string
@@ -2,17 +2,21 @@ package files
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/go-git/go-billy/v5"
 
 	keyV0 "github.com/julian7/redact/files/key_v0"
+	keyV1 "github.com/julian7/redact/files/key_v1"
+	"github.com/julian7/redact/securemem"
 )
 
 const (
@@ -27,15 +31,24 @@ const (
 	DefaultKeyExchangeDir = ".redact"
 	// KeyMagic magic string the key file starts with
 	KeyMagic = "\000REDACT\000"
+	// KeyTypeV0 is the original key file version, which contains raw keys
+	KeyTypeV0 = 0
+	// KeyTypeV1 is the key file version with key metadata, and a checksum
+	// over the whole file
+	KeyTypeV1 = 1
 	// KeyCurrentType current key file version
-	KeyCurrentType = 0
+	KeyCurrentType = KeyTypeV1
 	PEMType        = "REDACT SECRET KEY"
+	// PEMTypeEncrypted is the PEM type of exported secret keys, which are
+	// encrypted with a passphrase
+	PEMTypeEncrypted = "REDACT ENCRYPTED SECRET KEY"
 )
 
 var (
 	ErrInvalidKeyPreamble = errors.New("invalid key file preamble")
 	ErrInvalidKeyType     = errors.New("invalid key type")
 	ErrInvalidKey         = errors.New("invalid key")
+	ErrKeyChecksum        = errors.New("key file checksum mismatch")
 	ErrNoPEMData          = errors.New("no data found in PEM")
 	ErrPEMTypeMismatch    = errors.New("PEM type mismatch")
 	ErrNoKeysLoaded       = errors.New("no keys loaded")
@@ -44,16 +57,31 @@ var (
 
 // SecretKey contains secret key in a git repository
 type SecretKey struct {
-	dot       billy.Filesystem
+	store     KeyStore
 	Keys      map[uint32]KeyHandler
 	LatestKey uint32
+	// Passphrase provides the passphrase of protected key files
+	Passphrase PassphraseFunc
+	// ExportPassphrase provides the passphrase of encrypted exported keys
+	ExportPassphrase PassphraseFunc
+	passphrase       *securemem.Buffer
 }
 
 // NewSecretKey generates a new repo key in the OS' filesystem
 func NewSecretKey(dot billy.Filesystem) (*SecretKey, error) {
+	return NewSecretKeyIn(NewGitDirStore(dot)), nil
+}
+
+// NewSecretKeyIn generates a new repo key in a key store
+func NewSecretKeyIn(store KeyStore) *SecretKey {
 	return &SecretKey{
-		dot: dot,
-	}, nil
+		store: store,
+	}
+}
+
+// Store returns the key store of the secret key
+func (k *SecretKey) Store() KeyStore {
+	return k.store
 }
 
 // Returns keyfile name
@@ -63,11 +91,21 @@ func (k *SecretKey) Keyfile() string {
 
 // Generate generates a new secret key
 func (k *SecretKey) Generate() error {
+	return k.GenerateWith(keyV1.Metadata{})
+}
+
+// GenerateWith generates a new secret key with metadata. Creation time and
+// algorithm are filled in, if they are missing.
+func (k *SecretKey) GenerateWith(meta keyV1.Metadata) error {
 	epoch := k.LatestKey
 	k.ensureKeys()
 
+	if meta.Created.IsZero() {
+		meta.Created = time.Now().UTC().Truncate(time.Second)
+	}
+
 	epoch++
-	k.Keys[epoch] = keyV0.NewKey(epoch)
+	k.Keys[epoch] = keyV1.NewKey(epoch, meta)
 	k.LatestKey = epoch
 
 	return k.Keys[epoch].Generate()
@@ -93,16 +131,27 @@ func (k *SecretKey) Read(f io.Reader) error {
 		return fmt.Errorf("reading key type: %w", err)
 	}
 
-	if keyType != KeyCurrentType {
-		return ErrInvalidKeyType
+	k.ensureKeys()
+
+	switch keyType {
+	case KeyTypeV0:
+		return k.readV0(f)
+	case KeyTypeV1:
+		return k.readV1(f)
+	case KeyTypeProtected:
+		return k.readProtected(f)
 	}
 
-	k.ensureKeys()
+	return ErrInvalidKeyType
+}
 
+// readV0 reads raw keys of a v0 key file. They are converted to v1 keys,
+// which are saved in v1 format next time.
+func (k *SecretKey) readV0(f io.Reader) error {
 	for {
-		key := new(keyV0.KeyV0)
+		var epoch uint32
 
-		err = binary.Read(f, binary.BigEndian, key)
+		err := binary.Read(f, binary.BigEndian, &epoch)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				return nil
@@ -111,69 +160,166 @@ func (k *SecretKey) Read(f io.Reader) error {
 			return fmt.Errorf("reading key data: %w", err)
 		}
 
-		epoch := key.Version()
-		if _, ok := k.Keys[epoch]; ok {
-			return fmt.Errorf(
-				"%w: duplicate epoch number (%d)",
-				ErrInvalidKey,
-				epoch,
-			)
+		key := keyV0.NewKey(epoch)
+
+		if _, err := io.ReadFull(f, key.Secret()); err != nil {
+			key.Destroy()
+
+			return fmt.Errorf("reading key data: %w", err)
 		}
 
-		k.Keys[epoch] = key
-		if epoch > k.LatestKey {
-			k.LatestKey = epoch
+		err = k.addKey(keyV1.FromSecret(key.Version(), key.Secret()))
+		key.Destroy()
+
+		if err != nil {
+			return err
 		}
 	}
 }
 
-// Load loads existing key. Optionally it enforces strict file permissions.
-func (k *SecretKey) Load(strict bool) error {
-	err := k.checkKeyDir(strict)
-	if err != nil {
-		return err
+// readV1 reads keys with metadata of a v1 key file, verifying its checksum
+func (k *SecretKey) readV1(f io.Reader) error {
+	buf := securemem.New(0)
+	defer buf.Destroy()
+
+	if _, err := io.Copy(buf, f); err != nil {
+		return fmt.Errorf("reading key data: %w", err)
 	}
 
-	err = checkFileMode(k.dot, "key file", k.Keyfile(), 0600, strict)
-	if err != nil {
-		return err
+	data := buf.Bytes()
+	if len(data) < sha256.Size {
+		return fmt.Errorf("reading key data: %w", ErrKeyChecksum)
+	}
+
+	body, sum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+
+	if !bytes.Equal(keyChecksum(KeyTypeV1, body), sum) {
+		return fmt.Errorf("reading key data: %w", ErrKeyChecksum)
 	}
 
-	f, err := k.dot.OpenFile(k.Keyfile(), os.O_RDONLY, 0600)
+	for len(body) > 0 {
+		var (
+			key *keyV1.KeyV1
+			err error
+		)
+
+		key, body, err = keyV1.Parse(body)
+		if err != nil {
+			return fmt.Errorf("reading key data: %w", err)
+		}
+
+		if err := k.addKey(key); err != nil {
+			key.Destroy()
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keyChecksum returns the checksum of a key file, calculated over its header
+// and its contents
+func keyChecksum(keyType uint32, body []byte) []byte {
+	hash := keyHash(keyType)
+	hash.Write(body)
+
+	return hash.Sum(nil)
+}
+
+// keyHash returns a hash of a key file's header, to be continued with its
+// contents
+func keyHash(keyType uint32) hash.Hash {
+	hash := sha256.New()
+	hash.Write([]byte(KeyMagic))
+	_ = binary.Write(hash, binary.BigEndian, keyType)
+
+	return hash
+}
+
+func (k *SecretKey) addKey(key KeyHandler) error {
+	epoch := key.Version()
+	if _, ok := k.Keys[epoch]; ok {
+		return fmt.Errorf(
+			"%w: duplicate epoch number (%d)",
+			ErrInvalidKey,
+			epoch,
+		)
+	}
+
+	k.Keys[epoch] = key
+	if epoch > k.LatestKey {
+		k.LatestKey = epoch
+	}
+
+	return nil
+}
+
+// Load loads existing key. Optionally it enforces strict file permissions.
+func (k *SecretKey) Load(strict bool) error {
+	data, err := k.store.Load(strict)
 	if err != nil {
-		return fmt.Errorf("opening key file for reading: %w", err)
+		return err
 	}
 
-	defer f.Close()
+	defer securemem.Wipe(data)
 
-	return k.Read(f)
+	return k.Read(bytes.NewReader(data))
 }
 
+// Import merges keys of an exported (PEM encoded) secret key. Encrypted
+// exports are decrypted with the passphrase provided by ExportPassphrase.
 func (k *SecretKey) Import(reader io.Reader) error {
 	data, err := io.ReadAll(reader)
 	if err != nil {
 		return fmt.Errorf("importing: %w", err)
 	}
 
-	blk, _ := pem.Decode(data)
-	if blk == nil {
-		return ErrNoPEMData
+	defer securemem.Wipe(data)
+
+	data, err = decodeKeyExport(data)
+	if err != nil {
+		return err
 	}
 
-	if blk.Type != PEMType {
-		return ErrPEMTypeMismatch
+	defer securemem.Wipe(data)
+
+	imported := &SecretKey{Passphrase: k.ExportPassphrase}
+	if err := imported.Read(bytes.NewReader(data)); err != nil {
+		return err
 	}
 
-	return k.Read(bytes.NewReader(blk.Bytes))
+	_, err = k.Merge(imported)
+
+	return err
 }
 
+// Export writes the secret key in PEM format
 func (k *SecretKey) Export(writer io.Writer) error {
+	return k.export(writer, PEMType, k.SaveTo)
+}
+
+// ExportProtected writes the secret key in PEM format, encrypted with a key
+// derived from the passphrase
+func (k *SecretKey) ExportProtected(writer io.Writer, passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return ErrEmptyPassphrase
+	}
+
+	return k.export(writer, PEMTypeEncrypted, func(writer io.Writer) error {
+		return k.saveProtectedWith(writer, passphrase)
+	})
+}
+
+func (k *SecretKey) export(writer io.Writer, pemType string, save func(io.Writer) error) error {
 	blk := &pem.Block{
-		Type: PEMType,
+		Type: pemType,
 	}
 
-	buf := bytes.Buffer{}
-	if err := k.SaveTo(&buf); err != nil {
+	buf := securemem.New(0)
+	defer buf.Destroy()
+
+	if err := save(buf); err != nil {
 		return fmt.Errorf("export: %w", err)
 	}
 
@@ -188,7 +334,7 @@ func (k *SecretKey) Export(writer io.Writer) error {
 
 // Removes keyfile
 func (k *SecretKey) Remove() error {
-	return k.dot.Remove(k.Keyfile())
+	return k.store.Remove()
 }
 
 // SaveTo saves secret key into IO stream
@@ -204,51 +350,55 @@ func (k *SecretKey) SaveTo(writer io.Writer) error {
 		return fmt.Errorf("writing key type header: %w", err)
 	}
 
+	sum := keyHash(KeyCurrentType)
+
 	err := EachKey(k.Keys, func(idx uint32, key KeyHandler) error {
-		if err := binary.Write(writer, binary.BigEndian, key); err != nil {
+		record := keyV1.NewKey(key.Version(), key.Metadata())
+		defer record.Destroy()
+
+		copy(record.Secret(), key.Secret())
+
+		data, err := record.AppendBinary(nil)
+		if err != nil {
+			return fmt.Errorf("key #%d: %w", idx, err)
+		}
+
+		defer securemem.Wipe(data)
+
+		if _, err := writer.Write(data); err != nil {
 			return fmt.Errorf("key #%d: %w", idx, err)
 		}
 
+		sum.Write(data)
+
 		return nil
 	})
 	if err != nil {
 		return fmt.Errorf("writing key contents: %w", err)
 	}
 
+	if _, err := writer.Write(sum.Sum(nil)); err != nil {
+		return fmt.Errorf("writing key checksum: %w", err)
+	}
+
 	return nil
 }
 
-// Save saves key
+// Save saves key into its key store
 func (k *SecretKey) Save() error {
-	err := k.getOrCreateKeyDir()
-	if err != nil {
-		return err
+	save := k.SaveTo
+	if k.Protected() {
+		save = k.saveProtectedTo
 	}
 
-	f, err := k.dot.TempFile(DefaultKeyDir, "temp")
-	if err != nil {
-		return fmt.Errorf("saving key file: %w", err)
-	}
+	buf := securemem.New(0)
+	defer buf.Destroy()
 
-	if err = k.SaveTo(f); err != nil {
+	if err := save(buf); err != nil {
 		return err
 	}
 
-	if err = f.Close(); err != nil {
-		return fmt.Errorf("closing temp file for key: %w", err)
-	}
-
-	if err = k.dot.Rename(f.Name(), k.Keyfile()); err != nil {
-		return fmt.Errorf("placing secret key: %w", err)
-	}
-
-	if fs, ok := k.dot.(billy.Change); ok {
-		if err := fs.Chmod(k.Keyfile(), 0600); err != nil {
-			return fmt.Errorf("setting permissions on secret key: %w", err)
-		}
-	}
-
-	return nil
+	return k.store.Save(buf.Bytes())
 }
 
 // Key returns the a key handler with a certain epoch. If epoch is 0,
@@ -270,51 +420,19 @@ func (k *SecretKey) Key(epoch uint32) (KeyHandler, error) {
 	return key, nil
 }
 
-func (k *SecretKey) ensureKeys() {
-	if k.Keys == nil {
-		k.Keys = make(map[uint32]KeyHandler)
-	}
-}
-
-func (k *SecretKey) getOrCreateKeyDir() error {
-	fs, err := k.dot.Stat(DefaultKeyDir)
-	if err != nil {
-		if err := k.dot.MkdirAll(DefaultKeyDir, 0700); err != nil {
-			return fmt.Errorf("creating keydir: %w", err)
-		}
-
-		fs, err = k.dot.Stat(DefaultKeyDir)
-	}
-
-	if err != nil {
-		return fmt.Errorf("keydir not available: %w", err)
-	}
-
-	if !fs.IsDir() {
-		return ErrKeydirNotDirectory
+// Destroy wipes all secret keys, and the passphrase from memory
+func (k *SecretKey) Destroy() {
+	for _, key := range k.Keys {
+		key.Destroy()
 	}
 
-	return nil
+	k.Unprotect()
 }
 
-func (k *SecretKey) checkKeyDir(strict bool) error {
-	keyDir := DefaultKeyDir
-
-	fs, err := k.dot.Stat(keyDir)
-	if err != nil {
-		return fmt.Errorf("keydir %q not available: %w", keyDir, err)
-	}
-
-	if !fs.IsDir() {
-		return ErrKeydirNotDirectory
-	}
-
-	err = checkFileMode(k.dot, "key dir", DefaultKeyDir, 0700, strict)
-	if err != nil {
-		return err
+func (k *SecretKey) ensureKeys() {
+	if k.Keys == nil {
+		k.Keys = make(map[uint32]KeyHandler)
 	}
-
-	return nil
 }
 
 func (k *SecretKey) String() string {
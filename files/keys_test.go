@@ -8,11 +8,16 @@ import (
 	"io"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
 
+	"github.com/julian7/redact/encoder"
 	"github.com/julian7/redact/files"
+	keyV1 "github.com/julian7/redact/files/key_v1"
 	"github.com/julian7/redact/repo"
+	"github.com/julian7/redact/securemem"
 	"github.com/julian7/tester"
 	"github.com/julian7/tester/ioprobe"
 )
@@ -293,21 +298,21 @@ func TestSaveTo(t *testing.T) { //nolint:funlen
 			name:   "success",
 			writer: bytes.NewBuffer(nil),
 			keys:   1,
-			len:    112,
+			len:    186,
 			err:    nil,
 		},
 		{
 			name:   "no keys",
 			writer: bytes.NewBuffer(nil),
 			keys:   0,
-			len:    12,
+			len:    44,
 			err:    nil,
 		},
 		{
 			name:   "5 keys",
 			writer: bytes.NewBuffer(nil),
 			keys:   5,
-			len:    512,
+			len:    754,
 			err:    nil,
 		},
 		{
@@ -337,6 +342,13 @@ func TestSaveTo(t *testing.T) { //nolint:funlen
 			len:    -1,
 			err:    errors.New("writing key contents: key #3: unexpected EOF"),
 		},
+		{
+			name:   "write error 6",
+			writer: ioprobe.NewTimeoutWriter(bytes.NewBuffer(nil), 6),
+			keys:   3,
+			len:    -1,
+			err:    errors.New("writing key checksum: unexpected EOF"),
+		},
 	}
 	for _, tc := range tt {
 		tc := tc
@@ -470,3 +482,231 @@ func TestSave(t *testing.T) { //nolint:funlen,gocognit
 		})
 	}
 }
+
+func genKeyV1(t *testing.T) []byte {
+	t.Helper()
+
+	k := &files.SecretKey{}
+
+	err := k.GenerateWith(keyV1.Metadata{
+		Creator: "Jane Doe <jane@example.com>",
+		Expires: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		Comment: "first key",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := k.SaveTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestReadV1(t *testing.T) {
+	data := genKeyV1(t)
+
+	flipped := bytes.Clone(data)
+	flipped[20] ^= 1
+
+	tt := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{name: "success", data: data},
+		{name: "bit flip", data: flipped, err: errors.New("reading key data: key file checksum mismatch")},
+		{name: "truncated", data: data[:len(data)-1], err: errors.New("reading key data: key file checksum mismatch")},
+		{name: "truncated header", data: data[:20], err: errors.New("reading key data: key file checksum mismatch")},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			k := &files.SecretKey{}
+			if err := tester.AssertError(tc.err, k.Read(bytes.NewReader(tc.data))); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestKeyMetadata(t *testing.T) {
+	k := &files.SecretKey{}
+	if err := k.Read(bytes.NewReader(genKeyV1(t))); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := k.Key(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := key.Metadata()
+
+	if meta.Creator != "Jane Doe <jane@example.com>" || meta.Comment != "first key" {
+		t.Errorf("unexpected metadata: %+v", meta)
+	}
+
+	if meta.Algorithm != keyV1.AlgorithmAEADHMAC {
+		t.Errorf("unexpected algorithm: %q", meta.Algorithm)
+	}
+
+	if meta.Created.IsZero() || !meta.Expires.Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected times: %+v", meta)
+	}
+
+	latest, err := k.Key(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !latest.Metadata().Expires.IsZero() {
+		t.Errorf("unexpected expiry of latest key: %v", latest.Metadata().Expires)
+	}
+}
+
+func TestKeyMigration(t *testing.T) {
+	r, err := genGitRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeKey(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Load(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	dot, err := r.Workdir.Chroot(".git")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := util.ReadFile(dot, r.Keyfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keyType := binary.BigEndian.Uint32(data[len(files.KeyMagic):]); keyType != files.KeyTypeV1 {
+		t.Errorf("key file is not migrated: type %d", keyType)
+	}
+
+	migrated, err := files.NewSecretKey(dot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrated.Load(false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, epoch := range []uint32{1, 2} {
+		key, err := migrated.Key(epoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key.Type() != files.KeyTypeV1 {
+			t.Errorf("key #%d is not migrated: type %d", epoch, key.Type())
+		}
+
+		if !bytes.Equal(key.Secret(), []byte(sampleCode+sampleCode+sampleCode)) {
+			t.Errorf("secret of key #%d changed", epoch)
+		}
+	}
+}
+
+func TestSecureMemory(t *testing.T) {
+	r, err := genGitRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := securemem.Outstanding()
+
+	for range 2 {
+		if err := r.Generate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.Protect([]byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+
+	// two keys, and the passphrase
+	kept := before + 3
+	if received := securemem.Outstanding(); received != kept {
+		t.Errorf("guarded buffers after generating keys\nExpected: %d\nReceived: %d", kept, received)
+	}
+
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Export(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded := &bytes.Buffer{}
+	if err := r.Encode(encoder.TypeAES256GCM96, 0, bytes.NewReader([]byte(samplePlaintext)), encoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Decode(encoded, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	if received := securemem.Outstanding(); received != kept {
+		t.Errorf("temporary buffers are not destroyed\nExpected: %d\nReceived: %d", kept, received)
+	}
+
+	r.Destroy()
+
+	if received := securemem.Outstanding(); received != before {
+		t.Errorf("keys are not destroyed\nExpected: %d\nReceived: %d", before, received)
+	}
+
+	if r.Protected() {
+		t.Error("passphrase is not destroyed")
+	}
+
+	dot, err := r.Workdir.Chroot(".git")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := files.NewSecretKey(dot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded.Passphrase = func() ([]byte, error) { return []byte("passphrase"), nil }
+
+	if err := loaded.Load(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if received := securemem.Outstanding(); received != kept {
+		t.Errorf("guarded buffers after loading keys\nExpected: %d\nReceived: %d", kept, received)
+	}
+
+	loaded.Destroy()
+
+	if received := securemem.Outstanding(); received != before {
+		t.Errorf("loaded keys are not destroyed\nExpected: %d\nReceived: %d", before, received)
+	}
+}
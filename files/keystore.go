@@ -0,0 +1,632 @@
+package files
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+
+	"github.com/julian7/redact/securemem"
+)
+
+const (
+	// DefaultBackupDir is where snapshots of previous key files are stored,
+	// next to the key file
+	DefaultBackupDir = "backups"
+	// maxKeyExportSize limits exported secret keys read from outside sources
+	maxKeyExportSize = 1 << 20
+	// backupTimeFormat is the timestamp of key file snapshots, which sorts
+	// lexically
+	backupTimeFormat = "20060102T150405.000000000Z"
+)
+
+var (
+	ErrReadOnlyKeyStore = errors.New("key store is read-only")
+	ErrInvalidKeyStore  = errors.New("invalid key store")
+	ErrInvalidBackup    = errors.New("invalid key backup")
+)
+
+// KeyStore stores secret key files
+type KeyStore interface {
+	// Load returns the secret key file's contents. Optionally it enforces
+	// strict file permissions. It returns an error wrapping os.ErrNotExist
+	// if there is no secret key file.
+	Load(strict bool) ([]byte, error)
+	// Save stores the secret key file's contents
+	Save(data []byte) error
+	// Remove removes the secret key file, and its snapshots, if any
+	Remove() error
+	// Describe returns the location of the secret key file
+	Describe() string
+}
+
+// BackupStore is a key store, which snapshots the previous secret key file
+// before overwriting it
+type BackupStore interface {
+	KeyStore
+	// Backups returns snapshot names, oldest first
+	Backups() ([]string, error)
+	// LoadBackup returns the contents of a snapshot
+	LoadBackup(name string) ([]byte, error)
+	// SaveBackup replaces the contents of a snapshot
+	SaveBackup(name string, data []byte) error
+	// RemoveBackup wipes a snapshot
+	RemoveBackup(name string) error
+}
+
+// GitDirStore stores the secret key file in the git common dir. This is the
+// default key store.
+type GitDirStore struct {
+	dot billy.Filesystem
+}
+
+// NewGitDirStore returns a key store in a git common dir
+func NewGitDirStore(dot billy.Filesystem) *GitDirStore {
+	return &GitDirStore{dot: dot}
+}
+
+// Load loads the secret key file
+func (s *GitDirStore) Load(strict bool) ([]byte, error) {
+	if err := s.checkKeyDir(strict); err != nil {
+		return nil, err
+	}
+
+	return loadKeyFile(s.dot, filepath.Join(DefaultKeyDir, DefaultKeyFile), strict)
+}
+
+// Save saves the secret key file
+func (s *GitDirStore) Save(data []byte) error {
+	if err := s.getOrCreateKeyDir(); err != nil {
+		return err
+	}
+
+	return saveKeyFile(s.dot, DefaultKeyDir, DefaultKeyFile, data)
+}
+
+// Remove wipes the secret key file, and its snapshots
+func (s *GitDirStore) Remove() error {
+	return removeKeyFiles(s.dot, DefaultKeyDir, DefaultKeyFile)
+}
+
+// Describe returns the location of the secret key file
+func (s *GitDirStore) Describe() string {
+	return "git:" + filepath.Join(s.dot.Root(), DefaultKeyDir, DefaultKeyFile)
+}
+
+// Backups returns snapshots of previous key files
+func (s *GitDirStore) Backups() ([]string, error) {
+	return listBackups(s.dot, DefaultKeyDir, DefaultKeyFile)
+}
+
+// LoadBackup returns the contents of a snapshot
+func (s *GitDirStore) LoadBackup(name string) ([]byte, error) {
+	return loadBackup(s.dot, DefaultKeyDir, DefaultKeyFile, name)
+}
+
+// SaveBackup replaces the contents of a snapshot
+func (s *GitDirStore) SaveBackup(name string, data []byte) error {
+	return saveBackup(s.dot, DefaultKeyDir, DefaultKeyFile, name, data)
+}
+
+// RemoveBackup wipes a snapshot
+func (s *GitDirStore) RemoveBackup(name string) error {
+	return removeBackup(s.dot, DefaultKeyDir, DefaultKeyFile, name)
+}
+
+func (s *GitDirStore) getOrCreateKeyDir() error {
+	fs, err := s.dot.Stat(DefaultKeyDir)
+	if err != nil {
+		if err := s.dot.MkdirAll(DefaultKeyDir, 0700); err != nil {
+			return fmt.Errorf("creating keydir: %w", err)
+		}
+
+		fs, err = s.dot.Stat(DefaultKeyDir)
+	}
+
+	if err != nil {
+		return fmt.Errorf("keydir not available: %w", err)
+	}
+
+	if !fs.IsDir() {
+		return ErrKeydirNotDirectory
+	}
+
+	return nil
+}
+
+func (s *GitDirStore) checkKeyDir(strict bool) error {
+	keyDir := DefaultKeyDir
+
+	fs, err := s.dot.Stat(keyDir)
+	if err != nil {
+		return fmt.Errorf("keydir %q not available: %w", keyDir, err)
+	}
+
+	if !fs.IsDir() {
+		return ErrKeydirNotDirectory
+	}
+
+	err = checkFileMode(s.dot, "key dir", DefaultKeyDir, 0700, strict)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// FileStore stores the secret key file at an arbitrary path
+type FileStore struct {
+	fs   billy.Filesystem
+	dir  string
+	name string
+}
+
+// NewFileStore returns a key store of a file path
+func NewFileStore(path string) (*FileStore, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKeyStore, err)
+	}
+
+	dir := filepath.Dir(path)
+
+	return &FileStore{fs: osfs.New(dir), dir: dir, name: filepath.Base(path)}, nil
+}
+
+// NewXDGStore returns a key store in the user's configuration directory
+// ($XDG_CONFIG_HOME/redact/keys on Linux), in a directory named after the
+// repository's identity
+func NewXDGStore(repoID string) (*FileStore, error) {
+	if repoID == "" || repoID != filepath.Base(repoID) || repoID == "." || repoID == ".." {
+		return nil, fmt.Errorf("%w: invalid repo identity %q", ErrInvalidKeyStore, repoID)
+	}
+
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKeyStore, err)
+	}
+
+	return NewFileStore(filepath.Join(config, "redact", "keys", repoID, DefaultKeyFile))
+}
+
+// Load loads the secret key file
+func (s *FileStore) Load(strict bool) ([]byte, error) {
+	return loadKeyFile(s.fs, s.name, strict)
+}
+
+// Save saves the secret key file, creating its directory if needed
+func (s *FileStore) Save(data []byte) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("creating keydir: %w", err)
+	}
+
+	return saveKeyFile(s.fs, "", s.name, data)
+}
+
+// Remove wipes the secret key file, and its snapshots
+func (s *FileStore) Remove() error {
+	return removeKeyFiles(s.fs, "", s.name)
+}
+
+// Describe returns the location of the secret key file
+func (s *FileStore) Describe() string {
+	return "file:" + filepath.Join(s.dir, s.name)
+}
+
+// Backups returns snapshots of previous key files
+func (s *FileStore) Backups() ([]string, error) {
+	return listBackups(s.fs, "", s.name)
+}
+
+// LoadBackup returns the contents of a snapshot
+func (s *FileStore) LoadBackup(name string) ([]byte, error) {
+	return loadBackup(s.fs, "", s.name, name)
+}
+
+// SaveBackup replaces the contents of a snapshot
+func (s *FileStore) SaveBackup(name string, data []byte) error {
+	return saveBackup(s.fs, "", s.name, name, data)
+}
+
+// RemoveBackup wipes a snapshot
+func (s *FileStore) RemoveBackup(name string) error {
+	return removeBackup(s.fs, "", s.name, name)
+}
+
+// EnvStore reads an exported (PEM encoded) secret key from an environment
+// variable. It is read-only.
+type EnvStore struct {
+	name string
+}
+
+// NewEnvStore returns a key store of an environment variable
+func NewEnvStore(name string) *EnvStore {
+	return &EnvStore{name: name}
+}
+
+// Load decodes the exported secret key
+func (s *EnvStore) Load(_ bool) ([]byte, error) {
+	value, ok := os.LookupEnv(s.name)
+	if !ok || value == "" {
+		return nil, fmt.Errorf("environment variable %s: %w", s.name, os.ErrNotExist)
+	}
+
+	data, err := decodeKeyExport([]byte(value))
+	if err != nil {
+		return nil, fmt.Errorf("environment variable %s: %w", s.name, err)
+	}
+
+	return data, nil
+}
+
+// Save is not supported
+func (s *EnvStore) Save(_ []byte) error {
+	return fmt.Errorf("%w: %s", ErrReadOnlyKeyStore, s.Describe())
+}
+
+// Remove is not supported
+func (s *EnvStore) Remove() error {
+	return fmt.Errorf("%w: %s", ErrReadOnlyKeyStore, s.Describe())
+}
+
+// Describe returns the environment variable's name
+func (s *EnvStore) Describe() string {
+	return "env:" + s.name
+}
+
+// FDStore reads an exported (PEM encoded) secret key from an inherited file
+// descriptor. It is read-only. Regular files can be read by multiple
+// processes, as they are read from their beginning without changing their
+// offset; pipes can be read only once.
+type FDStore struct {
+	fd   uintptr
+	file *os.File
+}
+
+// NewFDStore returns a key store of a file descriptor
+func NewFDStore(fd uintptr) *FDStore {
+	return &FDStore{fd: fd, file: os.NewFile(fd, fmt.Sprintf("fd:%d", fd))}
+}
+
+// Load decodes the exported secret key
+func (s *FDStore) Load(_ bool) ([]byte, error) {
+	if s.file == nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidKeyStore, s.Describe())
+	}
+
+	data, err := io.ReadAll(io.NewSectionReader(s.file, 0, maxKeyExportSize))
+	if err != nil {
+		data, err = io.ReadAll(io.LimitReader(s.file, maxKeyExportSize))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", s.Describe(), err)
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%s: %w", s.Describe(), os.ErrNotExist)
+	}
+
+	data, err = decodeKeyExport(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Describe(), err)
+	}
+
+	return data, nil
+}
+
+// Save is not supported
+func (s *FDStore) Save(_ []byte) error {
+	return fmt.Errorf("%w: %s", ErrReadOnlyKeyStore, s.Describe())
+}
+
+// Remove is not supported
+func (s *FDStore) Remove() error {
+	return fmt.Errorf("%w: %s", ErrReadOnlyKeyStore, s.Describe())
+}
+
+// Describe returns the file descriptor
+func (s *FDStore) Describe() string {
+	return fmt.Sprintf("fd:%d", s.fd)
+}
+
+// decodeKeyExport returns the secret key file of an exported secret key.
+// Encrypted exports contain a protected key file.
+func decodeKeyExport(data []byte) ([]byte, error) {
+	blk, _ := pem.Decode(data)
+	if blk == nil {
+		return nil, ErrNoPEMData
+	}
+
+	var encrypted bool
+
+	switch blk.Type {
+	case PEMType:
+	case PEMTypeEncrypted:
+		encrypted = true
+	default:
+		return nil, ErrPEMTypeMismatch
+	}
+
+	if isProtectedKeyFile(blk.Bytes) != encrypted {
+		securemem.Wipe(blk.Bytes)
+
+		return nil, fmt.Errorf("%w: %s contains a key file of another kind", ErrPEMTypeMismatch, blk.Type)
+	}
+
+	return blk.Bytes, nil
+}
+
+// isProtectedKeyFile tells whether key file contents are passphrase protected
+func isProtectedKeyFile(data []byte) bool {
+	return len(data) >= len(KeyMagic)+4 &&
+		binary.BigEndian.Uint32(data[len(KeyMagic):]) == KeyTypeProtected
+}
+
+func loadKeyFile(fs billy.Filesystem, name string, strict bool) ([]byte, error) {
+	if err := checkFileMode(fs, "key file", name, 0600, strict); err != nil {
+		return nil, err
+	}
+
+	f, err := fs.OpenFile(name, os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening key file for reading: %w", err)
+	}
+
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+
+	return data, nil
+}
+
+// saveKeyFile replaces a key file atomically, after taking a snapshot of
+// the previous one
+func saveKeyFile(fs billy.Filesystem, dir, name string, data []byte) error {
+	if err := backupKeyFile(fs, dir, name, data); err != nil {
+		return err
+	}
+
+	return writeKeyFile(fs, dir, name, data)
+}
+
+// backupKeyFile copies an existing key file into the backup directory,
+// unless it is identical to the new contents. When the new contents are
+// passphrase protected, unprotected key files are not kept as snapshots:
+// the previous file is not copied, and existing snapshots are wiped.
+func backupKeyFile(fs billy.Filesystem, dir, name string, data []byte) error {
+	previous, err := loadKeyFile(fs, filepath.Join(dir, name), false)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("backing up key file: %w", err)
+	}
+
+	defer securemem.Wipe(previous)
+
+	if isProtectedKeyFile(data) {
+		if err := purgePlaintextBackups(fs, dir, name); err != nil {
+			return err
+		}
+
+		if !isProtectedKeyFile(previous) {
+			return nil
+		}
+	}
+
+	if previous == nil || bytes.Equal(previous, data) {
+		return nil
+	}
+
+	backupDir := filepath.Join(dir, DefaultBackupDir)
+	if err := fs.MkdirAll(backupDir, 0700); err != nil {
+		return fmt.Errorf("creating backup dir: %w", err)
+	}
+
+	backup := name + "." + time.Now().UTC().Format(backupTimeFormat)
+	if err := writeKeyFile(fs, backupDir, backup, previous); err != nil {
+		return fmt.Errorf("backing up key file: %w", err)
+	}
+
+	return nil
+}
+
+// removeKeyFiles wipes a key file, and all of its snapshots. Snapshots are
+// wiped even if the key file doesn't exist.
+func removeKeyFiles(fs billy.Filesystem, dir, name string) error {
+	keyErr := removeKeyFile(fs, filepath.Join(dir, name))
+	if keyErr != nil && !errors.Is(keyErr, os.ErrNotExist) {
+		return keyErr
+	}
+
+	backups, err := listBackups(fs, dir, name)
+	if err != nil {
+		return err
+	}
+
+	for _, backup := range backups {
+		if err := removeBackup(fs, dir, name, backup); err != nil {
+			return err
+		}
+	}
+
+	return keyErr
+}
+
+// purgePlaintextBackups wipes snapshots of a key file, which are not
+// passphrase protected
+func purgePlaintextBackups(fs billy.Filesystem, dir, name string) error {
+	backups, err := listBackups(fs, dir, name)
+	if err != nil {
+		return err
+	}
+
+	for _, backup := range backups {
+		data, err := loadBackup(fs, dir, name, backup)
+		if err != nil {
+			return err
+		}
+
+		protected := isProtectedKeyFile(data)
+		securemem.Wipe(data)
+
+		if protected {
+			continue
+		}
+
+		if err := removeKeyFile(fs, filepath.Join(dir, DefaultBackupDir, backup)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listBackups returns snapshots of a key file, oldest first
+func listBackups(fs billy.Filesystem, dir, name string) ([]string, error) {
+	entries, err := fs.ReadDir(filepath.Join(dir, DefaultBackupDir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("listing key backups: %w", err)
+	}
+
+	backups := []string{}
+
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() && strings.HasPrefix(entry.Name(), name+".") {
+			backups = append(backups, entry.Name())
+		}
+	}
+
+	slices.Sort(backups)
+
+	return backups, nil
+}
+
+// loadBackup returns the contents of a key file's snapshot
+func loadBackup(fs billy.Filesystem, dir, name, backup string) ([]byte, error) {
+	if err := checkBackupName(name, backup); err != nil {
+		return nil, err
+	}
+
+	data, err := loadKeyFile(fs, filepath.Join(dir, DefaultBackupDir, backup), false)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+
+	return data, nil
+}
+
+// saveBackup replaces a key file's snapshot. The previous contents are wiped
+// first.
+func saveBackup(fs billy.Filesystem, dir, name, backup string, data []byte) error {
+	if err := removeBackup(fs, dir, name, backup); err != nil {
+		return err
+	}
+
+	if err := writeKeyFile(fs, filepath.Join(dir, DefaultBackupDir), backup, data); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+
+	return nil
+}
+
+// removeBackup wipes a key file's snapshot
+func removeBackup(fs billy.Filesystem, dir, name, backup string) error {
+	if err := checkBackupName(name, backup); err != nil {
+		return err
+	}
+
+	if err := removeKeyFile(fs, filepath.Join(dir, DefaultBackupDir, backup)); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+
+	return nil
+}
+
+// checkBackupName ensures a snapshot name refers to a key file's snapshot in
+// the backup directory
+func checkBackupName(name, backup string) error {
+	if backup != filepath.Base(backup) || !strings.HasPrefix(backup, name+".") {
+		return fmt.Errorf("%w: %q", ErrInvalidBackup, backup)
+	}
+
+	return nil
+}
+
+// writeKeyFile replaces a key file atomically, through a temp file in the
+// same directory
+func writeKeyFile(fs billy.Filesystem, dir, name string, data []byte) error {
+	f, err := fs.TempFile(dir, "temp")
+	if err != nil {
+		return fmt.Errorf("saving key file: %w", err)
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+
+		return fmt.Errorf("saving key file: %w", err)
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("closing temp file for key: %w", err)
+	}
+
+	keyfile := filepath.Join(dir, name)
+
+	if err = fs.Rename(f.Name(), keyfile); err != nil {
+		return fmt.Errorf("placing secret key: %w", err)
+	}
+
+	if chfs, ok := fs.(billy.Change); ok {
+		if err := chfs.Chmod(keyfile, 0600); err != nil {
+			return fmt.Errorf("setting permissions on secret key: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// removeKeyFile overwrites a key file with zeros before removing it. This
+// doesn't guarantee the old contents are gone on every file system (eg.
+// copy-on-write ones), but it doesn't leave them in free blocks either.
+func removeKeyFile(fs billy.Filesystem, name string) error {
+	info, err := fs.Lstat(name)
+	if err != nil {
+		return fmt.Errorf("wiping key file: %w", err)
+	}
+
+	f, err := fs.OpenFile(name, os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("wiping key file: %w", err)
+	}
+
+	_, err = f.Write(make([]byte, info.Size()))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("wiping key file: %w", err)
+	}
+
+	if err := fs.Remove(name); err != nil {
+		return fmt.Errorf("removing key file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,379 @@
+package files_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julian7/redact/files"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "repo", "key")
+
+	store, err := files.NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Load(true); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("unexpected error loading missing key: %v", err)
+	}
+
+	k := files.NewSecretKeyIn(store)
+	if err := k.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if perm := st.Mode().Perm(); perm != 0600 && os.PathSeparator == '/' {
+		t.Errorf("unexpected key file permissions: %s", perm)
+	}
+
+	loaded := files.NewSecretKeyIn(store)
+	if err := loaded.Load(true); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(loaded.Keys[1].Secret(), k.Keys[1].Secret()) {
+		t.Error("loaded key doesn't match")
+	}
+
+	if received := store.Describe(); received != "file:"+path {
+		t.Errorf("unexpected description: %s", received)
+	}
+
+	if err := loaded.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("key file is not removed: %v", err)
+	}
+}
+
+func TestXDGStore(t *testing.T) {
+	config := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", config)
+	t.Setenv("HOME", config)
+	t.Setenv("AppData", config)
+
+	store, err := files.NewXDGStore("repo-0123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if received := store.Describe(); !strings.HasSuffix(received, filepath.Join("redact", "keys", "repo-0123", "key")) {
+		t.Errorf("unexpected description: %s", received)
+	}
+
+	for _, id := range []string{"", "..", "a/b"} {
+		if _, err := files.NewXDGStore(id); !errors.Is(err, files.ErrInvalidKeyStore) {
+			t.Errorf("unexpected error of repo identity %q: %v", id, err)
+		}
+	}
+}
+
+func TestEnvStore(t *testing.T) {
+	k := &files.SecretKey{}
+	if err := k.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	exported := bytes.Buffer{}
+	if err := k.Export(&exported); err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted := bytes.Buffer{}
+	if err := k.ExportProtected(&encrypted, []byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name  string
+		value string
+		err   error
+	}{
+		{name: "exported key", value: exported.String()},
+		{name: "encrypted exported key without passphrase", value: encrypted.String(), err: files.ErrPassphraseRequired},
+		{name: "empty", value: "", err: os.ErrNotExist},
+		{name: "not PEM", value: "key", err: files.ErrNoPEMData},
+		{
+			name:  "wrong PEM type",
+			value: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")})),
+			err:   files.ErrPEMTypeMismatch,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("REDACT_TEST_KEY", tc.value)
+
+			store := files.NewEnvStore("REDACT_TEST_KEY")
+			loaded := files.NewSecretKeyIn(store)
+
+			err := loaded.Load(true)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("unexpected error. Expected: %v, received: %v", tc.err, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if !bytes.Equal(loaded.Keys[1].Secret(), k.Keys[1].Secret()) {
+				t.Error("loaded key doesn't match")
+			}
+
+			if err := loaded.Save(); !errors.Is(err, files.ErrReadOnlyKeyStore) {
+				t.Errorf("unexpected error saving key: %v", err)
+			}
+
+			if err := loaded.Remove(); !errors.Is(err, files.ErrReadOnlyKeyStore) {
+				t.Errorf("unexpected error removing key: %v", err)
+			}
+		})
+	}
+}
+
+func TestFDStore(t *testing.T) {
+	k := &files.SecretKey{}
+	if err := k.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	exported := bytes.Buffer{}
+	if err := k.Export(&exported); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, exported.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	store := files.NewFDStore(file.Fd())
+
+	for range 2 {
+		loaded := files.NewSecretKeyIn(store)
+		if err := loaded.Load(true); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(loaded.Keys[1].Secret(), k.Keys[1].Secret()) {
+			t.Error("loaded key doesn't match")
+		}
+	}
+
+	if err := store.Save(nil); !errors.Is(err, files.ErrReadOnlyKeyStore) {
+		t.Errorf("unexpected error saving key: %v", err)
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer reader.Close()
+
+	if _, err := writer.Write(exported.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	writer.Close()
+
+	if err := files.NewSecretKeyIn(files.NewFDStore(reader.Fd())).Load(true); err != nil {
+		t.Errorf("unexpected error reading pipe: %v", err)
+	}
+}
+
+func TestBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+
+	store, err := files.NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := files.NewSecretKeyIn(store)
+	saved := [][]byte{}
+
+	for range 3 {
+		if err := k.Generate(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := k.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := store.Load(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		saved = append(saved, data)
+	}
+
+	if err := k.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := store.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(backups) != 2 {
+		t.Fatalf("unexpected backups: %v", backups)
+	}
+
+	for idx, backup := range backups {
+		data, err := store.LoadBackup(backup)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, saved[idx]) {
+			t.Errorf("backup %s doesn't match key file #%d", backup, idx)
+		}
+	}
+
+	for _, name := range []string{"../key", "other.1", backups[0] + "x"} {
+		if _, err := store.LoadBackup(name); !errors.Is(err, files.ErrInvalidBackup) {
+			t.Errorf("unexpected error loading backup %q: %v", name, err)
+		}
+	}
+}
+
+func TestRemoveWipesBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "key")
+
+	store, err := files.NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := files.NewSecretKeyIn(store)
+
+	for range 3 {
+		if err := k.Generate(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := k.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if backups, err := store.Backups(); err != nil || len(backups) != 2 {
+		t.Fatalf("unexpected backups before removal: %v (%v)", backups, err)
+	}
+
+	if err := k.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	if backups, err := store.Backups(); err != nil || len(backups) != 0 {
+		t.Errorf("backups kept after removal: %v (%v)", backups, err)
+	}
+
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("key file is not removed: %v", err)
+	}
+
+	if err := k.Remove(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("unexpected error removing missing key: %v", err)
+	}
+}
+
+func TestProtectedBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+
+	store, err := files.NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := files.NewSecretKeyIn(store)
+
+	for range 2 {
+		if err := k.Generate(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := k.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if backups, err := store.Backups(); err != nil || len(backups) != 1 {
+		t.Fatalf("unexpected backups before protecting: %v (%v)", backups, err)
+	}
+
+	if err := k.Protect([]byte("correct horse battery staple")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := store.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(backups) != 0 {
+		t.Errorf("plaintext backups kept after protecting: %v", backups)
+	}
+
+	if err := k.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err = store.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(backups) != 1 {
+		t.Fatalf("unexpected backups of protected key: %v", backups)
+	}
+
+	data, err := store.LoadBackup(backups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if binary.BigEndian.Uint32(data[len(files.KeyMagic):]) != files.KeyTypeProtected {
+		t.Errorf("backup of protected key is not protected")
+	}
+}
@@ -0,0 +1,54 @@
+package files
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var ErrKeyConflict = errors.New("conflicting secret keys")
+
+// Merge merges keys of another secret key. It returns epochs added. It
+// refuses to merge anything, if the same epoch holds different secret keys.
+// Keys of other, which are not merged, are wiped.
+func (k *SecretKey) Merge(other *SecretKey) ([]uint32, error) {
+	added := []uint32{}
+
+	for epoch, key := range other.Keys {
+		existing, ok := k.Keys[epoch]
+		if !ok {
+			added = append(added, epoch)
+
+			continue
+		}
+
+		if !bytes.Equal(existing.Secret(), key.Secret()) {
+			return nil, fmt.Errorf("%w: epoch %d holds different secret keys", ErrKeyConflict, epoch)
+		}
+	}
+
+	k.ensureKeys()
+
+	for epoch, key := range other.Keys {
+		existing, ok := k.Keys[epoch]
+		if ok && existing != key {
+			if !existing.Metadata().Created.IsZero() {
+				key.Destroy()
+
+				continue
+			}
+
+			existing.Destroy()
+		}
+
+		k.Keys[epoch] = key
+		if epoch > k.LatestKey {
+			k.LatestKey = epoch
+		}
+	}
+
+	slices.Sort(added)
+
+	return added, nil
+}
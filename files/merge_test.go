@@ -0,0 +1,59 @@
+package files_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/julian7/redact/files"
+	keyV1 "github.com/julian7/redact/files/key_v1"
+)
+
+func TestMerge(t *testing.T) {
+	local := &files.SecretKey{}
+	for range 2 {
+		if err := local.Generate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exchange := &files.SecretKey{Keys: map[uint32]files.KeyHandler{
+		2: local.Keys[2],
+		3: keyV1.NewKey(3, keyV1.Metadata{}),
+	}, LatestKey: 3}
+	if err := exchange.Keys[3].Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	added, err := local.Merge(exchange)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(added) != 1 || added[0] != 3 {
+		t.Errorf("unexpected epochs added: %v", added)
+	}
+
+	if local.LatestKey != 3 || len(local.Keys) != 3 {
+		t.Errorf("unexpected merged key: %v", local)
+	}
+
+	conflicting := &files.SecretKey{}
+	for range 4 {
+		if err := conflicting.Generate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := local.Merge(conflicting); !errors.Is(err, files.ErrKeyConflict) {
+		t.Errorf("unexpected error merging conflicting key: %v", err)
+	}
+
+	if local.LatestKey != 3 || len(local.Keys) != 3 {
+		t.Errorf("conflicting merge changed key: %v", local)
+	}
+
+	if !bytes.Equal(local.Keys[2].Secret(), exchange.Keys[2].Secret()) {
+		t.Error("conflicting merge changed secret")
+	}
+}
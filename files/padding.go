@@ -0,0 +1,254 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Padding schemes. They are recorded in the file header's flags.
+const (
+	// PaddingNone doesn't pad files
+	PaddingNone = uint8(iota)
+	// PaddingPow2 pads files to the next power of two
+	PaddingPow2
+	// PaddingBlock pads files to a multiple of a fixed block size
+	PaddingBlock
+	// PaddingPadme pads files with the Padmé scheme, which leaks at most
+	// O(log log n) bits of file size, with at most 12% overhead
+	PaddingPadme
+
+	// MaxPaddingBlockSize is the largest block size of PaddingBlock scheme.
+	// Every file grows by up to one block, which is written as zeros.
+	MaxPaddingBlockSize = 16 * 1024 * 1024
+
+	paddingShift = 4
+	paddingMask  = uint32(0xf) << paddingShift
+
+	// paddingMarker starts padding (as in ISO/IEC 7816-4), followed by zeros
+	paddingMarker = byte(0x80)
+)
+
+var (
+	ErrInvalidPadding = errors.New("invalid padding")
+
+	paddingNames = map[uint8]string{
+		PaddingNone:  "none",
+		PaddingPow2:  "pow2",
+		PaddingBlock: "block",
+		PaddingPadme: "padme",
+	}
+)
+
+// Padding describes how plaintext is padded before encryption, to hide its
+// exact size
+type Padding struct {
+	Scheme uint8
+	// BlockSize is the bucket size of PaddingBlock scheme
+	BlockSize int64
+}
+
+// ParsePadding parses padding descriptions, like "none", "pow2",
+// "block:4096", or "padme"
+func ParsePadding(desc string) (Padding, error) {
+	name, param, hasParam := strings.Cut(strings.ToLower(strings.TrimSpace(desc)), ":")
+
+	for scheme, schemeName := range paddingNames {
+		if name != schemeName {
+			continue
+		}
+
+		if scheme != PaddingBlock {
+			if hasParam {
+				return Padding{}, fmt.Errorf("%w: %q takes no parameters", ErrInvalidPadding, desc)
+			}
+
+			return Padding{Scheme: scheme}, nil
+		}
+
+		blockSize, err := strconv.ParseInt(param, 10, 64)
+		if err != nil || blockSize < 1 || blockSize > MaxPaddingBlockSize {
+			return Padding{}, fmt.Errorf(
+				"%w: %q requires a block size between 1 and %d",
+				ErrInvalidPadding,
+				desc,
+				MaxPaddingBlockSize,
+			)
+		}
+
+		return Padding{Scheme: scheme, BlockSize: blockSize}, nil
+	}
+
+	return Padding{}, fmt.Errorf("%w: unknown scheme %q", ErrInvalidPadding, desc)
+}
+
+func (p Padding) validate() error {
+	if _, ok := paddingNames[p.Scheme]; !ok {
+		return fmt.Errorf("%w: unknown scheme %d", ErrInvalidPadding, p.Scheme)
+	}
+
+	if p.Scheme == PaddingBlock && (p.BlockSize < 1 || p.BlockSize > MaxPaddingBlockSize) {
+		return fmt.Errorf("%w: invalid block size %d", ErrInvalidPadding, p.BlockSize)
+	}
+
+	return nil
+}
+
+func (p Padding) String() string {
+	name, ok := paddingNames[p.Scheme]
+	if !ok {
+		return "unknown"
+	}
+
+	if p.Scheme == PaddingBlock && p.BlockSize > 0 {
+		return fmt.Sprintf("%s:%d", name, p.BlockSize)
+	}
+
+	return name
+}
+
+// PaddedSize returns the padded size of a plaintext of size bytes, including
+// the padding marker
+func (p Padding) PaddedSize(size int64) int64 {
+	size++ // padding marker
+
+	switch p.Scheme {
+	case PaddingPow2:
+		return int64(1) << bits.Len64(uint64(size-1)) //nolint:gosec
+	case PaddingBlock:
+		return (size + p.BlockSize - 1) / p.BlockSize * p.BlockSize
+	case PaddingPadme:
+		if size < 2 {
+			return size
+		}
+
+		exp := bits.Len64(uint64(size)) - 1       //nolint:gosec
+		lastBits := exp - bits.Len64(uint64(exp)) //nolint:gosec
+		mask := int64(1)<<lastBits - 1
+
+		return (size + mask) &^ mask
+	}
+
+	return size
+}
+
+// Padding returns the padding scheme the file is encoded with
+func (h *FileHeader) Padding() uint8 {
+	return uint8((h.Flags & paddingMask) >> paddingShift)
+}
+
+func (h *FileHeader) setPadding(scheme uint8) {
+	h.Flags = h.Flags&^paddingMask | uint32(scheme)<<paddingShift&paddingMask
+}
+
+// padWriter counts plaintext written, and appends padding on Close
+type padWriter struct {
+	io.Writer
+	padding Padding
+	size    int64
+}
+
+func (w *padWriter) Write(data []byte) (int, error) {
+	n, err := w.Writer.Write(data)
+	w.size += int64(n)
+
+	return n, err
+}
+
+// Close writes the padding marker, and zeros up to the padded size
+func (w *padWriter) Close() error {
+	if _, err := w.Writer.Write([]byte{paddingMarker}); err != nil {
+		return err
+	}
+
+	return writeZeros(w.Writer, w.padding.PaddedSize(w.size)-w.size-1)
+}
+
+// unpadWriter strips padding from the end of the stream. It holds back the
+// last padding marker candidate, and counts zeros following it, instead of
+// buffering them.
+type unpadWriter struct {
+	io.Writer
+	marker bool
+	zeros  int64
+}
+
+func (w *unpadWriter) Write(data []byte) (int, error) {
+	written := len(data)
+
+	if w.marker {
+		idx := 0
+		for idx < len(data) && data[idx] == 0 {
+			idx++
+		}
+
+		w.zeros += int64(idx)
+		data = data[idx:]
+
+		if len(data) == 0 {
+			return written, nil
+		}
+
+		// not padding: flush held back data
+		if err := w.flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	last := len(data) - 1
+	for last >= 0 && data[last] == 0 {
+		last--
+	}
+
+	if last >= 0 && data[last] == paddingMarker {
+		w.marker = true
+		w.zeros = int64(len(data) - last - 1)
+		data = data[:last]
+	}
+
+	if _, err := w.Writer.Write(data); err != nil {
+		return 0, err
+	}
+
+	return written, nil
+}
+
+func (w *unpadWriter) flush() error {
+	w.marker = false
+
+	if _, err := w.Writer.Write([]byte{paddingMarker}); err != nil {
+		return err
+	}
+
+	zeros := w.zeros
+	w.zeros = 0
+
+	return writeZeros(w.Writer, zeros)
+}
+
+// Close verifies the stream ended with padding
+func (w *unpadWriter) Close() error {
+	if !w.marker {
+		return fmt.Errorf("%w: padding marker not found", ErrInvalidPadding)
+	}
+
+	return nil
+}
+
+func writeZeros(writer io.Writer, count int64) error {
+	zeros := make([]byte, min(count, 32*1024))
+
+	for count > 0 {
+		n := min(count, int64(len(zeros)))
+		if _, err := writer.Write(zeros[:n]); err != nil {
+			return err
+		}
+
+		count -= n
+	}
+
+	return nil
+}
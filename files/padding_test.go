@@ -0,0 +1,186 @@
+package files_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/julian7/redact/files"
+)
+
+func TestParsePadding(t *testing.T) {
+	tt := []struct {
+		desc    string
+		padding files.Padding
+		err     error
+	}{
+		{desc: "none", padding: files.Padding{Scheme: files.PaddingNone}},
+		{desc: "pow2", padding: files.Padding{Scheme: files.PaddingPow2}},
+		{desc: "Padme", padding: files.Padding{Scheme: files.PaddingPadme}},
+		{desc: "block:4096", padding: files.Padding{Scheme: files.PaddingBlock, BlockSize: 4096}},
+		{desc: "block", err: files.ErrInvalidPadding},
+		{desc: "block:16777216", padding: files.Padding{Scheme: files.PaddingBlock, BlockSize: 16777216}},
+		{desc: "block:0", err: files.ErrInvalidPadding},
+		{desc: "block:16777217", err: files.ErrInvalidPadding},
+		{desc: "block:9223372036854775807", err: files.ErrInvalidPadding},
+		{desc: "pow2:16", err: files.ErrInvalidPadding},
+		{desc: "random", err: files.ErrInvalidPadding},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			padding, err := files.ParsePadding(tc.desc)
+			if !errors.Is(err, tc.err) {
+				t.Errorf("unexpected error. Expected: %v, received: %v", tc.err, err)
+			}
+
+			if padding != tc.padding {
+				t.Errorf("unexpected padding. Expected: %v, received: %v", tc.padding, padding)
+			}
+		})
+	}
+}
+
+func TestPaddedSize(t *testing.T) {
+	tt := []struct {
+		padding  string
+		size     int64
+		expected int64
+	}{
+		{padding: "none", size: 100, expected: 101},
+		{padding: "pow2", size: 0, expected: 1},
+		{padding: "pow2", size: 7, expected: 8},
+		{padding: "pow2", size: 8, expected: 16},
+		{padding: "pow2", size: 1000, expected: 1024},
+		{padding: "block:512", size: 0, expected: 512},
+		{padding: "block:512", size: 511, expected: 512},
+		{padding: "block:512", size: 512, expected: 1024},
+		{padding: "padme", size: 0, expected: 1},
+		{padding: "padme", size: 8, expected: 10},
+		{padding: "padme", size: 1000, expected: 1024},
+		{padding: "padme", size: 10000, expected: 10240},
+		{padding: "padme", size: 1000000, expected: 1015808},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.padding, func(t *testing.T) {
+			padding, err := files.ParsePadding(tc.padding)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if received := padding.PaddedSize(tc.size); received != tc.expected {
+				t.Errorf("unexpected padded size of %d. Expected: %d, received: %d", tc.size, tc.expected, received)
+			}
+		})
+	}
+}
+
+func TestPaddingRoundtrip(t *testing.T) { //nolint:funlen
+	k, err := genGitRepo()
+	if err != nil {
+		t.Error(err)
+
+		return
+	}
+
+	if err := writeKey(k); err != nil {
+		t.Error(err)
+
+		return
+	}
+
+	if err := k.Load(false); err != nil {
+		t.Error(err)
+
+		return
+	}
+
+	large := bytes.Repeat([]byte(samplePlaintext), 100)
+
+	tt := []struct {
+		name      string
+		padding   string
+		plaintext []byte
+		size      int64
+	}{
+		{name: "empty", padding: "pow2", plaintext: []byte{}, size: 1},
+		{name: "short", padding: "pow2", plaintext: []byte("foo"), size: 4},
+		{name: "text", padding: "pow2", plaintext: []byte(samplePlaintext), size: 1024},
+		{name: "trailing marker", padding: "pow2", plaintext: []byte("foo\x80"), size: 8},
+		{name: "trailing marker and zeros", padding: "block:16", plaintext: []byte("foo\x80\x00\x00\x00"), size: 16},
+		{name: "zeros", padding: "block:16", plaintext: make([]byte, 20), size: 32},
+		{name: "marker in padding block", padding: "block:16", plaintext: []byte("\x80\x00\x00\x80"), size: 16},
+		{name: "multiple segments", padding: "padme", plaintext: large, size: files.Padding{Scheme: files.PaddingPadme}.PaddedSize(int64(len(large)))},
+		{name: "segment boundary", padding: "block:65536", plaintext: append(make([]byte, 65530), 0x80, 0, 0, 0, 0, 0), size: 131072},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			padding, err := files.ParsePadding(tc.padding)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ciphertext := bytes.NewBuffer(nil)
+
+			err = k.EncodeWithOptions(0, 1, &files.FileOptions{Padding: padding}, bytes.NewReader(tc.plaintext), ciphertext)
+			if err != nil {
+				t.Fatalf("cannot encode: %v", err)
+			}
+
+			hdr, err := k.FileStatus(bytes.NewReader(ciphertext.Bytes()))
+			if err != nil {
+				t.Fatalf("cannot read header: %v", err)
+			}
+
+			if hdr.Padding() != padding.Scheme {
+				t.Errorf("unexpected padding scheme in header: %d", hdr.Padding())
+			}
+
+			segments := (tc.size + 65535) / 65536
+			expectedLen := 26 + tc.size + segments*28
+
+			if int64(ciphertext.Len()) != expectedLen {
+				t.Errorf("unexpected ciphertext size. Expected: %d, received: %d", expectedLen, ciphertext.Len())
+			}
+
+			out := bytes.NewBuffer(nil)
+			if err := k.Decode(ciphertext, out); err != nil {
+				t.Fatalf("cannot decode: %v", err)
+			}
+
+			if !bytes.Equal(out.Bytes(), tc.plaintext) {
+				t.Errorf("decoded plaintext not matching: %q", out.Bytes())
+			}
+		})
+	}
+}
+
+func TestPaddingLimit(t *testing.T) {
+	k, err := genGitRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeKey(k); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k.Load(false); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &files.FileOptions{
+		Padding: files.Padding{Scheme: files.PaddingBlock, BlockSize: files.MaxPaddingBlockSize + 1},
+	}
+
+	err = k.EncodeWithOptions(0, 1, opts, bytes.NewReader([]byte(samplePlaintext)), io.Discard)
+	if !errors.Is(err, files.ErrInvalidPadding) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package files
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/julian7/redact/securemem"
+)
+
+const (
+	// KeyTypeProtected is a v1 key file, encrypted with a key derived from
+	// a passphrase
+	KeyTypeProtected = 2
+
+	// KDFArgon2id is the Argon2id key derivation function
+	KDFArgon2id = uint8(1)
+
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	// argon2MaxTime and argon2MaxMemory limit KDF parameters of key files
+	// read, to avoid excessive resource use with crafted files
+	argon2MaxTime   = 16
+	argon2MaxMemory = 1024 * 1024
+
+	protectSaltSize = 16
+)
+
+var (
+	ErrPassphraseRequired = errors.New("key file is protected, passphrase required")
+	ErrEmptyPassphrase    = errors.New("empty passphrase")
+	ErrWrongPassphrase    = errors.New("wrong passphrase, or corrupted key file")
+	ErrInvalidKDF         = errors.New("invalid key derivation parameters")
+)
+
+// PassphraseFunc provides the passphrase of a protected key file
+type PassphraseFunc func() ([]byte, error)
+
+// kdfParams are parameters of the key derivation function of protected key
+// files
+type kdfParams struct {
+	KDF     uint8
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	Salt    [protectSaltSize]byte
+}
+
+func newKDFParams() (*kdfParams, error) {
+	params := &kdfParams{
+		KDF:     KDFArgon2id,
+		Time:    argon2Time,
+		Memory:  argon2Memory,
+		Threads: argon2Threads,
+	}
+
+	if _, err := rand.Read(params.Salt[:]); err != nil {
+		return nil, fmt.Errorf("generating salt: %w", err)
+	}
+
+	return params, nil
+}
+
+func (p *kdfParams) validate() error {
+	if p.KDF != KDFArgon2id {
+		return fmt.Errorf("%w: unknown function %d", ErrInvalidKDF, p.KDF)
+	}
+
+	if p.Time == 0 || p.Time > argon2MaxTime || p.Memory == 0 || p.Memory > argon2MaxMemory || p.Threads == 0 {
+		return fmt.Errorf("%w: time=%d memory=%d threads=%d", ErrInvalidKDF, p.Time, p.Memory, p.Threads)
+	}
+
+	return nil
+}
+
+func (p *kdfParams) deriveKey(passphrase []byte) []byte {
+	return argon2.IDKey(passphrase, p.Salt[:], p.Time, p.Memory, p.Threads, chacha20poly1305.KeySize)
+}
+
+// cipher returns the key file cipher with a key derived from the passphrase
+func (p *kdfParams) cipher(passphrase []byte) (cipher.AEAD, error) {
+	key := p.deriveKey(passphrase)
+	defer securemem.Wipe(key)
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating key cipher: %w", err)
+	}
+
+	return aead, nil
+}
+
+// Protected returns whether the key file is protected with a passphrase
+func (k *SecretKey) Protected() bool {
+	return k.passphrase != nil
+}
+
+// Protect sets a passphrase, which protects the key file on next save
+func (k *SecretKey) Protect(passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return ErrEmptyPassphrase
+	}
+
+	// passphrase may be the current one, which is wiped after copying
+	previous := k.passphrase
+	k.passphrase = securemem.New(len(passphrase))
+	copy(k.passphrase.Bytes(), passphrase)
+	previous.Destroy()
+
+	return nil
+}
+
+// Unprotect removes passphrase protection of the key file on next save
+func (k *SecretKey) Unprotect() {
+	k.passphrase.Destroy()
+	k.passphrase = nil
+}
+
+// readProtected decrypts a protected key file, and reads its contents
+func (k *SecretKey) readProtected(f io.Reader) error {
+	params := &kdfParams{}
+
+	if err := binary.Read(f, binary.BigEndian, params); err != nil {
+		return fmt.Errorf("reading key derivation parameters: %w", err)
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := io.ReadFull(f, nonce); err != nil {
+		return fmt.Errorf("reading key nonce: %w", err)
+	}
+
+	ciphertext, err := io.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("reading key data: %w", err)
+	}
+
+	passphrase := k.passphrase.Bytes()
+	if passphrase == nil {
+		if k.Passphrase == nil {
+			return ErrPassphraseRequired
+		}
+
+		passphrase, err = k.Passphrase()
+		if err != nil {
+			return fmt.Errorf("reading passphrase: %w", err)
+		}
+	}
+
+	aead, err := params.cipher(passphrase)
+	if err != nil {
+		return err
+	}
+
+	plaintext := securemem.New(max(0, len(ciphertext)-aead.Overhead()))
+	defer plaintext.Destroy()
+
+	if _, err := aead.Open(plaintext.Bytes()[:0], nonce, ciphertext, protectedHeader(params, nonce)); err != nil {
+		return ErrWrongPassphrase
+	}
+
+	protected := &SecretKey{}
+	if err := protected.Read(bytes.NewReader(plaintext.Bytes())); err != nil {
+		return fmt.Errorf("reading protected key: %w", err)
+	}
+
+	for _, key := range protected.Keys {
+		if err := k.addKey(key); err != nil {
+			return err
+		}
+	}
+
+	return k.Protect(passphrase)
+}
+
+// saveProtectedTo saves secret key into IO stream, encrypted with a key
+// derived from the passphrase
+func (k *SecretKey) saveProtectedTo(writer io.Writer) error {
+	return k.saveProtectedWith(writer, k.passphrase.Bytes())
+}
+
+// saveProtectedWith saves secret key into IO stream in protected key file
+// format, encrypted with a key derived from a passphrase
+func (k *SecretKey) saveProtectedWith(writer io.Writer, passphrase []byte) error {
+	params, err := newKDFParams()
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("generating key nonce: %w", err)
+	}
+
+	aead, err := params.cipher(passphrase)
+	if err != nil {
+		return err
+	}
+
+	plaintext := securemem.New(0)
+	defer plaintext.Destroy()
+
+	if err := k.SaveTo(plaintext); err != nil {
+		return err
+	}
+
+	header := protectedHeader(params, nonce)
+
+	if _, err := writer.Write(header); err != nil {
+		return fmt.Errorf("writing protected key header: %w", err)
+	}
+
+	if _, err := writer.Write(aead.Seal(nil, nonce, plaintext.Bytes(), header)); err != nil {
+		return fmt.Errorf("writing protected key: %w", err)
+	}
+
+	return nil
+}
+
+// protectedHeader returns the header of a protected key file, which is
+// authenticated as associated data
+func protectedHeader(params *kdfParams, nonce []byte) []byte {
+	header := bytes.NewBufferString(KeyMagic)
+	_ = binary.Write(header, binary.BigEndian, uint32(KeyTypeProtected))
+	_ = binary.Write(header, binary.BigEndian, params)
+	header.Write(nonce)
+
+	return header.Bytes()
+}
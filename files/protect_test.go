@@ -0,0 +1,272 @@
+package files_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/util"
+
+	"github.com/julian7/redact/files"
+)
+
+func TestProtect(t *testing.T) { //nolint:funlen
+	passphrase := []byte("correct horse battery staple")
+
+	tt := []struct {
+		name       string
+		passphrase files.PassphraseFunc
+		tamper     func([]byte)
+		err        error
+	}{
+		{
+			name:       "success",
+			passphrase: func() ([]byte, error) { return passphrase, nil },
+		},
+		{
+			name: "no passphrase",
+			err:  files.ErrPassphraseRequired,
+		},
+		{
+			name:       "wrong passphrase",
+			passphrase: func() ([]byte, error) { return []byte("wrong"), nil },
+			err:        files.ErrWrongPassphrase,
+		},
+		{
+			name:       "passphrase error",
+			passphrase: func() ([]byte, error) { return nil, files.ErrEmptyPassphrase },
+			err:        files.ErrEmptyPassphrase,
+		},
+		{
+			name:       "tampered salt",
+			passphrase: func() ([]byte, error) { return passphrase, nil },
+			tamper:     func(data []byte) { data[len(files.KeyMagic)+4+10] ^= 1 },
+			err:        files.ErrWrongPassphrase,
+		},
+		{
+			name:       "excessive memory",
+			passphrase: func() ([]byte, error) { return passphrase, nil },
+			tamper: func(data []byte) {
+				binary.BigEndian.PutUint32(data[len(files.KeyMagic)+4+5:], 0xffffffff)
+			},
+			err: files.ErrInvalidKDF,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := genGitRepo()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := r.Generate(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := r.Protect(passphrase); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := r.Save(); err != nil {
+				t.Fatal(err)
+			}
+
+			dot, err := r.Workdir.Chroot(".git")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := util.ReadFile(dot, r.Keyfile())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if keyType := binary.BigEndian.Uint32(data[len(files.KeyMagic):]); keyType != files.KeyTypeProtected {
+				t.Fatalf("key file is not protected: type %d", keyType)
+			}
+
+			if bytes.Contains(data, r.Keys[1].Secret()) {
+				t.Fatal("key file contains plaintext secret")
+			}
+
+			if tc.tamper != nil {
+				tc.tamper(data)
+
+				if err := util.WriteFile(dot, r.Keyfile(), data, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			loaded, err := files.NewSecretKey(dot)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			loaded.Passphrase = tc.passphrase
+
+			err = loaded.Load(false)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("unexpected error. Expected: %v, received: %v", tc.err, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if !loaded.Protected() {
+				t.Error("loaded key is not protected")
+			}
+
+			if !bytes.Equal(loaded.Keys[1].Secret(), r.Keys[1].Secret()) {
+				t.Error("loaded key doesn't match")
+			}
+		})
+	}
+}
+
+func TestUnprotect(t *testing.T) {
+	r, err := genGitRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Protect(nil); !errors.Is(err, files.ErrEmptyPassphrase) {
+		t.Errorf("unexpected error of empty passphrase: %v", err)
+	}
+
+	if err := r.Protect([]byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Unprotect()
+
+	if r.Protected() {
+		t.Error("key is still protected")
+	}
+
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	dot, err := r.Workdir.Chroot(".git")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := files.NewSecretKey(dot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loaded.Load(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.Protected() {
+		t.Error("loaded key is protected")
+	}
+}
+
+func TestExportProtected(t *testing.T) { //nolint:funlen
+	passphrase := []byte("correct horse battery staple")
+
+	k := &files.SecretKey{}
+	if err := k.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k.ExportProtected(io.Discard, nil); !errors.Is(err, files.ErrEmptyPassphrase) {
+		t.Errorf("unexpected error of empty passphrase: %v", err)
+	}
+
+	exported := bytes.Buffer{}
+	if err := k.ExportProtected(&exported, passphrase); err != nil {
+		t.Fatal(err)
+	}
+
+	blk, _ := pem.Decode(exported.Bytes())
+	if blk == nil || blk.Type != files.PEMTypeEncrypted {
+		t.Fatalf("export is not an encrypted PEM block: %q", exported.String())
+	}
+
+	if bytes.Contains(blk.Bytes, k.Keys[1].Secret()) {
+		t.Fatal("export contains plaintext secret")
+	}
+
+	plain := bytes.Buffer{}
+	if err := k.Export(&plain); err != nil {
+		t.Fatal(err)
+	}
+
+	plainBlk, _ := pem.Decode(plain.Bytes())
+
+	tt := []struct {
+		name       string
+		exported   []byte
+		passphrase files.PassphraseFunc
+		err        error
+	}{
+		{
+			name:       "success",
+			exported:   exported.Bytes(),
+			passphrase: func() ([]byte, error) { return passphrase, nil },
+		},
+		{
+			name:     "no passphrase",
+			exported: exported.Bytes(),
+			err:      files.ErrPassphraseRequired,
+		},
+		{
+			name:       "wrong passphrase",
+			exported:   exported.Bytes(),
+			passphrase: func() ([]byte, error) { return []byte("wrong"), nil },
+			err:        files.ErrWrongPassphrase,
+		},
+		{
+			name:     "encrypted type of plain export",
+			exported: pem.EncodeToMemory(&pem.Block{Type: files.PEMTypeEncrypted, Bytes: plainBlk.Bytes}),
+			err:      files.ErrPEMTypeMismatch,
+		},
+		{
+			name:     "plain type of encrypted export",
+			exported: pem.EncodeToMemory(&pem.Block{Type: files.PEMType, Bytes: blk.Bytes}),
+			err:      files.ErrPEMTypeMismatch,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			imported := &files.SecretKey{
+				Passphrase:       func() ([]byte, error) { return nil, errors.New("key file passphrase requested") },
+				ExportPassphrase: tc.passphrase,
+			}
+
+			err := imported.Import(bytes.NewReader(tc.exported))
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("unexpected error. Expected: %v, received: %v", tc.err, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if !bytes.Equal(imported.Keys[1].Secret(), k.Keys[1].Secret()) {
+				t.Error("imported key doesn't match")
+			}
+
+			if imported.Protected() {
+				t.Error("imported key is protected with the export passphrase")
+			}
+		})
+	}
+}
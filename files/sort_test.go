@@ -3,6 +3,8 @@ package files
 import (
 	"fmt"
 	"testing"
+
+	keyV1 "github.com/julian7/redact/files/key_v1"
 )
 
 func TestLen(t *testing.T) {
@@ -76,11 +78,13 @@ func TestSwap(t *testing.T) {
 
 type fakeKey struct{ epoch uint32 }
 
-func (k *fakeKey) Type() uint32    { return 99 }
-func (k *fakeKey) Version() uint32 { return k.epoch }
-func (k *fakeKey) Generate() error { return nil }
-func (k *fakeKey) Secret() []byte  { return []byte("foo") }
-func (k *fakeKey) String() string  { return fmt.Sprintf("fakeKey #%d", k.epoch) }
+func (k *fakeKey) Type() uint32             { return 99 }
+func (k *fakeKey) Version() uint32          { return k.epoch }
+func (k *fakeKey) Generate() error          { return nil }
+func (k *fakeKey) Secret() []byte           { return []byte("foo") }
+func (k *fakeKey) Metadata() keyV1.Metadata { return keyV1.Metadata{} }
+func (k *fakeKey) Destroy()                 {}
+func (k *fakeKey) String() string           { return fmt.Sprintf("fakeKey #%d", k.epoch) }
 
 func TestEachKey(t *testing.T) {
 	tt := []struct {
@@ -0,0 +1,21 @@
+package gitutil
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// Renormalize adds files to the index again, running clean filters on them,
+// even if they look unchanged
+func Renormalize(files []string) error {
+	args := make([]string, 0, 4+len(files))
+	args = append(args, "add", "--renormalize", "--")
+	args = append(args, files...)
+
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("renormalizing files: %w: %s", err, out)
+	}
+
+	return nil
+}
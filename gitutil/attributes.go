@@ -2,22 +2,134 @@ package gitutil
 
 import (
 	"bufio"
-	"bytes"
-	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 )
 
-// CheckAttrs fills in filter attributes for file entries
-func (e *FileEntries) CheckAttrs() error {
-	cmd := exec.Command(
-		"git",
-		"check-attr",
-		"--stdin",
-		"filter",
-	)
+const (
+	// AttrSet is the value of an attribute set without value (like "attr")
+	AttrSet = "set"
+	// AttrUnset is the value of an explicitly unset attribute (like "-attr")
+	AttrUnset = "unset"
+	// AttrUnspecified is the value of an attribute not mentioned
+	AttrUnspecified = "unspecified"
+)
+
+// CheckAttr returns values of attributes of a single path
+func CheckAttr(path string, attrs ...string) (map[string]string, error) {
+	args := make([]string, 0, len(attrs)+4)
+	args = append(args, "check-attr", "-z")
+	args = append(args, attrs...)
+	args = append(args, "--", path)
+
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("checking attributes of %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(attrs))
+
+	err = parseCheckAttr(out, func(_, attr, value string) {
+		values[attr] = value
+	})
+	if err != nil {
+		return nil, fmt.Errorf("checking attributes of %s: %w", path, err)
+	}
+
+	return values, nil
+}
+
+// CheckAttrsAt returns values of attributes of paths, as of .gitattributes
+// files of a tree-ish (like a commit). Paths are relative to the top level
+// directory. The tree-ish is read into a temporary index, therefore neither
+// the index, nor the working tree is affected.
+func CheckAttrsAt(treeish string, paths []string, attrs ...string) (map[string]map[string]string, error) {
+	if len(paths) == 0 {
+		return map[string]map[string]string{}, nil
+	}
+
+	tmpdir, err := os.MkdirTemp("", "redact-index-")
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary index: %w", err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	env := append(os.Environ(), "GIT_INDEX_FILE="+filepath.Join(tmpdir, "index"))
+
+	toplevel, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return nil, fmt.Errorf("retrieving git rev-parse output: %w", err)
+	}
+
+	readTree := exec.Command("git", "read-tree", treeish)
+	readTree.Env = env
+
+	if out, err := readTree.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("reading tree %s: %w: %s", treeish, err, strings.TrimSpace(string(out)))
+	}
+
+	args := make([]string, 0, len(attrs)+4)
+	args = append(args, "check-attr", "--cached", "-z", "--stdin")
+	args = append(args, attrs...)
+
+	checkAttr := exec.Command("git", args...)
+	checkAttr.Env = env
+	checkAttr.Dir = strings.TrimSpace(string(toplevel))
+	checkAttr.Stdin = strings.NewReader(strings.Join(paths, "\000") + "\000")
+
+	out, err := checkAttr.Output()
+	if err != nil {
+		return nil, fmt.Errorf("checking attributes of %s: %w", treeish, err)
+	}
+
+	values := make(map[string]map[string]string, len(paths))
+
+	err = parseCheckAttr(out, func(path, attr, value string) {
+		if values[path] == nil {
+			values[path] = make(map[string]string, len(attrs))
+		}
+
+		values[path][attr] = value
+	})
+	if err != nil {
+		return nil, fmt.Errorf("checking attributes of %s: %w", treeish, err)
+	}
+
+	return values, nil
+}
+
+// parseCheckAttr parses "git check-attr -z" output, which is
+// "<path> NUL <attribute> NUL <info> NUL" for each path and attribute
+func parseCheckAttr(out []byte, cb func(path, attr, value string)) error {
+	if len(out) == 0 {
+		return nil
+	}
+
+	fields := strings.Split(strings.TrimSuffix(string(out), "\000"), "\000")
+	if len(fields)%3 != 0 {
+		return fmt.Errorf("%w: %q", ErrInvalidOutput, out)
+	}
+
+	for i := 0; i < len(fields); i += 3 {
+		cb(fields[i], fields[i+1], fields[i+2])
+	}
+
+	return nil
+}
+
+// CheckAttrs fills in filter attributes, and values of additional attributes
+// for file entries
+func (e *FileEntries) CheckAttrs(attrs ...string) error {
+	args := make([]string, 0, len(attrs)+4)
+	args = append(args, "check-attr", "-z", "--stdin", "filter")
+	args = append(args, attrs...)
+
+	cmd := exec.Command("git", args...)
 
 	feeder, err := cmd.StdinPipe()
 	if err != nil {
@@ -41,19 +153,27 @@ func (e *FileEntries) CheckAttrs() error {
 
 	go e.feedWithFileNames(feeder)
 
-	go e.logErrors(errorstream)
+	logged := make(chan struct{})
+
+	go func() {
+		e.logErrors(errorstream)
+		close(logged)
+	}()
 
 	err = e.readCheckAttrs(receiver)
 	if err != nil {
 		e.AddError("git command output", err)
 	}
 
+	// pipes are closed by Wait, therefore all reads have to be finished
+	<-logged
+
 	return cmd.Wait()
 }
 
 func (e *FileEntries) feedWithFileNames(writer io.WriteCloser) {
 	for _, entry := range e.Items {
-		_, err := writer.Write([]byte(entry.Name + "\n"))
+		_, err := writer.Write([]byte(entry.Name + "\000"))
 		if err != nil {
 			e.AddError(entry.Name, err)
 		}
@@ -62,9 +182,7 @@ func (e *FileEntries) feedWithFileNames(writer io.WriteCloser) {
 	writer.Close()
 }
 
-func (e FileEntries) readCheckAttrs(reader io.ReadCloser) error {
-	var err error
-
+func (e *FileEntries) readCheckAttrs(reader io.ReadCloser) error {
 	defer reader.Close()
 
 	idx := make(map[string]*FileEntry)
@@ -77,40 +195,27 @@ func (e FileEntries) readCheckAttrs(reader io.ReadCloser) error {
 		return err
 	}
 
-	outbuf := bytes.NewBuffer(out)
-
-	for {
-		var line string
-
-		line, err = outbuf.ReadString('\n')
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				err = nil
-			}
+	return parseCheckAttr(out, func(path, attr, value string) {
+		item, ok := idx[path]
+		if !ok {
+			e.AddError(path, ErrNotFound)
 
-			break
+			return
 		}
 
-		line = strings.TrimRight(line, "\n")
-		items := strings.Split(line, ": filter: ")
-
-		if len(items) != 2 {
-			err = fmt.Errorf(`finding filter entry in line: "%s": %w`, line, err)
+		if attr == "filter" {
+			item.Filter = value
 
-			break
+			return
 		}
 
-		item, ok := idx[items[0]]
-		if !ok {
-			e.AddError(items[0], ErrNotFound)
+		if item.Attrs == nil {
+			item.Attrs = map[string]string{}
 		}
 
-		item.Filter = items[1]
-	}
-
-	return err
+		item.Attrs[attr] = value
+	})
 }
-
 func (e *FileEntries) logErrors(input io.ReadCloser) {
 	defer input.Close()
 
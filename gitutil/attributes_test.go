@@ -0,0 +1,177 @@
+package gitutil_test
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"github.com/julian7/redact/gitutil"
+)
+
+const sampleGitAttributes = `*.key filter=redact diff=redact
+*.bin filter=redact redact-cipher=chacha20-poly1305 redact-epoch-min=4
+plain.bin -filter -redact-cipher
+`
+
+func setupAttrRepo(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Skipf("git init: %v: %s", err, out)
+	}
+
+	if err := os.WriteFile(".gitattributes", []byte(sampleGitAttributes), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckAttr(t *testing.T) {
+	setupAttrRepo(t)
+
+	tt := []struct {
+		path     string
+		expected map[string]string
+	}{
+		{
+			path:     "secret.key",
+			expected: map[string]string{"filter": "redact", "redact-cipher": "unspecified"},
+		},
+		{
+			path:     "dir/firmware.bin",
+			expected: map[string]string{"filter": "redact", "redact-cipher": "chacha20-poly1305"},
+		},
+		{
+			path:     "plain.bin",
+			expected: map[string]string{"filter": "unset", "redact-cipher": "unset"},
+		},
+		{
+			path:     "name with: colons.txt",
+			expected: map[string]string{"filter": "unspecified", "redact-cipher": "unspecified"},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.path, func(t *testing.T) {
+			attrs, err := gitutil.CheckAttr(tc.path, "filter", "redact-cipher")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(attrs, tc.expected) {
+				t.Errorf("unexpected attributes. Expected: %v, received: %v", tc.expected, attrs)
+			}
+		})
+	}
+}
+
+func TestCheckAttrs(t *testing.T) {
+	setupAttrRepo(t)
+
+	entries := gitutil.NewEntries()
+	for _, name := range []string{"secret.key", "firmware.bin", "plain.bin", "name with: filter: colons.txt"} {
+		entries.AddFile(&gitutil.FileEntry{Name: name})
+	}
+
+	if err := entries.CheckAttrs("redact-cipher", "redact-epoch-min"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries.Errors) > 0 {
+		t.Errorf("unexpected errors: %v", entries.Errors)
+	}
+
+	expected := []gitutil.FileEntry{
+		{
+			Name:   "secret.key",
+			Filter: "redact",
+			Attrs:  map[string]string{"redact-cipher": "unspecified", "redact-epoch-min": "unspecified"},
+		},
+		{
+			Name:   "firmware.bin",
+			Filter: "redact",
+			Attrs:  map[string]string{"redact-cipher": "chacha20-poly1305", "redact-epoch-min": "4"},
+		},
+		{
+			Name:   "plain.bin",
+			Filter: "unset",
+			Attrs:  map[string]string{"redact-cipher": "unset", "redact-epoch-min": "4"},
+		},
+		{
+			Name:   "name with: filter: colons.txt",
+			Filter: "unspecified",
+			Attrs:  map[string]string{"redact-cipher": "unspecified", "redact-epoch-min": "unspecified"},
+		},
+	}
+
+	for idx, entry := range entries.Items {
+		if !reflect.DeepEqual(*entry, expected[idx]) {
+			t.Errorf("unexpected entry. Expected: %+v, received: %+v", expected[idx], *entry)
+		}
+	}
+}
+
+func TestCheckAttrsAt(t *testing.T) {
+	setupAttrRepo(t)
+
+	first := commitAll(t, "first")
+
+	if err := os.WriteFile(".gitattributes", []byte("*.txt filter=redact\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	second := commitAll(t, "second")
+
+	// uncommitted attributes are ignored
+	if err := os.WriteFile(".gitattributes", []byte("* filter=other\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// paths are relative to the top level directory
+	if err := os.Mkdir("sub", 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Chdir("sub")
+
+	tt := []struct {
+		name     string
+		treeish  string
+		expected map[string]map[string]string
+	}{
+		{
+			name:    "first",
+			treeish: first,
+			expected: map[string]map[string]string{
+				"secret.key":    {"filter": "redact"},
+				"dir/notes.txt": {"filter": "unspecified"},
+				"dir/plain.bin": {"filter": "unset"},
+			},
+		},
+		{
+			name:    "second",
+			treeish: second,
+			expected: map[string]map[string]string{
+				"secret.key":    {"filter": "unspecified"},
+				"dir/notes.txt": {"filter": "redact"},
+				"dir/plain.bin": {"filter": "unspecified"},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			attrs, err := gitutil.CheckAttrsAt(tc.treeish, []string{"secret.key", "dir/notes.txt", "dir/plain.bin"}, "filter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(attrs, tc.expected) {
+				t.Errorf("unexpected attributes. Expected: %v, received: %v", tc.expected, attrs)
+			}
+		})
+	}
+}
@@ -6,7 +6,14 @@ import (
 	"os/exec"
 )
 
-// Cat "cat"s a file by SHA1 hash
+// catReader reads a blob from a "git cat-file blob" process
+type catReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+// Cat "cat"s a file by SHA1 hash. The reader has to be closed, which waits
+// for the git process to exit. Use CatFile for reading many objects.
 func Cat(objectID []byte) (io.ReadCloser, error) {
 	cmd := exec.Command( //nolint:gosec
 		"git",
@@ -24,5 +31,14 @@ func Cat(objectID []byte) (io.ReadCloser, error) {
 		return nil, err
 	}
 
-	return out, nil
+	return &catReader{ReadCloser: out, cmd: cmd}, nil
+}
+
+// Close closes git's output, and waits for it to exit. Git is not expected
+// to exit successfully, if its output was not read completely.
+func (r *catReader) Close() error {
+	err := r.ReadCloser.Close()
+	_ = r.cmd.Wait()
+
+	return err
 }
@@ -0,0 +1,207 @@
+package gitutil
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// CatFile reads objects through a single "git cat-file --batch" process,
+// instead of starting a git process for every object. It is not safe for
+// concurrent use.
+type CatFile struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	object *catFileObject
+	err    error
+}
+
+// catFileObject reads contents of the current object from git's output
+type catFileObject struct {
+	reader    *bufio.Reader
+	remaining int64
+}
+
+// maxSkip is the largest amount of unread contents discarded from git's
+// output. Beyond that, restarting git is cheaper than reading the rest of
+// the object.
+const maxSkip = 1024 * 1024
+
+// NewCatFile starts a "git cat-file --batch" process
+func NewCatFile() (*CatFile, error) {
+	c := &CatFile{}
+
+	if err := c.start(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// start starts the git process
+func (c *CatFile) start() error {
+	cmd := exec.Command("git", "cat-file", "--batch")
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("getting git command input pipe: %w", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("getting git command output pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting git cat-file: %w", err)
+	}
+
+	c.cmd = cmd
+	c.stdin = stdin
+	c.stdout = bufio.NewReader(stdout)
+
+	return nil
+}
+
+// kill stops the git process without reading its pending output
+func (c *CatFile) kill() {
+	_ = c.stdin.Close()
+	_ = c.cmd.Process.Kill()
+	_ = c.cmd.Wait()
+
+	c.cmd = nil
+}
+
+// Blob returns a reader of a blob's contents by its object ID. The reader is
+// valid until the next call. Contents not read are skipped then, and git is
+// restarted instead of skipping more than maxSkip bytes, therefore reading
+// only the beginning of a blob (like a file header) is cheap.
+func (c *CatFile) Blob(objectID []byte) (io.Reader, error) {
+	name := hex.EncodeToString(objectID)
+
+	if err := c.skip(); err != nil {
+		return nil, err
+	}
+
+	if _, err := fmt.Fprintln(c.stdin, name); err != nil {
+		c.err = fmt.Errorf("writing git cat-file request: %w", err)
+
+		return nil, c.err
+	}
+
+	line, err := c.stdout.ReadString('\n')
+	if err != nil {
+		c.err = fmt.Errorf("reading git cat-file response: %w", err)
+
+		return nil, c.err
+	}
+
+	// <object ID> <type> <size>, or <object ID> missing
+	fields := strings.Fields(line)
+
+	if len(fields) == 2 && fields[1] == "missing" {
+		return nil, NewError(name, ErrNotFound)
+	}
+
+	if len(fields) != 3 {
+		c.err = fmt.Errorf("%w: git cat-file: %q", ErrInvalidOutput, line)
+
+		return nil, c.err
+	}
+
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || size < 0 {
+		c.err = fmt.Errorf("%w: git cat-file: %q", ErrInvalidOutput, line)
+
+		return nil, c.err
+	}
+
+	c.object = &catFileObject{reader: c.stdout, remaining: size}
+
+	if fields[1] != "blob" {
+		return nil, NewError(name, fmt.Errorf("%w: %s", ErrNotBlob, fields[1]))
+	}
+
+	return c.object, nil
+}
+
+// skip discards the rest of the current object, and the newline after it.
+// Large objects are not read: git is restarted instead.
+func (c *CatFile) skip() error {
+	if c.err != nil {
+		return c.err
+	}
+
+	if c.object == nil {
+		return nil
+	}
+
+	object := c.object
+	c.object = nil
+
+	if object.remaining >= maxSkip {
+		c.kill()
+
+		if err := c.start(); err != nil {
+			c.err = err
+		}
+
+		return c.err
+	}
+
+	if _, err := io.CopyN(io.Discard, c.stdout, object.remaining+1); err != nil {
+		c.err = fmt.Errorf("reading git cat-file response: %w", err)
+	}
+
+	return c.err
+}
+
+// Close stops the git process
+func (c *CatFile) Close() error {
+	if c.object != nil && c.object.remaining >= maxSkip {
+		c.kill()
+
+		return nil
+	}
+
+	_ = c.skip()
+
+	if c.cmd == nil {
+		return c.err
+	}
+
+	if err := c.stdin.Close(); err != nil {
+		return err
+	}
+
+	if err := c.cmd.Wait(); err != nil {
+		return fmt.Errorf("git cat-file: %w", err)
+	}
+
+	return nil
+}
+
+func (o *catFileObject) Read(data []byte) (int, error) {
+	if o.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(data)) > o.remaining {
+		data = data[:o.remaining]
+	}
+
+	n, err := o.reader.Read(data)
+	o.remaining -= int64(n)
+
+	if errors.Is(err, io.EOF) && o.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
@@ -0,0 +1,110 @@
+package gitutil_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/julian7/redact/gitutil"
+)
+
+func hashObject(t *testing.T, contents []byte) []byte {
+	t.Helper()
+
+	cmd := exec.Command("git", "hash-object", "-w", "--stdin")
+	cmd.Stdin = bytes.NewReader(contents)
+
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git hash-object: %v", err)
+	}
+
+	objectID, err := hex.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return objectID
+}
+
+func TestCatFile(t *testing.T) { //nolint:funlen
+	setupAttrRepo(t)
+
+	small := []byte("small blob\n")
+	large := bytes.Repeat([]byte("large blob "), 100000)
+	empty := []byte{}
+
+	smallID := hashObject(t, small)
+	largeID := hashObject(t, large)
+	emptyID := hashObject(t, empty)
+
+	out, err := exec.Command("git", "mktree").Output()
+	if err != nil {
+		t.Fatalf("git mktree: %v", err)
+	}
+
+	treeID, _ := hex.DecodeString(strings.TrimSpace(string(out)))
+	missingID := bytes.Repeat([]byte{0x42}, 20)
+
+	tt := []struct {
+		name     string
+		objectID []byte
+		read     int
+		expected []byte
+		err      error
+	}{
+		{name: "partially read", objectID: largeID, read: 10, expected: large[:10]},
+		{name: "small", objectID: smallID, read: -1, expected: small},
+		{name: "unread", objectID: largeID},
+		{name: "missing", objectID: missingID, err: gitutil.ErrNotFound},
+		{name: "not a blob", objectID: treeID, err: gitutil.ErrNotBlob},
+		{name: "empty", objectID: emptyID, read: -1, expected: empty},
+		{name: "large", objectID: largeID, read: -1, expected: large},
+	}
+
+	cat, err := gitutil.NewCatFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cases depend on each other, as they share the same git process
+	for _, tc := range tt {
+		reader, err := cat.Blob(tc.objectID)
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("%s: unexpected error. Expected: %v, received: %v", tc.name, tc.err, err)
+		}
+
+		if err != nil || tc.read == 0 {
+			continue
+		}
+
+		var data []byte
+
+		if tc.read < 0 {
+			data, err = io.ReadAll(reader)
+		} else {
+			data = make([]byte, tc.read)
+			_, err = io.ReadFull(reader, data)
+		}
+
+		if err != nil {
+			t.Fatalf("%s: reading blob: %v", tc.name, err)
+		}
+
+		if !bytes.Equal(data, tc.expected) {
+			t.Errorf("%s: unexpected contents (%d bytes instead of %d)", tc.name, len(data), len(tc.expected))
+		}
+	}
+
+	if _, err := cat.Blob(largeID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cat.Close(); err != nil {
+		t.Errorf("closing with unread contents: %v", err)
+	}
+}
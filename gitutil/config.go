@@ -1,8 +1,10 @@
 package gitutil
 
 import (
+	"errors"
 	"fmt"
 	"os/exec"
+	"strings"
 )
 
 // GitConfig sets configuration data
@@ -19,3 +21,31 @@ func GitConfig(key, val string) error {
 
 	return nil
 }
+
+// GitConfigGet returns a configuration value. It returns ok == false if the
+// value is not set.
+func GitConfigGet(key string) (value string, ok bool, err error) {
+	return gitConfigGet("--get", key)
+}
+
+// GitConfigBool returns a boolean configuration value. It returns ok == false
+// if the value is not set.
+func GitConfigBool(key string) (value bool, ok bool, err error) {
+	out, ok, err := gitConfigGet("--type=bool", "--get", key)
+
+	return out == "true", ok, err
+}
+
+func gitConfigGet(args ...string) (string, bool, error) {
+	out, err := exec.Command("git", append([]string{"config"}, args...)...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return "", false, nil
+		}
+
+		return "", false, fmt.Errorf("getting config %s: %w", args[len(args)-1], err)
+	}
+
+	return strings.TrimSpace(string(out)), true, nil
+}
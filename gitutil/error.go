@@ -10,6 +10,8 @@ type NamedError struct {
 
 var (
 	ErrGitCheckout        = fmt.Errorf("git checkout")
+	ErrInvalidOutput      = fmt.Errorf("invalid git output")
+	ErrNotBlob            = fmt.Errorf("not a blob")
 	ErrNotFound           = fmt.Errorf("not found")
 	ErrParsingGitRevParse = fmt.Errorf("error parsing git rev-parse")
 )
@@ -23,3 +25,8 @@ func (e *NamedError) Error() string {
 func NewError(name string, err error) *NamedError {
 	return &NamedError{Name: name, Orig: err}
 }
+
+// Unwrap returns the original error
+func (e *NamedError) Unwrap() error {
+	return e.Orig
+}
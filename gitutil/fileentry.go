@@ -7,6 +7,8 @@ import (
 // FileEntry contains a single file entry in a git repository
 type FileEntry struct {
 	Filter string
+	// Attrs contains additional attributes queried by CheckAttrs
+	Attrs  map[string]string
 	Mode   int64
 	Name   string
 	Status byte
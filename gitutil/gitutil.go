@@ -89,3 +89,20 @@ func (i *GitRepoInfo) FixCommon() error {
 
 	return nil
 }
+
+// HasHead returns whether HEAD points to a commit. It doesn't in new
+// repositories without commits.
+func HasHead() bool {
+	return exec.Command("git", "rev-parse", "--verify", "--quiet", "HEAD^{commit}").Run() == nil
+}
+
+// Prefix returns the path of the current directory relative to the top level
+// directory of the git repo, with a trailing slash (or empty at top level)
+func Prefix() (string, error) {
+	out, err := exec.Command("git", "rev-parse", "--show-prefix").Output()
+	if err != nil {
+		return "", fmt.Errorf("retrieving git rev-parse output: %w", err)
+	}
+
+	return strings.TrimRight(string(out), "\n"), nil
+}
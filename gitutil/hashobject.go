@@ -0,0 +1,24 @@
+package gitutil
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// HashObject returns the object ID of a file's contents as is, without
+// applying any filters
+func HashObject(filename string) ([]byte, error) {
+	out, err := exec.Command("git", "hash-object", "--no-filters", "--", filename).Output()
+	if err != nil {
+		return nil, fmt.Errorf("hashing %s: %w", filename, err)
+	}
+
+	objectID, err := hex.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil, fmt.Errorf("parsing object ID of %s: %w", filename, err)
+	}
+
+	return objectID, nil
+}
@@ -28,6 +28,16 @@ func LsFiles(files []string) (*FileEntries, error) {
 		args = append(args, files...)
 	}
 
+	return lsFiles(args)
+}
+
+// LsFilesFull returns all files in the index, with paths relative to the top
+// level directory, regardless of the current directory
+func LsFilesFull() (*FileEntries, error) {
+	return lsFiles([]string{"ls-files", "--cached", "--stage", "-t", "-z", "--full-name", "--", ":(top)"})
+}
+
+func lsFiles(args []string) (*FileEntries, error) {
 	out, err := exec.Command("git", args...).Output()
 	if err != nil {
 		return nil, fmt.Errorf("listing git files: %w", err)
@@ -56,8 +66,6 @@ func LsFiles(files []string) (*FileEntries, error) {
 		allEntries.AddFile(fileEntry)
 	}
 
-	_ = out
-
 	return allEntries, nil
 }
 
@@ -0,0 +1,56 @@
+package gitutil_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/julian7/redact/gitutil"
+)
+
+func TestListFull(t *testing.T) {
+	setupAttrRepo(t)
+
+	for _, dir := range []string{"sub", "other"} {
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(dir+"/a.key", []byte(dir), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	commitAll(t, "first")
+	t.Chdir("sub")
+
+	expected := []string{".gitattributes", "other/a.key", "sub/a.key"}
+
+	index, err := gitutil.LsFilesFull()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, entry := range index.Items {
+		names = append(names, entry.Name)
+	}
+
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("unexpected index entries. Expected: %v, received: %v", expected, names)
+	}
+
+	tree, err := gitutil.LsTreeFull("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names = []string{}
+	for _, entry := range tree {
+		names = append(names, entry.Filename)
+	}
+
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("unexpected tree entries. Expected: %v, received: %v", expected, names)
+	}
+}
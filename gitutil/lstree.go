@@ -18,10 +18,28 @@ type TreeEntry struct {
 	Filename string
 }
 
+// LsTree lists tree entries of a tree-ish, possibly filtered by paths
 func LsTree(treeish string, paths []string) ([]*TreeEntry, error) {
-	args := make([]string, 0, 3+len(paths))
-	args = append(args, "ls-tree", "-z", treeish)
-	args = append(args, paths...)
+	return lsTree([]string{"ls-tree", "-z", treeish}, paths)
+}
+
+// LsTreeRecursive lists all blobs of a tree-ish recursively, possibly
+// filtered by paths
+func LsTreeRecursive(treeish string, paths []string) ([]*TreeEntry, error) {
+	return lsTree([]string{"ls-tree", "-r", "-z", treeish}, paths)
+}
+
+// LsTreeFull lists all blobs of a tree-ish recursively, with paths relative
+// to the top level directory, regardless of the current directory
+func LsTreeFull(treeish string) ([]*TreeEntry, error) {
+	return lsTree([]string{"ls-tree", "-r", "-z", "--full-tree", treeish}, nil)
+}
+
+func lsTree(args []string, paths []string) ([]*TreeEntry, error) {
+	if len(paths) > 0 {
+		args = append(args, "--")
+		args = append(args, paths...)
+	}
 
 	out, err := exec.Command("git", args...).Output()
 	if err != nil {
@@ -0,0 +1,211 @@
+package gitutil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PktMaxDataSize is the maximum payload size of a single pkt-line
+	PktMaxDataSize = 65516
+
+	pktHeaderSize = 4
+	pktFlush      = "0000"
+)
+
+var (
+	ErrPktInvalidLength = errors.New("invalid pkt-line length")
+	ErrPktUnexpected    = errors.New("unexpected pkt-line")
+)
+
+// PktReader reads pkt-line framed data, as used by git's long running
+// process protocols (see gitprotocol-common(5)).
+type PktReader struct {
+	reader *bufio.Reader
+	buf    []byte
+}
+
+// PktWriter writes pkt-line framed data. Writes are buffered until the next
+// flush packet.
+type PktWriter struct {
+	writer *bufio.Writer
+}
+
+// NewPktReader returns a new PktReader
+func NewPktReader(reader io.Reader) *PktReader {
+	return &PktReader{
+		reader: bufio.NewReader(reader),
+		buf:    make([]byte, PktMaxDataSize),
+	}
+}
+
+// ReadPacket reads a single packet. It returns flush == true on flush packets.
+// The returned data is valid until the next read.
+func (r *PktReader) ReadPacket() ([]byte, bool, error) {
+	var hdr [pktHeaderSize]byte
+
+	if _, err := io.ReadFull(r.reader, hdr[:]); err != nil {
+		return nil, false, err
+	}
+
+	size, err := strconv.ParseUint(string(hdr[:]), 16, 16)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %q", ErrPktInvalidLength, hdr)
+	}
+
+	if size == 0 {
+		return nil, true, nil
+	}
+
+	if size <= pktHeaderSize || size-pktHeaderSize > PktMaxDataSize {
+		return nil, false, fmt.Errorf("%w: %d", ErrPktInvalidLength, size)
+	}
+
+	data := r.buf[:size-pktHeaderSize]
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return nil, false, fmt.Errorf("reading pkt-line: %w", err)
+	}
+
+	return data, false, nil
+}
+
+// ReadText reads text packets until the next flush packet, with their
+// trailing line feeds removed
+func (r *PktReader) ReadText() ([]string, error) {
+	lines := []string{}
+
+	for {
+		data, flush, err := r.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+
+		if flush {
+			return lines, nil
+		}
+
+		lines = append(lines, strings.TrimSuffix(string(data), "\n"))
+	}
+}
+
+// ReadKeyValues reads "key=value" text packets until the next flush packet
+func (r *PktReader) ReadKeyValues() (map[string]string, error) {
+	lines, err := r.ReadText()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(lines))
+
+	for _, line := range lines {
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrPktUnexpected, line)
+		}
+
+		values[key] = val
+	}
+
+	return values, nil
+}
+
+// Content returns a reader, which reads packet payloads until the next flush
+// packet as a stream
+func (r *PktReader) Content() io.Reader {
+	return &pktContentReader{pkt: r}
+}
+
+type pktContentReader struct {
+	pkt  *PktReader
+	data []byte
+	done bool
+}
+
+func (r *pktContentReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		data, flush, err := r.pkt.ReadPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return 0, err
+		}
+
+		r.data = data
+		r.done = flush
+	}
+
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+
+	return n, nil
+}
+
+// NewPktWriter returns a new PktWriter
+func NewPktWriter(writer io.Writer) *PktWriter {
+	return &PktWriter{writer: bufio.NewWriterSize(writer, PktMaxDataSize+pktHeaderSize)}
+}
+
+// WritePacket writes a single data packet
+func (w *PktWriter) WritePacket(data []byte) error {
+	if len(data) == 0 || len(data) > PktMaxDataSize {
+		return fmt.Errorf("%w: %d", ErrPktInvalidLength, len(data))
+	}
+
+	if _, err := fmt.Fprintf(w.writer, "%04x", len(data)+pktHeaderSize); err != nil {
+		return err
+	}
+
+	_, err := w.writer.Write(data)
+
+	return err
+}
+
+// WriteText writes text packets, one line each
+func (w *PktWriter) WriteText(lines ...string) error {
+	for _, line := range lines {
+		if err := w.WritePacket([]byte(line + "\n")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Flush writes a flush packet, and flushes buffered output
+func (w *PktWriter) Flush() error {
+	if _, err := w.writer.WriteString(pktFlush); err != nil {
+		return err
+	}
+
+	return w.writer.Flush()
+}
+
+// Write implements io.Writer: it splits data into packets of the largest
+// possible size. Closing the content stream is the caller's responsibility
+// with a flush packet.
+func (w *PktWriter) Write(data []byte) (int, error) {
+	written := 0
+
+	for len(data) > 0 {
+		n := min(len(data), PktMaxDataSize)
+
+		if err := w.WritePacket(data[:n]); err != nil {
+			return written, err
+		}
+
+		data = data[n:]
+		written += n
+	}
+
+	return written, nil
+}
@@ -0,0 +1,103 @@
+package gitutil_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/julian7/redact/gitutil"
+	"github.com/julian7/tester"
+)
+
+func TestPktReader(t *testing.T) {
+	tt := []struct {
+		name  string
+		input string
+		lines []string
+		err   error
+	}{
+		{name: "empty list", input: "0000", lines: []string{}},
+		{
+			name:  "text lines",
+			input: "0016git-filter-client\n000eversion=2\n0000",
+			lines: []string{"git-filter-client", "version=2"},
+		},
+		{name: "missing flush", input: "000eversion=2\n", err: io.EOF},
+		{name: "invalid length", input: "00zz", err: errors.New(`invalid pkt-line length: "00zz"`)},
+		{name: "too short", input: "0003", err: errors.New("invalid pkt-line length: 3")},
+		{name: "truncated", input: "0010foo", err: errors.New("reading pkt-line: unexpected EOF")},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			lines, err := gitutil.NewPktReader(bytes.NewBufferString(tc.input)).ReadText()
+			if err2 := tester.AssertError(tc.err, err); err2 != nil {
+				t.Error(err2)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if len(lines) != len(tc.lines) {
+				t.Errorf("unexpected lines: %q", lines)
+
+				return
+			}
+
+			for idx := range lines {
+				if lines[idx] != tc.lines[idx] {
+					t.Errorf("unexpected line #%d: %q", idx, lines[idx])
+				}
+			}
+		})
+	}
+}
+
+func TestPktContentRoundtrip(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), gitutil.PktMaxDataSize/8)
+	buf := &bytes.Buffer{}
+	writer := gitutil.NewPktWriter(buf)
+
+	if err := writer.WriteText("command=smudge", "pathname=secret.key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := writer.Write(content); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := gitutil.NewPktReader(buf)
+
+	values, err := reader.ReadKeyValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if values["command"] != "smudge" || values["pathname"] != "secret.key" {
+		t.Errorf("unexpected values: %v", values)
+	}
+
+	received, err := io.ReadAll(reader.Content())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(received, content) {
+		t.Errorf("content mismatch: received %d bytes instead of %d", len(received), len(content))
+	}
+
+	if buf.Len() != 0 {
+		t.Errorf("unread data after content: %d bytes", buf.Len())
+	}
+}
@@ -0,0 +1,22 @@
+package gitutil
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// RevList returns commit IDs of a revision range in chronological order,
+// oldest first
+func RevList(revs []string) ([]string, error) {
+	args := make([]string, 0, len(revs)+2)
+	args = append(args, "rev-list", "--reverse")
+	args = append(args, revs...)
+
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing commits: %w", err)
+	}
+
+	return strings.Fields(string(out)), nil
+}
@@ -0,0 +1,71 @@
+package gitutil_test
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/julian7/redact/gitutil"
+)
+
+// commitAll commits all changes of the working tree, and returns the commit
+// ID
+func commitAll(t *testing.T, message string) string {
+	t.Helper()
+
+	t.Setenv("GIT_AUTHOR_NAME", "redact")
+	t.Setenv("GIT_AUTHOR_EMAIL", "redact@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "redact")
+	t.Setenv("GIT_COMMITTER_EMAIL", "redact@example.com")
+
+	for _, args := range [][]string{{"add", "-A"}, {"commit", "-q", "-m", message}} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v: %s", args[0], err, out)
+		}
+	}
+
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		t.Fatalf("git rev-parse: %v", err)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+func TestRevList(t *testing.T) {
+	setupAttrRepo(t)
+
+	first := commitAll(t, "first")
+
+	if err := os.WriteFile("secret.key", []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	second := commitAll(t, "second")
+
+	tt := []struct {
+		name     string
+		revs     []string
+		expected []string
+	}{
+		{name: "all", revs: []string{"HEAD"}, expected: []string{first, second}},
+		{name: "range", revs: []string{first + "..HEAD"}, expected: []string{second}},
+		{name: "empty range", revs: []string{"HEAD..HEAD"}, expected: []string{}},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			commits, err := gitutil.RevList(tc.revs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(commits, tc.expected) {
+				t.Errorf("unexpected commits. Expected: %v, received: %v", tc.expected, commits)
+			}
+		})
+	}
+}
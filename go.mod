@@ -1,6 +1,6 @@
 module github.com/julian7/redact
 
-go 1.26
+go 1.26.0
 
 require (
 	github.com/Azure/azure-sdk-for-go/sdk/azcore v1.22.0
@@ -14,6 +14,8 @@ require (
 	github.com/julian7/tester v0.0.0-20190708141839-fd2332449f51
 	github.com/urfave/cli/v3 v3.10.0
 	golang.org/x/crypto v0.53.0
+	golang.org/x/sys v0.48.0
+	golang.org/x/term v0.46.0
 )
 
 require (
@@ -40,6 +42,5 @@ require (
 	github.com/kylelemons/godebug v1.1.0 // indirect
 	github.com/pkg/browser v0.0.0-20240102092130-5ac0b6a4141c // indirect
 	golang.org/x/net v0.56.0 // indirect
-	golang.org/x/sys v0.46.0 // indirect
 	golang.org/x/text v0.38.0 // indirect
 )
@@ -1,7 +1,9 @@
 package kx
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -10,8 +12,10 @@ import (
 
 	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/go-git/go-billy/v5/util"
+	"github.com/julian7/redact/files"
 	"github.com/julian7/redact/gpgutil"
 	"github.com/julian7/redact/repo"
+	"github.com/julian7/redact/securemem"
 )
 
 // SecretKeyFromExchange loads data from an encrypted secret key into provided
@@ -33,13 +37,20 @@ func SecretKeyFromExchange(redactRepo *repo.Repo, fingerprint []byte) (io.ReadCl
 	return reader, nil
 }
 
-// SaveGPGKeyToKX saves secret key into key exchange, encrypted with OpenPGP key
-func SaveGPGKeyToKX(redactRepo *repo.Repo, key *openpgp.Entity, writerCallback func(io.Writer)) error {
+// SaveGPGKeyToKX saves secret key into key exchange, encrypted with OpenPGP
+// key. It saves keys of the epoch range only, and it records the range for
+// later updates.
+func SaveGPGKeyToKX(redactRepo *repo.Repo, key *openpgp.Entity, epochs files.EpochRange) error {
 	kxstub, err := redactRepo.GetExchangeFilenameStubFor(key.PrimaryKey.Fingerprint, nil)
 	if err != nil {
 		return err
 	}
 
+	secretKey, err := redactRepo.Subset(epochs)
+	if err != nil {
+		return err
+	}
+
 	secretName := repo.ExchangeSecretKeyFile(kxstub)
 
 	secretWriter, err := os.OpenFile(secretName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
@@ -48,14 +59,18 @@ func SaveGPGKeyToKX(redactRepo *repo.Repo, key *openpgp.Entity, writerCallback f
 	}
 	defer secretWriter.Close()
 
-	r, w := io.Pipe()
+	plaintext := securemem.New(0)
+	defer plaintext.Destroy()
 
-	go func(writer io.WriteCloser) {
-		writerCallback(writer)
-		writer.Close()
-	}(w)
+	if err := secretKey.SaveTo(plaintext); err != nil {
+		return err
+	}
 
-	return gpgutil.Encrypt(r, secretWriter, key)
+	if err := gpgutil.Encrypt(bytes.NewReader(plaintext.Bytes()), secretWriter, key); err != nil {
+		return err
+	}
+
+	return redactRepo.SaveExchangeEpochs(kxstub, epochs)
 }
 
 // LoadGPGPubkeysFromKX loads a public key from key exchange
@@ -96,8 +111,11 @@ func SaveGPGPubkeyToKX(redactRepo *repo.Repo, key *openpgp.Entity) error {
 	return nil
 }
 
-// UpdateGPGKeysInKX updates all key exchange secret keys with new data
-func UpdateGPGKeysInKX(redactRepo *repo.Repo, writerCallback func(io.Writer)) (int, error) {
+// UpdateGPGKeysInKX updates all key exchange secret keys with new data. Every
+// recipient gets keys of their recorded epoch range. Only recipients with
+// any of the epochs provided in their range are updated. Secret keys of recipients without any keys left in their range
+// (like after retiring their only epoch) are removed.
+func UpdateGPGKeysInKX(redactRepo *repo.Repo, epochs files.EpochRange) (int, error) {
 	kxdir := redactRepo.ExchangeDir()
 	updated := 0
 
@@ -110,7 +128,7 @@ func UpdateGPGKeysInKX(redactRepo *repo.Repo, writerCallback func(io.Writer)) (i
 			return nil
 		}
 
-		fingerprintText := strings.TrimRight(filepath.Base(path), repo.ExtKeyArmor)
+		fingerprintText := strings.TrimSuffix(filepath.Base(path), repo.ExtKeyArmor)
 
 		fingerprint, err := hex.DecodeString(fingerprintText)
 		if err != nil {
@@ -126,9 +144,25 @@ func UpdateGPGKeysInKX(redactRepo *repo.Repo, writerCallback func(io.Writer)) (i
 			return fmt.Errorf("key %s has %d public keys", fingerprintText, len(keys))
 		}
 
-		updated++
+		granted, err := redactRepo.LoadExchangeEpochs(strings.TrimSuffix(path, repo.ExtKeyArmor))
+		if err != nil {
+			return fmt.Errorf("loading epoch range for %s: %w", fingerprintText, err)
+		}
+
+		if _, err := redactRepo.Subset(granted); errors.Is(err, files.ErrNoKeysInRange) {
+			secretName := repo.ExchangeSecretKeyFile(strings.TrimSuffix(path, repo.ExtKeyArmor))
+			if err := redactRepo.Workdir.Remove(secretName); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("removing secret key of %s: %w", fingerprintText, err)
+			}
+
+			return nil
+		}
+
+		err = saveGPGKeyInRange(redactRepo, keys[0], granted, epochs)
+		if errors.Is(err, files.ErrNoKeysInRange) {
+			return nil
+		}
 
-		err = SaveGPGKeyToKX(redactRepo, keys[0], writerCallback)
 		if err != nil {
 			return fmt.Errorf(
 				"saving secret key encrypted with key %s: %w",
@@ -137,6 +171,8 @@ func UpdateGPGKeysInKX(redactRepo *repo.Repo, writerCallback func(io.Writer)) (i
 			)
 		}
 
+		updated++
+
 		return nil
 	})
 	if err != nil {
@@ -145,3 +181,17 @@ func UpdateGPGKeysInKX(redactRepo *repo.Repo, writerCallback func(io.Writer)) (i
 
 	return updated, nil
 }
+
+// saveGPGKeyInRange saves secret key of the epoch range granted to a
+// recipient, if it intersects another epoch range. Recipients always get
+// their full granted range: selecting epochs only selects recipients to
+// update, so that earlier grants are never dropped.
+func saveGPGKeyInRange(redactRepo *repo.Repo, key *openpgp.Entity, granted, epochs files.EpochRange) error {
+	if !epochs.All() {
+		if _, err := redactRepo.Subset(granted.Intersect(epochs)); err != nil {
+			return err
+		}
+	}
+
+	return SaveGPGKeyToKX(redactRepo, key, granted)
+}
@@ -0,0 +1,123 @@
+package kx_test
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-billy/v5/helper/chroot"
+	"github.com/go-git/go-billy/v5/osfs"
+
+	"github.com/julian7/redact/files"
+	"github.com/julian7/redact/kx"
+	"github.com/julian7/redact/repo"
+)
+
+func TestUpdateGPGKeysInKXKeepsGrants(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	workdir := osfs.New(dir)
+
+	secretKey, err := files.NewSecretKey(chroot.New(workdir, ".git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redactRepo := &repo.Repo{SecretKey: secretKey, Workdir: workdir}
+
+	for range 3 {
+		if err := redactRepo.Generate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entity, err := openpgp.NewEntity("Recipient", "", "recipient@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kx.SaveGPGPubkeyToKX(redactRepo, entity); err != nil {
+		t.Fatal(err)
+	}
+
+	granted := files.EpochRange{From: 2}
+	if err := kx.SaveGPGKeyToKX(redactRepo, entity, granted); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		epochs  files.EpochRange
+		updated int
+	}{
+		{name: "granted epoch", epochs: files.EpochRange{From: 3, To: 3}, updated: 1},
+		{name: "earlier epoch", epochs: files.EpochRange{From: 1, To: 1}, updated: 0},
+		{name: "all epochs", epochs: files.EpochRange{}, updated: 1},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			updated, err := kx.UpdateGPGKeysInKX(redactRepo, tc.epochs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if updated != tc.updated {
+				t.Errorf("expected %d updated keys, got %d", tc.updated, updated)
+			}
+
+			stub, err := redactRepo.GetExchangeFilenameStubFor(entity.PrimaryKey.Fingerprint, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorded, err := redactRepo.LoadExchangeEpochs(stub)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if recorded != granted {
+				t.Errorf("expected recorded range %s, got %s", granted, recorded)
+			}
+
+			epochs := exchangeEpochs(t, repo.ExchangeSecretKeyFile(stub), entity)
+			if !slices.Equal(epochs, []uint32{2, 3}) {
+				t.Errorf("expected epochs [2 3] in exchange, got %v", epochs)
+			}
+		})
+	}
+}
+
+func exchangeEpochs(t *testing.T, name string, entity *openpgp.Entity) []uint32 {
+	t.Helper()
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := openpgp.ReadMessage(bytes.NewReader(data), openpgp.EntityList{entity}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := files.NewSecretKeyIn(nil)
+	defer key.Destroy()
+
+	if err := key.Read(msg.UnverifiedBody); err != nil {
+		t.Fatal(err)
+	}
+
+	epochs := make([]uint32, 0, len(key.Keys))
+	for epoch := range key.Keys {
+		epochs = append(epochs, epoch)
+	}
+
+	slices.Sort(epochs)
+
+	return epochs
+}
@@ -0,0 +1,63 @@
+package repo
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/julian7/redact/encoder"
+	"github.com/julian7/redact/gitutil"
+)
+
+const (
+	// AttrCipher is the gitattribute selecting encoding type of files
+	// (like `*.bin filter=redact diff=redact redact-cipher=chacha20-poly1305`)
+	AttrCipher = "redact-cipher"
+	// AttrEpochMin is the gitattribute setting minimum key epoch of files
+	// (like `*.key filter=redact diff=redact redact-epoch-min=4`)
+	AttrEpochMin = "redact-epoch-min"
+)
+
+// FileAttrs are gitattributes affecting encryption of individual files
+var FileAttrs = []string{AttrBindPath, AttrPadding, AttrCompress, AttrCipher, AttrEpochMin}
+
+// attrValue returns the value of an attribute, and whether it has a value
+func attrValue(value string) (string, bool) {
+	switch value {
+	case "", gitutil.AttrUnspecified, gitutil.AttrSet, gitutil.AttrUnset:
+		return "", false
+	}
+
+	return value, true
+}
+
+// ParseCipherAttr parses the value of the redact-cipher attribute. It
+// returns false when the attribute has no value.
+func ParseCipherAttr(value string) (uint32, bool, error) {
+	name, ok := attrValue(value)
+	if !ok {
+		return 0, false, nil
+	}
+
+	encType, err := encoder.FindEncoder(name)
+	if err != nil {
+		return 0, false, fmt.Errorf("%w: %s attribute %q: %w", ErrInvalidAttribute, AttrCipher, value, err)
+	}
+
+	return encType, true, nil
+}
+
+// ParseEpochMinAttr parses the value of the redact-epoch-min attribute. It
+// returns false when the attribute has no value.
+func ParseEpochMinAttr(value string) (uint32, bool, error) {
+	num, ok := attrValue(value)
+	if !ok {
+		return 0, false, nil
+	}
+
+	epoch, err := strconv.ParseUint(num, 10, 32)
+	if err != nil {
+		return 0, false, fmt.Errorf("%w: %s attribute %q", ErrInvalidAttribute, AttrEpochMin, value)
+	}
+
+	return uint32(epoch), true, nil
+}
@@ -0,0 +1,69 @@
+package repo_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/julian7/redact/encoder"
+	"github.com/julian7/redact/repo"
+)
+
+func TestParseCipherAttr(t *testing.T) {
+	tt := []struct {
+		value   string
+		encType uint32
+		ok      bool
+		err     error
+	}{
+		{value: "unspecified"},
+		{value: "set"},
+		{value: "unset"},
+		{value: "chacha20-poly1305", encType: encoder.TypeChaCha20Poly1305, ok: true},
+		{value: "AES256-GCM-SIV", encType: encoder.TypeAES256GCMSIV, ok: true},
+		{value: "rot13", err: repo.ErrInvalidAttribute},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.value, func(t *testing.T) {
+			encType, ok, err := repo.ParseCipherAttr(tc.value)
+			if !errors.Is(err, tc.err) {
+				t.Errorf("unexpected error. Expected: %v, received: %v", tc.err, err)
+			}
+
+			if encType != tc.encType || ok != tc.ok {
+				t.Errorf("unexpected result. Expected: %d %v, received: %d %v", tc.encType, tc.ok, encType, ok)
+			}
+		})
+	}
+}
+
+func TestParseEpochMinAttr(t *testing.T) {
+	tt := []struct {
+		value string
+		epoch uint32
+		ok    bool
+		err   error
+	}{
+		{value: "unspecified"},
+		{value: "set"},
+		{value: "4", epoch: 4, ok: true},
+		{value: "-1", err: repo.ErrInvalidAttribute},
+		{value: "4294967296", err: repo.ErrInvalidAttribute},
+		{value: "latest", err: repo.ErrInvalidAttribute},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.value, func(t *testing.T) {
+			epoch, ok, err := repo.ParseEpochMinAttr(tc.value)
+			if !errors.Is(err, tc.err) {
+				t.Errorf("unexpected error. Expected: %v, received: %v", tc.err, err)
+			}
+
+			if epoch != tc.epoch || ok != tc.ok {
+				t.Errorf("unexpected result. Expected: %d %v, received: %d %v", tc.epoch, tc.ok, epoch, ok)
+			}
+		})
+	}
+}
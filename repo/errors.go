@@ -4,5 +4,7 @@ import "errors"
 
 var (
 	ErrExchangeIsNotDir  = errors.New("key exchange is not a directory")
+	ErrInvalidAttribute  = errors.New("invalid gitattribute")
+	ErrInvalidSettings   = errors.New("invalid repo settings")
 	ErrRedactKeyNotFound = errors.New("redact key not found")
 )
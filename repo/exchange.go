@@ -2,12 +2,15 @@ package repo
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"path/filepath"
 
 	"github.com/go-git/go-billy/v5/util"
 
+	"github.com/julian7/redact/files"
 	"github.com/julian7/redact/logger"
 )
 
@@ -16,6 +19,9 @@ const (
 	ExtKeyArmor = ".asc"
 	// ExtSecret is encrypted secret key file extension in Key Exchange folder
 	ExtSecret = ".key"
+	// ExtEpochs is the file extension of epoch ranges granted to recipients
+	// in Key Exchange folder
+	ExtEpochs = ".epochs"
 	// DefaultKeyExchangeDir is where key exchange files are stored
 	GitAttributesFile       = ".gitattributes"
 	kxGitAttributesContents = `# This file has been created by redact
@@ -41,6 +47,7 @@ func (r *Repo) GetExchangeFilename(filename string, log *logger.Logger) (string,
 //
 // - .asc: Public key ASCII armor file
 // - .key: Secret key encryped with public key
+// - .epochs: Epoch range of the secret key, if limited
 func (r *Repo) GetExchangeFilenameStubFor(fingerprint []byte, log *logger.Logger) (string, error) {
 	return r.GetExchangeFilename(fmt.Sprintf("%x", fingerprint), log)
 }
@@ -138,3 +145,43 @@ func ExchangePubKeyFile(stub string) string {
 func ExchangeSecretKeyFile(stub string) string {
 	return fmt.Sprintf("%s%s", stub, ExtSecret)
 }
+
+// ExchangeEpochsFile returns full filename for the epoch range of a
+// recipient's secret key
+func ExchangeEpochsFile(stub string) string {
+	return fmt.Sprintf("%s%s", stub, ExtEpochs)
+}
+
+// LoadExchangeEpochs returns the epoch range granted to a recipient. It
+// returns all epochs, if there is no range recorded.
+func (r *Repo) LoadExchangeEpochs(stub string) (files.EpochRange, error) {
+	data, err := util.ReadFile(r.Workdir, ExchangeEpochsFile(stub))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return files.EpochRange{}, nil
+		}
+
+		return files.EpochRange{}, fmt.Errorf("reading epoch range: %w", err)
+	}
+
+	return files.ParseEpochRange(string(data))
+}
+
+// SaveExchangeEpochs records the epoch range granted to a recipient
+func (r *Repo) SaveExchangeEpochs(stub string, epochs files.EpochRange) error {
+	fileName := ExchangeEpochsFile(stub)
+
+	if epochs.All() {
+		if err := r.Workdir.Remove(fileName); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing epoch range: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := util.WriteFile(r.Workdir, fileName, []byte(epochs.String()+"\n"), 0644); err != nil {
+		return fmt.Errorf("writing epoch range: %w", err)
+	}
+
+	return nil
+}
@@ -156,3 +156,42 @@ func TestExchangeSecretKeyFile(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestExchangeEpochsFile(t *testing.T) {
+	if err := checkString("stub.epochs", repo.ExchangeEpochsFile("stub")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExchangeEpochs(t *testing.T) {
+	r, err := genGitRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub := filepath.Join(repo.DefaultKeyExchangeDir, "stub")
+
+	epochs, err := r.LoadExchangeEpochs(stub)
+	if err != nil || !epochs.All() {
+		t.Errorf("unexpected epochs without record: %v, %v", epochs, err)
+	}
+
+	for _, expected := range []files.EpochRange{{From: 3}, {From: 2, To: 2}, {}} {
+		if err := r.SaveExchangeEpochs(stub, expected); err != nil {
+			t.Fatal(err)
+		}
+
+		epochs, err := r.LoadExchangeEpochs(stub)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if epochs != expected {
+			t.Errorf("expected %v, received %v", expected, epochs)
+		}
+	}
+
+	if _, err := r.Workdir.Stat(repo.ExchangeEpochsFile(stub)); !os.IsNotExist(err) {
+		t.Errorf("epoch range of all epochs is recorded: %v", err)
+	}
+}
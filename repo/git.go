@@ -1,12 +1,19 @@
 package repo
 
 import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec
+	"errors"
 	"fmt"
+	"io/fs"
+	"strings"
 	"time"
 
+	"github.com/julian7/redact/files"
 	"github.com/julian7/redact/gitutil"
 
 	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
 )
 
 type configItem struct {
@@ -17,7 +24,8 @@ type configItem struct {
 
 var configItems = []configItem{
 	{"filter", "clean", "%q git clean --file=%%f"},
-	{"filter", "smudge", "%q git smudge"},
+	{"filter", "smudge", "%q git smudge --file=%%f"},
+	{"filter", "process", "%q git filter-process"},
 	{"diff", "textconv", "%q git diff"},
 }
 
@@ -25,14 +33,56 @@ const (
 	// AttrName defines name used in .gitattribute file's attribute
 	// like: `*.key filter=AttrName diff=AttrName`
 	AttrName = "redact"
+	// AttrBindPath is the gitattribute enabling path binding of files
+	// (like `*.key filter=redact diff=redact redact-bind-path`)
+	AttrBindPath = "redact-bind-path"
+	// ConfigBindPath is the git config option enabling path binding
+	// repository-wide
+	ConfigBindPath = "redact.bindpath"
+	// AttrPadding is the gitattribute setting padding scheme of files
+	// (like `*.key filter=redact diff=redact redact-padding=pow2`)
+	AttrPadding = "redact-padding"
+	// ConfigPadding is the git config option setting padding scheme
+	// repository-wide
+	ConfigPadding = "redact.padding"
+	// AttrCompress is the gitattribute setting compression scheme of files
+	// (like `*.json filter=redact diff=redact redact-compress`)
+	AttrCompress = "redact-compress"
+	// ConfigCompress is the git config option setting compression scheme
+	// repository-wide
+	ConfigCompress = "redact.compress"
+	// ConfigMinEpoch is the git config option raising the minimum key epoch
+	// repository-wide
+	ConfigMinEpoch = "redact.minepoch"
+	// MinEpochEnv is the environment variable raising the minimum key epoch
+	MinEpochEnv = "REDACT_MIN_EPOCH"
 	// DefaultKeyExchangeDir is where key exchange files are stored
 	DefaultKeyExchangeDir = ".redact"
 )
 
+// ExchangeDir returns the key exchange dir, which can be set in repo
+// settings
 func (r *Repo) ExchangeDir() string {
+	if r.Settings != nil && r.Settings.ExchangeDir != "" {
+		return r.Settings.ExchangeDir
+	}
+
 	return DefaultKeyExchangeDir
 }
 
+// IsExchangeFile returns whether a repository-relative path is in the key
+// exchange dir, or in the default one, which holds repo settings. These files
+// should never be encrypted.
+func (r *Repo) IsExchangeFile(name string) bool {
+	for _, dir := range []string{DefaultKeyExchangeDir, r.ExchangeDir()} {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (r *Repo) SaveGitSettings(argv0 string, cb func(string)) error {
 	for _, opt := range configItems {
 		attr := fmt.Sprintf("%s.%s.%s", opt.sect, AttrName, opt.key)
@@ -71,7 +121,7 @@ func (r *Repo) TouchFile(filePath string) error {
 	touchTime := time.Now()
 
 	if chfs, ok := r.Workdir.(billy.Change); ok {
-		if err := chfs.Chtimes(filePath, touchTime, touchTime); err != nil {
+		if err := chfs.Chtimes(filePath, touchTime, touchTime); err != nil && !errors.Is(err, fs.ErrNotExist) {
 			return fmt.Errorf("touch file: %w", err)
 		}
 	}
@@ -79,6 +129,39 @@ func (r *Repo) TouchFile(filePath string) error {
 	return nil
 }
 
+// removeEncryptedCopy removes a file from the working tree, if it's an
+// encrypted copy of its index entry (like in a fresh clone). Git considers
+// these files unchanged, as the clean filter passes encrypted contents
+// through, therefore they wouldn't be checked out otherwise.
+func (r *Repo) removeEncryptedCopy(entry *gitutil.FileEntry) error {
+	data, err := util.ReadFile(r.Workdir, entry.Name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+
+		return fmt.Errorf("reading %s: %w", entry.Name, err)
+	}
+
+	if !bytes.HasPrefix(data, []byte(files.FileMagic)) {
+		return nil
+	}
+
+	hash := sha1.New() //nolint:gosec
+	fmt.Fprintf(hash, "blob %d\000", len(data))
+	hash.Write(data)
+
+	if !bytes.Equal(hash.Sum(nil), entry.SHA1[:]) {
+		return nil
+	}
+
+	if err := r.Workdir.Remove(entry.Name); err != nil {
+		return fmt.Errorf("removing encrypted copy of %s: %w", entry.Name, err)
+	}
+
+	return nil
+}
+
 func (r *Repo) TouchUp(files []string, rekey bool, softErrHandler func(error)) error {
 	if len(files) < 1 {
 		return nil
@@ -127,6 +210,10 @@ func (r *Repo) ForceReencrypt(rekey bool, softErrHandler func(error)) error {
 
 	for _, entry := range files.Items {
 		if entry.Filter == AttrName && entry.Status != gitutil.StatusOther {
+			if err := r.removeEncryptedCopy(entry); err != nil && softErrHandler != nil {
+				softErrHandler(err)
+			}
+
 			affectedFiles = append(affectedFiles, entry.Name)
 		}
 	}
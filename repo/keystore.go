@@ -0,0 +1,113 @@
+package repo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/julian7/redact/files"
+)
+
+const (
+	// ConfigKeyStore is the git config option selecting the key store
+	ConfigKeyStore = "redact.keystore"
+	// DefaultKeyStore keeps the secret key in the git common dir
+	DefaultKeyStore = "git"
+	// DefaultKeyPEMEnv is the environment variable of the "env" key store
+	DefaultKeyPEMEnv = "REDACT_KEY_PEM"
+	// KeyFDEnv is the environment variable providing a file descriptor of
+	// an exported secret key
+	KeyFDEnv = "REDACT_KEY_FD"
+)
+
+// NewKeyStore returns a key store by its description:
+//
+//   - "git": in the git common dir (default)
+//   - "xdg": in the user's configuration directory, per repository
+//   - "env" or "env:NAME": exported key in an environment variable
+//     (REDACT_KEY_PEM by default), read-only
+//   - "fd:N": exported key read from file descriptor N, read-only
+//   - "file:PATH": at an arbitrary path
+func NewKeyStore(desc string, dot billy.Filesystem, commonDir string) (files.KeyStore, error) {
+	kind, param, hasParam := strings.Cut(strings.TrimSpace(desc), ":")
+
+	switch strings.ToLower(kind) {
+	case "git":
+		if !hasParam {
+			return files.NewGitDirStore(dot), nil
+		}
+	case "xdg":
+		if !hasParam {
+			return files.NewXDGStore(RepoID(commonDir))
+		}
+	case "env":
+		if !hasParam {
+			return files.NewEnvStore(DefaultKeyPEMEnv), nil
+		}
+
+		if param != "" {
+			return files.NewEnvStore(param), nil
+		}
+	case "fd":
+		if fd, err := strconv.ParseUint(param, 10, 31); err == nil {
+			return files.NewFDStore(uintptr(fd)), nil
+		}
+	case "file":
+		if param != "" {
+			return files.NewFileStore(param)
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q", files.ErrInvalidKeyStore, desc)
+}
+
+// EphemeralKeyStore returns the description of a key store of an exported
+// secret key from the environment (REDACT_KEY_PEM or REDACT_KEY_FD), if
+// there is any. Ephemeral key stores are read-only.
+func EphemeralKeyStore() Setting {
+	if value, ok := os.LookupEnv(DefaultKeyPEMEnv); ok && value != "" {
+		return Setting{Value: "env", Set: true}
+	}
+
+	if value, ok := os.LookupEnv(KeyFDEnv); ok && value != "" {
+		return Setting{Value: "fd:" + value, Set: true}
+	}
+
+	return Setting{}
+}
+
+// Ephemeral returns whether the secret key is read from an exported secret
+// key, which can't be written
+func (r *Repo) Ephemeral() bool {
+	if r.SecretKey == nil {
+		return false
+	}
+
+	switch r.Store().(type) {
+	case *files.EnvStore, *files.FDStore:
+		return true
+	}
+
+	return false
+}
+
+// RepoID returns a repository identity for key stores outside of the
+// repository. It is the repository's directory name, and a hash of its git
+// common dir.
+func RepoID(commonDir string) string {
+	name := filepath.Base(commonDir)
+	if name == ".git" {
+		name = filepath.Base(filepath.Dir(commonDir))
+	}
+
+	name = strings.TrimSuffix(name, ".git")
+	sum := sha256.Sum256([]byte(commonDir))
+
+	return name + "-" + hex.EncodeToString(sum[:8])
+}
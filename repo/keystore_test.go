@@ -0,0 +1,100 @@
+package repo_test
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+
+	"github.com/julian7/redact/files"
+	"github.com/julian7/redact/repo"
+)
+
+func TestNewKeyStore(t *testing.T) {
+	config := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", config)
+	t.Setenv("HOME", config)
+	t.Setenv("AppData", config)
+
+	commonDir := filepath.Join(config, "project", ".git")
+	keyFile := filepath.Join(config, "key")
+
+	tt := []struct {
+		desc     string
+		expected string
+		err      error
+	}{
+		{desc: "git", expected: "git:"},
+		{desc: "xdg", expected: "file:" + filepath.Join(config, "redact", "keys", repo.RepoID(commonDir), "key")},
+		{desc: "env", expected: "env:REDACT_KEY_PEM"},
+		{desc: "env:CI_REDACT_KEY", expected: "env:CI_REDACT_KEY"},
+		{desc: "file:" + keyFile, expected: "file:" + keyFile},
+		{desc: "fd:3", expected: "fd:3"},
+		{desc: "file:", err: files.ErrInvalidKeyStore},
+		{desc: "fd:x", err: files.ErrInvalidKeyStore},
+		{desc: "env:", err: files.ErrInvalidKeyStore},
+		{desc: "git:/tmp", err: files.ErrInvalidKeyStore},
+		{desc: "s3", err: files.ErrInvalidKeyStore},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			store, err := repo.NewKeyStore(tc.desc, memfs.New(), commonDir)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("unexpected error. Expected: %v, received: %v", tc.err, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if received := store.Describe(); !strings.HasPrefix(received, tc.expected) {
+				t.Errorf("unexpected key store. Expected: %s, received: %s", tc.expected, received)
+			}
+		})
+	}
+}
+
+func TestRepoID(t *testing.T) {
+	for dir, prefix := range map[string]string{
+		"/src/project/.git": "project-",
+		"/srv/project.git":  "project-",
+	} {
+		if id := repo.RepoID(dir); !strings.HasPrefix(id, prefix) || len(id) != len(prefix)+16 {
+			t.Errorf("unexpected repo identity of %s: %s", dir, id)
+		}
+	}
+
+	if repo.RepoID("/a/project/.git") == repo.RepoID("/b/project/.git") {
+		t.Error("repo identities of different repositories match")
+	}
+}
+
+func TestEphemeralKeyStore(t *testing.T) {
+	tt := []struct {
+		name     string
+		pem      string
+		fd       string
+		expected repo.Setting
+	}{
+		{name: "none", expected: repo.Setting{}},
+		{name: "pem", pem: "key", expected: repo.StringSetting("env")},
+		{name: "fd", fd: "3", expected: repo.StringSetting("fd:3")},
+		{name: "pem over fd", pem: "key", fd: "3", expected: repo.StringSetting("env")},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(repo.DefaultKeyPEMEnv, tc.pem)
+			t.Setenv(repo.KeyFDEnv, tc.fd)
+
+			if received := repo.EphemeralKeyStore(); received != tc.expected {
+				t.Errorf("unexpected key store. Expected: %+v, received: %+v", tc.expected, received)
+			}
+		})
+	}
+}
@@ -1,23 +1,47 @@
 package repo
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/urfave/cli/v3"
 
+	"github.com/julian7/redact/agent"
 	"github.com/julian7/redact/files"
 	"github.com/julian7/redact/gitutil"
+	"github.com/julian7/redact/securemem"
 )
 
 type Repo struct {
 	*files.SecretKey
-	Workdir                billy.Filesystem
+	Workdir billy.Filesystem
+	// CommonDir is the absolute path of the git common dir, which identifies
+	// the repository in the agent
+	CommonDir string
+	Settings  *Settings
+	// StrictPermissions is strict permission checks setting from the
+	// environment, or from command line
+	StrictPermissions      Setting
 	StrictPermissionChecks bool
+	// PassphraseFunc provides the passphrase of protected key files
+	PassphraseFunc files.PassphraseFunc
+	// ExportPassphraseFunc provides the passphrase of encrypted exported
+	// keys
+	ExportPassphraseFunc files.PassphraseFunc
+	// KeyStore is the key store setting from the environment, or from
+	// command line
+	KeyStore Setting
+	// agentServes is set by LoadKeyForUpdate, if the agent serves the key
+	agentServes bool
+	// keyFile is set by LoadKeyForUpdate, if the key store has the key
+	keyFile bool
 }
 
 func (r *Repo) SetupRepo() error {
@@ -29,24 +53,66 @@ func (r *Repo) SetupRepo() error {
 	fs := osfs.New(repo.Toplevel, osfs.WithBoundOS())
 	r.Workdir = NewOSFS(fs)
 
+	r.CommonDir, err = filepath.Abs(repo.Common)
+	if err != nil {
+		return fmt.Errorf("detecting git common dir: %w", err)
+	}
+
+	r.Settings, err = LoadSettings(r.Workdir)
+	if err != nil {
+		return err
+	}
+
+	strict := Resolve("true", r.StrictPermissions, r.Settings.StrictPermissionsSetting())
+
+	r.StrictPermissionChecks, err = strconv.ParseBool(strict)
+	if err != nil {
+		return fmt.Errorf("invalid strict permissions setting %q: %w", strict, err)
+	}
+
 	commonfs, err := fs.Chroot(repo.Common)
 	if err != nil {
 		return err
 	}
 
-	r.SecretKey, err = files.NewSecretKey(NewOSFS(commonfs))
+	configStore, ok, err := gitutil.GitConfigGet(ConfigKeyStore)
+	if err != nil {
+		return err
+	}
+
+	desc := Resolve(DefaultKeyStore, r.KeyStore, EphemeralKeyStore(), Setting{Value: configStore, Set: ok})
+
+	store, err := NewKeyStore(desc, NewOSFS(commonfs), r.CommonDir)
 	if err != nil {
 		return err
 	}
 
+	r.SecretKey = files.NewSecretKeyIn(store)
+
+	r.SecretKey.Passphrase = r.PassphraseFunc
+	r.SecretKey.ExportPassphrase = r.ExportPassphraseFunc
+
+	if r.Ephemeral() {
+		r.SecretKey.Passphrase = r.ExportPassphraseFunc
+	}
+
 	return nil
 }
 
+// LoadSecretKey loads the secret key from the agent, or from the key store,
+// if the agent doesn't have it. Ephemeral key stores take precedence over
+// the agent.
 func (r *Repo) LoadSecretKey(ctx context.Context, _ *cli.Command) (context.Context, error) {
 	if err := r.SetupRepo(); err != nil {
 		return ctx, fmt.Errorf("detecting repo config: %w", err)
 	}
 
+	if !r.Ephemeral() {
+		if ok, err := r.LoadFromAgent(); ok || err != nil {
+			return ctx, err
+		}
+	}
+
 	if err := r.Load(r.StrictPermissionChecks); err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
 			return ctx, fmt.Errorf("loading secret key: %w", err)
@@ -57,3 +123,148 @@ func (r *Repo) LoadSecretKey(ctx context.Context, _ *cli.Command) (context.Conte
 
 	return ctx, nil
 }
+
+// LoadFromAgent loads the secret key from the agent. It returns false if
+// the agent is not running, or it doesn't have the key.
+func (r *Repo) LoadFromAgent() (bool, error) {
+	data, err := agent.NewClient().Get(r.CommonDir)
+	if err != nil {
+		if errors.Is(err, agent.ErrNotRunning) || errors.Is(err, agent.ErrKeyNotFound) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("loading secret key from agent: %w", err)
+	}
+
+	if err := r.Read(bytes.NewReader(data)); err != nil {
+		return false, fmt.Errorf("loading secret key from agent: %w", err)
+	}
+
+	return true, nil
+}
+
+// LoadSecretKeyForUpdate loads the secret key for commands changing it. See
+// LoadKeyForUpdate.
+func (r *Repo) LoadSecretKeyForUpdate(ctx context.Context, _ *cli.Command) (context.Context, error) {
+	if err := r.SetupRepo(); err != nil {
+		return ctx, fmt.Errorf("detecting repo config: %w", err)
+	}
+
+	if err := r.LoadKeyForUpdate(); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return ctx, err
+		}
+
+		return ctx, ErrRedactKeyNotFound
+	}
+
+	return ctx, nil
+}
+
+// LoadKeyForUpdate loads the secret key from the key store (asking for the
+// passphrase of protected key files), and merges the key served by the agent.
+// Keys in the agent are not protected, therefore saving the agent's copy
+// would drop protection of the key file. SaveKey saves the key into both. It
+// returns os.ErrNotExist if neither has the key.
+func (r *Repo) LoadKeyForUpdate() error {
+	err := r.Load(r.StrictPermissionChecks)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("loading secret key: %w", err)
+	}
+
+	r.keyFile = err == nil
+
+	if !r.Ephemeral() {
+		r.agentServes, err = r.mergeFromAgent()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !r.keyFile && !r.agentServes {
+		return fmt.Errorf("loading secret key: %w", os.ErrNotExist)
+	}
+
+	return nil
+}
+
+// mergeFromAgent merges the secret key served by the agent. It returns false
+// if the agent is not running, or it doesn't have the key.
+func (r *Repo) mergeFromAgent() (bool, error) {
+	data, err := agent.NewClient().Get(r.CommonDir)
+	if err != nil {
+		if errors.Is(err, agent.ErrNotRunning) || errors.Is(err, agent.ErrKeyNotFound) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("loading secret key from agent: %w", err)
+	}
+
+	defer securemem.Wipe(data)
+
+	served := &files.SecretKey{}
+	if err := served.Read(bytes.NewReader(data)); err != nil {
+		return false, fmt.Errorf("loading secret key from agent: %w", err)
+	}
+
+	if _, err := r.Merge(served); err != nil {
+		served.Destroy()
+
+		return false, fmt.Errorf("merging secret key from agent: %w", err)
+	}
+
+	return true, nil
+}
+
+// AgentOnly returns whether the secret key loaded by LoadKeyForUpdate is in
+// the agent only
+func (r *Repo) AgentOnly() bool {
+	return r.agentServes && !r.keyFile
+}
+
+// SaveKey saves the secret key loaded by LoadKeyForUpdate into the key store,
+// and into the agent, if it serves the key. Keys in the agent only are not
+// saved into the key store.
+func (r *Repo) SaveKey() error {
+	if !r.AgentOnly() {
+		if err := r.Save(); err != nil {
+			return err
+		}
+	}
+
+	if r.agentServes {
+		return r.SaveToAgent()
+	}
+
+	return nil
+}
+
+// AgentServes returns whether the agent serves the secret key
+func (r *Repo) AgentServes() (bool, error) {
+	data, err := agent.NewClient().Get(r.CommonDir)
+	if err != nil {
+		if errors.Is(err, agent.ErrNotRunning) || errors.Is(err, agent.ErrKeyNotFound) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("querying key agent: %w", err)
+	}
+
+	securemem.Wipe(data)
+
+	return true, nil
+}
+
+// SaveToAgent stores the secret key in the agent
+func (r *Repo) SaveToAgent() error {
+	buf := bytes.Buffer{}
+	if err := r.SaveTo(&buf); err != nil {
+		return err
+	}
+
+	if err := agent.NewClient().Add(r.CommonDir, buf.Bytes()); err != nil {
+		return fmt.Errorf("saving secret key to agent: %w", err)
+	}
+
+	return nil
+}
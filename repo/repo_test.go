@@ -0,0 +1,116 @@
+package repo_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/julian7/redact/agent"
+	"github.com/julian7/redact/files"
+	"github.com/julian7/redact/repo"
+)
+
+func TestSaveKeyWithAgent(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv(repo.DefaultKeyPEMEnv, "")
+	t.Setenv(repo.KeyFDEnv, "")
+
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Skipf("git init: %v: %s", err, out)
+	}
+
+	sockDir, err := os.MkdirTemp("", "redact-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(sockDir) })
+
+	sock := filepath.Join(sockDir, "run", "agent.sock")
+	t.Setenv(agent.SocketEnv, sock)
+
+	listener, err := agent.Listen(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyAgent, err := agent.New(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go func() { _ = keyAgent.Serve(ctx, listener) }()
+
+	passphrase := func() ([]byte, error) { return []byte("passphrase"), nil }
+
+	original := &repo.Repo{PassphraseFunc: passphrase}
+	if err := original.SetupRepo(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := original.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := original.Protect([]byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := original.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := original.SaveToAgent(); err != nil {
+		t.Fatal(err)
+	}
+
+	noPassphrase := &repo.Repo{}
+	if err := noPassphrase.SetupRepo(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := noPassphrase.LoadKeyForUpdate(); !errors.Is(err, files.ErrPassphraseRequired) {
+		t.Errorf("unexpected error loading protected key without passphrase: %v", err)
+	}
+
+	updated := &repo.Repo{PassphraseFunc: passphrase}
+	if err := updated.SetupRepo(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := updated.LoadKeyForUpdate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := updated.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := updated.SaveKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := &repo.Repo{}
+	if err := saved.SetupRepo(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := saved.Load(false); !errors.Is(err, files.ErrPassphraseRequired) {
+		t.Errorf("key file is not protected anymore: %v", err)
+	}
+
+	if ok, err := saved.LoadFromAgent(); !ok || err != nil {
+		t.Fatalf("agent doesn't serve the key: %v", err)
+	}
+
+	if saved.LatestKey != 2 {
+		t.Errorf("agent serves a stale key: latest epoch %d", saved.LatestKey)
+	}
+}
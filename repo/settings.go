@@ -0,0 +1,246 @@
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+
+	"github.com/julian7/redact/encoder"
+	"github.com/julian7/redact/files"
+	"github.com/julian7/redact/gitutil"
+)
+
+const (
+	// SettingsFilename is the repository settings file in the default key
+	// exchange dir. It is always read from there, as it can move the rest of
+	// the key exchange to a different directory.
+	SettingsFilename = "settings.json"
+	// SettingsVersion is the settings document version this release reads
+	SettingsVersion = 1
+)
+
+// Settings are committed, repository-wide defaults and policies. Empty
+// values fall back to built-in defaults.
+//
+// Settings are resolved in this order of precedence: environment variables,
+// command line options, repository settings, and built-in defaults.
+type Settings struct {
+	// Version is the settings document version
+	Version int `json:"version"`
+	// Encoding is the default encoding type (like "chacha20-poly1305")
+	Encoding string `json:"encoding,omitempty"`
+	// MinEpoch is the minimum key epoch allowed for encryption
+	MinEpoch uint32 `json:"min_epoch,omitempty"`
+	// Padding is the default padding scheme (like "padme")
+	Padding string `json:"padding,omitempty"`
+	// Compression is the default compression scheme (like "deflate")
+	Compression string `json:"compression,omitempty"`
+	// ExchangeDir is the key exchange directory, relative to the
+	// repository's top level directory
+	ExchangeDir string `json:"exchange_dir,omitempty"`
+	// StrictPermissions enforces file permission checks of the secret key
+	StrictPermissions *bool `json:"strict_permissions,omitempty"`
+	// RetiredEpochs are key epochs removed from the secret key, which are
+	// never used for encryption
+	RetiredEpochs []uint32 `json:"retired_epochs,omitempty"`
+}
+
+// Setting is a value of a setting from one of its sources
+type Setting struct {
+	Value string
+	Set   bool
+}
+
+// StringSetting returns a setting, which is set when it's not empty
+func StringSetting(value string) Setting {
+	return Setting{Value: value, Set: value != ""}
+}
+
+// Resolve returns the value of the first set setting, or builtin if none of
+// them are set. Settings have to be provided in order of precedence.
+func Resolve(builtin string, sources ...Setting) string {
+	for _, source := range sources {
+		if source.Set {
+			return source.Value
+		}
+	}
+
+	return builtin
+}
+
+// SettingsFile returns the repository settings file's path
+func SettingsFile() string {
+	return path.Join(DefaultKeyExchangeDir, SettingsFilename)
+}
+
+// LoadSettings reads repository settings. Missing settings file results in
+// empty settings.
+func LoadSettings(workdir billy.Filesystem) (*Settings, error) {
+	settings := &Settings{Version: SettingsVersion}
+
+	reader, err := workdir.Open(SettingsFile())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return settings, nil
+		}
+
+		return nil, fmt.Errorf("opening repo settings: %w", err)
+	}
+
+	defer reader.Close()
+
+	settings.Version = 0
+
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(settings); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidSettings, SettingsFile(), err)
+	}
+
+	if err := settings.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidSettings, SettingsFile(), err)
+	}
+
+	return settings, nil
+}
+
+// SaveSettings writes repository settings
+func SaveSettings(workdir billy.Filesystem, settings *Settings) error {
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding repo settings: %w", err)
+	}
+
+	if err := workdir.MkdirAll(DefaultKeyExchangeDir, 0755); err != nil {
+		return fmt.Errorf("creating key exchange dir: %w", err)
+	}
+
+	if err := util.WriteFile(workdir, SettingsFile(), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing repo settings: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Settings) validate() error {
+	if s.Version != SettingsVersion {
+		return fmt.Errorf("unsupported version %d", s.Version)
+	}
+
+	if s.Encoding != "" {
+		if _, err := encoder.FindEncoder(s.Encoding); err != nil {
+			return fmt.Errorf("encoding %q: %w", s.Encoding, err)
+		}
+	}
+
+	if s.Padding != "" {
+		if _, err := files.ParsePadding(s.Padding); err != nil {
+			return err
+		}
+	}
+
+	if s.Compression != "" {
+		if _, err := files.ParseCompression(s.Compression); err != nil {
+			return err
+		}
+	}
+
+	if s.ExchangeDir != "" {
+		dir, err := cleanExchangeDir(s.ExchangeDir)
+		if err != nil {
+			return err
+		}
+
+		s.ExchangeDir = dir
+	}
+
+	if slices.Contains(s.RetiredEpochs, 0) {
+		return errors.New("retired epoch 0 is invalid")
+	}
+
+	return nil
+}
+
+// cleanExchangeDir normalizes a key exchange dir, which has to be inside the
+// working tree, and outside of the git directory. Backslashes are checked as
+// path separators too, as they are on Windows, and they are replaced with
+// slashes in the returned path.
+func cleanExchangeDir(dir string) (string, error) {
+	cleaned := path.Clean(strings.ReplaceAll(dir, "\\", "/"))
+	if path.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") ||
+		filepath.VolumeName(cleaned) != "" {
+		return "", fmt.Errorf("exchange dir %q is outside of the repository", dir)
+	}
+
+	if first, _, _ := strings.Cut(cleaned, "/"); strings.EqualFold(first, ".git") {
+		return "", fmt.Errorf("exchange dir %q is inside the git directory", dir)
+	}
+
+	return cleaned, nil
+}
+
+// Retired returns whether a key epoch is retired
+func (s *Settings) Retired(epoch uint32) bool {
+	return s != nil && slices.Contains(s.RetiredEpochs, epoch)
+}
+
+// Retire records a retired key epoch
+func (s *Settings) Retire(epoch uint32) {
+	if !s.Retired(epoch) {
+		s.RetiredEpochs = append(s.RetiredEpochs, epoch)
+		slices.Sort(s.RetiredEpochs)
+	}
+}
+
+// StrictPermissionsSetting returns strict permission checks setting
+func (s *Settings) StrictPermissionsSetting() Setting {
+	if s == nil || s.StrictPermissions == nil {
+		return Setting{}
+	}
+
+	return Setting{Value: strconv.FormatBool(*s.StrictPermissions), Set: true}
+}
+
+// MinEpoch returns the minimum key epoch allowed for encryption: the highest
+// of repo settings, git config, and environment variable. Local sources can
+// only raise the minimum epoch of repo settings.
+func (r *Repo) MinEpoch() (uint32, error) {
+	minEpoch := uint32(0)
+	if r.Settings != nil {
+		minEpoch = r.Settings.MinEpoch
+	}
+
+	config, _, err := gitutil.GitConfigGet(ConfigMinEpoch)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, source := range []struct{ name, value string }{
+		{ConfigMinEpoch + " config", config},
+		{MinEpochEnv, os.Getenv(MinEpochEnv)},
+	} {
+		if source.value == "" {
+			continue
+		}
+
+		epoch, err := strconv.ParseUint(source.value, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s %q", ErrInvalidSettings, source.name, source.value)
+		}
+
+		minEpoch = max(minEpoch, uint32(epoch))
+	}
+
+	return minEpoch, nil
+}
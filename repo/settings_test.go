@@ -0,0 +1,211 @@
+package repo_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/julian7/redact/repo"
+)
+
+func TestResolve(t *testing.T) {
+	env := repo.Setting{Value: "env", Set: true}
+	flag := repo.Setting{Value: "flag", Set: true}
+	settings := repo.StringSetting("settings")
+	unset := repo.Setting{}
+
+	tt := []struct {
+		name     string
+		sources  []repo.Setting
+		expected string
+	}{
+		{name: "all set", sources: []repo.Setting{env, flag, settings}, expected: "env"},
+		{name: "flag over settings", sources: []repo.Setting{unset, flag, settings}, expected: "flag"},
+		{name: "settings", sources: []repo.Setting{unset, unset, settings}, expected: "settings"},
+		{name: "built-in", sources: []repo.Setting{unset, unset, repo.StringSetting("")}, expected: "builtin"},
+		{name: "set to empty", sources: []repo.Setting{{Value: "", Set: true}, flag}, expected: ""},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if err := checkString(tc.expected, repo.Resolve("builtin", tc.sources...)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestLoadSettings(t *testing.T) { //nolint:funlen
+	strict := false
+
+	tt := []struct {
+		name     string
+		contents string
+		expected *repo.Settings
+		err      error
+	}{
+		{
+			name:     "missing",
+			expected: &repo.Settings{Version: repo.SettingsVersion},
+		},
+		{
+			name: "full",
+			contents: `{
+				"version": 1,
+				"encoding": "ChaCha20-Poly1305",
+				"min_epoch": 3,
+				"padding": "padme",
+				"compression": "deflate:4096",
+				"exchange_dir": "secrets/kx/",
+				"strict_permissions": false,
+				"retired_epochs": [1, 2]
+			}`,
+			expected: &repo.Settings{
+				Version:           1,
+				Encoding:          "ChaCha20-Poly1305",
+				MinEpoch:          3,
+				Padding:           "padme",
+				Compression:       "deflate:4096",
+				ExchangeDir:       "secrets/kx",
+				StrictPermissions: &strict,
+				RetiredEpochs:     []uint32{1, 2},
+			},
+		},
+		{
+			name:     "exchange dir with backslash",
+			contents: `{"version": 1, "exchange_dir": "secrets\\kx\\"}`,
+			expected: &repo.Settings{Version: 1, ExchangeDir: "secrets/kx"},
+		},
+		{name: "no version", contents: `{"encoding": "aes256-gcm96"}`, err: repo.ErrInvalidSettings},
+		{name: "newer version", contents: `{"version": 2}`, err: repo.ErrInvalidSettings},
+		{name: "unknown field", contents: `{"version": 1, "cipher": "aes"}`, err: repo.ErrInvalidSettings},
+		{name: "unknown encoding", contents: `{"version": 1, "encoding": "rot13"}`, err: repo.ErrInvalidSettings},
+		{name: "invalid padding", contents: `{"version": 1, "padding": "block:0"}`, err: repo.ErrInvalidSettings},
+		{name: "invalid compression", contents: `{"version": 1, "compression": "lzma"}`, err: repo.ErrInvalidSettings},
+		{name: "exchange dir outside", contents: `{"version": 1, "exchange_dir": "../kx"}`, err: repo.ErrInvalidSettings},
+		{name: "absolute exchange dir", contents: `{"version": 1, "exchange_dir": "/kx"}`, err: repo.ErrInvalidSettings},
+		{name: "exchange dir escaping", contents: `{"version": 1, "exchange_dir": "kx/../../kx"}`, err: repo.ErrInvalidSettings},
+		{name: "top level exchange dir", contents: `{"version": 1, "exchange_dir": "kx/.."}`, err: repo.ErrInvalidSettings},
+		{name: "git dir", contents: `{"version": 1, "exchange_dir": ".git"}`, err: repo.ErrInvalidSettings},
+		{name: "inside git dir", contents: `{"version": 1, "exchange_dir": ".git/kx"}`, err: repo.ErrInvalidSettings},
+		{name: "git dir in disguise", contents: `{"version": 1, "exchange_dir": "kx/../.GIT/redact"}`, err: repo.ErrInvalidSettings},
+		{name: "git dir with backslash", contents: `{"version": 1, "exchange_dir": ".git\\kx"}`, err: repo.ErrInvalidSettings},
+		{name: "retired epoch 0", contents: `{"version": 1, "retired_epochs": [0]}`, err: repo.ErrInvalidSettings},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := genGitRepo()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.contents != "" {
+				if err := writeFile(r, repo.SettingsFile(), 0644, tc.contents); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			settings, err := repo.LoadSettings(r.Workdir)
+			if !errors.Is(err, tc.err) {
+				t.Errorf("unexpected error. Expected: %v, received: %v", tc.err, err)
+			}
+
+			if !reflect.DeepEqual(settings, tc.expected) {
+				t.Errorf("unexpected settings. Expected: %+v, received: %+v", tc.expected, settings)
+			}
+		})
+	}
+}
+
+func TestSaveSettings(t *testing.T) {
+	r, err := genGitRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := &repo.Settings{Version: repo.SettingsVersion, MinEpoch: 2}
+	settings.Retire(3)
+	settings.Retire(1)
+	settings.Retire(3)
+
+	if !settings.Retired(1) || settings.Retired(2) {
+		t.Errorf("unexpected retired epochs: %v", settings.RetiredEpochs)
+	}
+
+	if err := repo.SaveSettings(r.Workdir, settings); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := repo.LoadSettings(r.Workdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(loaded, settings) {
+		t.Errorf("unexpected settings. Expected: %+v, received: %+v", settings, loaded)
+	}
+}
+
+func TestExchangeDir(t *testing.T) {
+	r, err := genGitRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkString(repo.DefaultKeyExchangeDir, r.ExchangeDir()); err != nil {
+		t.Error(err)
+	}
+
+	r.Settings = &repo.Settings{Version: repo.SettingsVersion, ExchangeDir: "secrets/kx"}
+
+	if err := checkString("secrets/kx", r.ExchangeDir()); err != nil {
+		t.Error(err)
+	}
+
+	for name, expected := range map[string]bool{
+		"secrets/kx/key.asc":    true,
+		".redact/settings.json": true,
+		"secrets/kx.key":        false,
+		"secrets/other/key.asc": false,
+		".redact.json":          false,
+	} {
+		if received := r.IsExchangeFile(name); received != expected {
+			t.Errorf("unexpected result of %s. Expected: %v, received: %v", name, expected, received)
+		}
+	}
+}
+
+func TestMinEpoch(t *testing.T) {
+	tt := []struct {
+		name     string
+		env      string
+		expected uint32
+		err      error
+	}{
+		{name: "settings", expected: 2},
+		{name: "raised", env: "3", expected: 3},
+		{name: "not lowered", env: "1", expected: 2},
+		{name: "invalid", env: "three", err: repo.ErrInvalidSettings},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(repo.MinEpochEnv, tc.env)
+
+			r := &repo.Repo{Settings: &repo.Settings{Version: repo.SettingsVersion, MinEpoch: 2}}
+
+			received, err := r.MinEpoch()
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if received != tc.expected {
+				t.Errorf("expected %d, received %d", tc.expected, received)
+			}
+		})
+	}
+}
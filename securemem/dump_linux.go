@@ -0,0 +1,10 @@
+//go:build linux
+
+package securemem
+
+import "golang.org/x/sys/unix"
+
+// excludeFromDump excludes memory from core dumps
+func excludeFromDump(mem []byte) {
+	_ = unix.Madvise(mem, unix.MADV_DONTDUMP)
+}
@@ -0,0 +1,6 @@
+//go:build !linux && !windows
+
+package securemem
+
+// excludeFromDump is a no-op on platforms without MADV_DONTDUMP
+func excludeFromDump(_ []byte) {}
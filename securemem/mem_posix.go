@@ -0,0 +1,39 @@
+//go:build !windows
+
+package securemem
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// allocate maps anonymous memory of at least size bytes outside of the Go
+// heap, and tries to lock it into RAM. It falls back to heap memory if
+// mapping fails.
+func allocate(size int) (mem []byte, mapped, locked bool) {
+	pagesize := os.Getpagesize()
+	length := (size + pagesize - 1) / pagesize * pagesize
+
+	mem, err := unix.Mmap(-1, 0, length, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return make([]byte, size), false, false
+	}
+
+	excludeFromDump(mem)
+
+	return mem, true, unix.Mlock(mem) == nil
+}
+
+// release unlocks and unmaps memory allocated by allocate
+func release(mem []byte, mapped, locked bool) {
+	if !mapped {
+		return
+	}
+
+	if locked {
+		_ = unix.Munlock(mem)
+	}
+
+	_ = unix.Munmap(mem)
+}
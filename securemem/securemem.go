@@ -0,0 +1,177 @@
+// Package securemem provides buffers for secret data. Buffers are kept out of
+// swap and core dumps where the platform allows it, and they are wiped when
+// they are released.
+package securemem
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"sync/atomic"
+)
+
+// minRead is the minimum free space for reading into a buffer
+const minRead = 512
+
+// outstanding is the number of buffers holding memory, which haven't been
+// destroyed
+var outstanding atomic.Int64
+
+// Buffer is a guarded memory region for secret data. Its memory is locked
+// into RAM and excluded from core dumps on a best effort basis: if the
+// platform or resource limits don't allow it, it falls back to regular
+// memory, which is still wiped on release.
+//
+// A Buffer is not safe for concurrent use.
+type Buffer struct {
+	mem    []byte
+	size   int
+	mapped bool
+	locked bool
+}
+
+// New returns a zeroed buffer of size bytes
+func New(size int) *Buffer {
+	buf := &Buffer{}
+	buf.grow(size)
+	buf.size = size
+
+	return buf
+}
+
+// FromBytes returns a buffer holding a copy of src. Src is wiped afterwards.
+func FromBytes(src []byte) *Buffer {
+	buf := New(len(src))
+	copy(buf.Bytes(), src)
+	Wipe(src)
+
+	return buf
+}
+
+// Bytes returns the contents of the buffer. The slice is valid until the
+// buffer is grown, or destroyed.
+func (b *Buffer) Bytes() []byte {
+	if b == nil || b.mem == nil {
+		return nil
+	}
+
+	return b.mem[:b.size]
+}
+
+// Len returns the size of the buffer's contents
+func (b *Buffer) Len() int {
+	if b == nil {
+		return 0
+	}
+
+	return b.size
+}
+
+// Locked returns whether the buffer's memory is locked into RAM
+func (b *Buffer) Locked() bool {
+	return b != nil && b.locked
+}
+
+// Write appends data to the buffer. When the buffer has to grow, its former
+// memory is wiped and released.
+func (b *Buffer) Write(data []byte) (int, error) {
+	if b.size+len(data) > len(b.mem) {
+		b.grow(max(2*len(b.mem), b.size+len(data)))
+	}
+
+	copy(b.mem[b.size:], data)
+	b.size += len(data)
+
+	return len(data), nil
+}
+
+// ReadFrom reads data from reader until EOF, and appends it to the buffer.
+// Data is read into guarded memory directly.
+func (b *Buffer) ReadFrom(reader io.Reader) (int64, error) {
+	var total int64
+
+	for {
+		if b.size == len(b.mem) {
+			b.grow(max(2*len(b.mem), b.size+minRead))
+		}
+
+		n, err := reader.Read(b.mem[b.size:])
+		b.size += n
+		total += int64(n)
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return total, nil
+			}
+
+			return total, err
+		}
+	}
+}
+
+// Reset wipes the buffer's contents, and truncates it to zero size. It keeps
+// its memory for reuse.
+func (b *Buffer) Reset() {
+	Wipe(b.mem)
+	b.size = 0
+}
+
+// Destroy wipes and releases the buffer's memory. The buffer is empty
+// afterwards, but it can be reused.
+//
+// Buffers have to be destroyed explicitly. The garbage collector releases
+// buffers left behind, but only at an unspecified time.
+func (b *Buffer) Destroy() {
+	if b == nil || b.mem == nil {
+		return
+	}
+
+	runtime.SetFinalizer(b, nil)
+	b.free()
+	outstanding.Add(-1)
+}
+
+func (b *Buffer) free() {
+	Wipe(b.mem)
+	release(b.mem, b.mapped, b.locked)
+
+	b.mem = nil
+	b.size = 0
+	b.mapped = false
+	b.locked = false
+}
+
+func (b *Buffer) grow(size int) {
+	if size == 0 {
+		return
+	}
+
+	mem, mapped, locked := allocate(size)
+
+	if b.mem != nil {
+		copy(mem, b.mem[:b.size])
+		Wipe(b.mem)
+		release(b.mem, b.mapped, b.locked)
+	} else {
+		outstanding.Add(1)
+		runtime.SetFinalizer(b, (*Buffer).free)
+	}
+
+	b.mem = mem
+	b.mapped = mapped
+	b.locked = locked
+}
+
+// Outstanding returns the number of buffers holding memory, which haven't
+// been destroyed. Buffers released by the garbage collector are counted too,
+// as they kept secrets in memory longer than necessary. It is useful for
+// finding buffers not destroyed.
+func Outstanding() int {
+	return int(outstanding.Load())
+}
+
+// Wipe overwrites data with zeroes
+func Wipe(data []byte) {
+	clear(data)
+	runtime.KeepAlive(data)
+}
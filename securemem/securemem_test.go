@@ -0,0 +1,166 @@
+package securemem
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func zeroed(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestNew(t *testing.T) {
+	tt := []struct {
+		name string
+		size int
+	}{
+		{name: "empty", size: 0},
+		{name: "key", size: 96},
+		{name: "multiple pages", size: 3*4096 + 1},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			buf := New(tc.size)
+			defer buf.Destroy()
+
+			if buf.Len() != tc.size || len(buf.Bytes()) != tc.size {
+				t.Errorf("invalid size\nExpected: %d\nReceived: %d (%d)", tc.size, buf.Len(), len(buf.Bytes()))
+			}
+
+			if !zeroed(buf.Bytes()) {
+				t.Error("new buffer is not zeroed")
+			}
+		})
+	}
+}
+
+func TestFromBytes(t *testing.T) {
+	src := []byte("secret data")
+
+	buf := FromBytes(src)
+	defer buf.Destroy()
+
+	if !bytes.Equal(buf.Bytes(), []byte("secret data")) {
+		t.Errorf("invalid contents: %q", buf.Bytes())
+	}
+
+	if !zeroed(src) {
+		t.Errorf("source is not wiped: %q", src)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	buf := New(0)
+	defer buf.Destroy()
+
+	expected := []byte{}
+
+	for i := range 100 {
+		chunk := bytes.Repeat([]byte{byte(i + 1)}, 97)
+		expected = append(expected, chunk...)
+
+		if n, err := buf.Write(chunk); err != nil || n != len(chunk) {
+			t.Fatalf("write #%d: %d, %v", i, n, err)
+		}
+	}
+
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Error("invalid contents after writes")
+	}
+}
+
+func TestReset(t *testing.T) {
+	buf := New(0)
+	defer buf.Destroy()
+
+	_, _ = buf.Write([]byte("secret data"))
+	contents := buf.Bytes()
+
+	buf.Reset()
+
+	if buf.Len() != 0 {
+		t.Errorf("buffer is not empty: %d", buf.Len())
+	}
+
+	if !zeroed(contents) {
+		t.Errorf("buffer is not wiped: %q", contents)
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	before := Outstanding()
+
+	buf := FromBytes([]byte("secret data"))
+	if Outstanding() != before+1 {
+		t.Errorf("outstanding buffers\nExpected: %d\nReceived: %d", before+1, Outstanding())
+	}
+
+	buf.Destroy()
+	buf.Destroy()
+
+	if Outstanding() != before {
+		t.Errorf("outstanding buffers\nExpected: %d\nReceived: %d", before, Outstanding())
+	}
+
+	if buf.Bytes() != nil || buf.Len() != 0 || buf.Locked() {
+		t.Error("destroyed buffer is not empty")
+	}
+
+	_, _ = buf.Write([]byte("reused"))
+	defer buf.Destroy()
+
+	if string(buf.Bytes()) != "reused" {
+		t.Errorf("invalid contents of reused buffer: %q", buf.Bytes())
+	}
+}
+
+func TestDestroyWipes(t *testing.T) {
+	// mapped memory is not accessible after release, so heap memory is
+	// checked instead
+	mem := []byte("secret data")
+	outstanding.Add(1)
+
+	buf := &Buffer{mem: mem, size: len(mem)}
+	buf.Destroy()
+
+	if !zeroed(mem) {
+		t.Errorf("memory is not wiped: %q", mem)
+	}
+}
+
+func TestWipe(t *testing.T) {
+	data := []byte("secret data")
+	Wipe(data)
+
+	if !zeroed(data) {
+		t.Errorf("data is not wiped: %q", data)
+	}
+}
+
+func TestReadFrom(t *testing.T) {
+	expected := bytes.Repeat([]byte("secret data"), 1000)
+
+	buf := New(0)
+	defer buf.Destroy()
+
+	// a reader without WriteTo, which makes io.Copy use ReadFrom
+	reader := io.LimitReader(bytes.NewReader(expected), int64(len(expected)))
+
+	n, err := io.Copy(buf, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != int64(len(expected)) || !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("invalid contents after reading %d bytes", n)
+	}
+}